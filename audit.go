@@ -0,0 +1,425 @@
+package main
+
+// audit module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The audit module keeps append-only log of all write actions performed via
+// frontend, e.g. amend, publish, template creation, update and deletion,
+// sync creation and deletion, notes, aux data and metadata submissions and
+// token revocation. Each entry records who performed the action, when, on
+// which DID, request ID and hashes of payload before and after the action.
+// Entries are written as JSON lines into Frontend.AuditDir area (by default
+// audit sub-directory of Frontend.StorageDir) and files are rotated once
+// they reach Frontend.AuditMaxSize. Admins can query the log via /audit
+// endpoint.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader defines HTTP header used to pass request ID
+const RequestIDHeader = "X-Request-ID"
+
+// AuditEntry represents single audit log entry
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id"`
+	User        string    `json:"user"`
+	Action      string    `json:"action"`
+	Path        string    `json:"path"`
+	Impersonate string    `json:"impersonate,omitempty"`
+	Did         string    `json:"did"`
+	IP          string    `json:"ip"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	BeforeHash  string    `json:"before_hash,omitempty"`
+	AfterHash   string    `json:"after_hash,omitempty"`
+}
+
+// AuditFilter represents filter of audit log entries
+type AuditFilter struct {
+	User   string
+	Did    string // did prefix
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Match checks if given entry matches the filter
+func (f *AuditFilter) Match(e AuditEntry) bool {
+	if f.User != "" && e.User != f.User {
+		return false
+	}
+	if f.Did != "" && !strings.HasPrefix(e.Did, f.Did) {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog represents append-only audit log stored as rotating JSONL files
+type AuditLog struct {
+	Dir     string // audit log area
+	MaxSize int64  // max size of audit file before rotation
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+}
+
+// audit log file names
+const (
+	auditFileName   = "audit.jsonl"
+	auditFilePrefix = "audit-"
+)
+
+// _auditLog holds frontend audit log
+var _auditLog *AuditLog
+
+// helper function to initialize audit log from frontend configuration
+func initAuditLog() {
+	dir := _config.AuditDir
+	if dir == "" {
+		dir = filepath.Join(storageDir(), "audit")
+	}
+	maxSize := _config.AuditMaxSize
+	if maxSize <= 0 {
+		maxSize = 100 * 1024 * 1024
+	}
+	_auditLog = &AuditLog{Dir: dir, MaxSize: maxSize}
+}
+
+// helper function to open current audit file, it should be called with acquired lock
+func (a *AuditLog) open() error {
+	if a.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(a.Dir, 0700); err != nil {
+		return fmt.Errorf("[Frontend.main.AuditLog.open] os.MkdirAll error: %w", err)
+	}
+	fname := filepath.Join(a.Dir, auditFileName)
+	file, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.AuditLog.open] os.OpenFile error: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("[Frontend.main.AuditLog.open] file.Stat error: %w", err)
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// helper function to rotate current audit file, it should be called with acquired lock
+func (a *AuditLog) rotate() error {
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	tstamp := time.Now().UTC().Format("20060102T150405.000000000")
+	src := filepath.Join(a.Dir, auditFileName)
+	dst := filepath.Join(a.Dir, auditFilePrefix+tstamp+".jsonl")
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("[Frontend.main.AuditLog.rotate] os.Rename error: %w", err)
+	}
+	return a.open()
+}
+
+// Write appends given entry to audit log
+func (a *AuditLog) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.AuditLog.Write] json.Marshal error: %w", err)
+	}
+	data = append(data, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.open(); err != nil {
+		return err
+	}
+	if a.size > 0 && a.size+int64(len(data)) > a.MaxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.AuditLog.Write] file.Write error: %w", err)
+	}
+	return nil
+}
+
+// Files returns list of audit files ordered from oldest to newest
+func (a *AuditLog) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(a.Dir, auditFilePrefix+"*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.AuditLog.Files] filepath.Glob error: %w", err)
+	}
+	// rotated files carry timestamp in their names
+	sort.Strings(files)
+	fname := filepath.Join(a.Dir, auditFileName)
+	if _, err := os.Stat(fname); err == nil {
+		files = append(files, fname)
+	}
+	return files, nil
+}
+
+// helper function to get rotation time of rotated audit file
+func auditRotationTime(fname string) (time.Time, bool) {
+	tstamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fname), auditFilePrefix), ".jsonl")
+	t, err := time.Parse("20060102T150405.000000000", tstamp)
+	return t, err == nil
+}
+
+// helper function to scan audit file line by line and return at most limit
+// most recent entries matching given filter, most recent entries come first
+func scanAuditFile(fname string, filter AuditFilter, limit int) ([]AuditEntry, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.scanAuditFile] os.Open error: %w", err)
+	}
+	defer file.Close()
+	// matching entries are kept in ring buffer of limit size
+	var entries []AuditEntry
+	var pos int
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("WARNING: skip malformed audit entry in %s, error %v", fname, err)
+			continue
+		}
+		if !filter.Match(entry) {
+			continue
+		}
+		if limit <= 0 || len(entries) < limit {
+			entries = append(entries, entry)
+		} else {
+			entries[pos] = entry
+			pos = (pos + 1) % limit
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("[Frontend.main.scanAuditFile] scanner error: %w", err)
+	}
+	entries = append(entries[pos:], entries[:pos]...)
+	// reverse entries to have most recent first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// Search returns audit entries matching given filter, most recent entries come first
+func (a *AuditLog) Search(filter AuditFilter) ([]AuditEntry, error) {
+	files, err := a.Files()
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	// scan files from newest to oldest until we collect enough entries
+	for i := len(files) - 1; i >= 0; i-- {
+		fname := files[i]
+		// rotated file only has entries written before its rotation
+		if t, ok := auditRotationTime(fname); ok && !filter.Since.IsZero() && t.Before(filter.Since) {
+			break
+		}
+		limit := 0
+		if filter.Limit > 0 {
+			limit = filter.Limit - len(entries)
+		}
+		records, err := scanAuditFile(fname, filter, limit)
+		if err != nil {
+			return entries, fmt.Errorf("[Frontend.main.AuditLog.Search] error: %w", err)
+		}
+		entries = append(entries, records...)
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// helper function to obtain request ID, we either use one provided by the
+// client or generate new one and return it back to the client
+func requestID(c *gin.Context) string {
+	if rid := c.GetString("request_id"); rid != "" {
+		return rid
+	}
+	rid := c.GetHeader(RequestIDHeader)
+	if rid == "" {
+		rid = randomString()
+	}
+	c.Set("request_id", rid)
+	c.Header(RequestIDHeader, rid)
+	return rid
+}
+
+// helper function to compute hash of given payload
+func payloadHash(payload any) string {
+	var data []byte
+	switch v := payload.(type) {
+	case nil:
+		return ""
+	case map[string]any:
+		if v == nil {
+			return ""
+		}
+		data, _ = json.Marshal(v)
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			log.Println("WARNING: unable to marshal audit payload", err)
+			return ""
+		}
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to return audit action of metadata submission
+func metadataAction(update bool) string {
+	if update {
+		return "metadata_update"
+	}
+	return "metadata_insert"
+}
+
+// helper function to convert failed service response into error
+func serviceError(sresp services.ServiceResponse) error {
+	if sresp.SrvCode != 0 || sresp.HttpCode != http.StatusOK {
+		return fmt.Errorf("service response status=%s http code=%d srv code=%d",
+			sresp.Status, sresp.HttpCode, sresp.SrvCode)
+	}
+	return nil
+}
+
+// helper function to record write action in audit log
+func audit(c *gin.Context, action, did string, before, after any, err error) {
+	user, _ := getUser(c)
+	entry := AuditEntry{
+		Time:        time.Now(),
+		RequestID:   requestID(c),
+		User:        user,
+		Action:      action,
+		Path:        c.Request.URL.Path,
+		Impersonate: impersonatedUser(c),
+		Did:         did,
+		IP:          c.ClientIP(),
+		Status:      "ok",
+		BeforeHash:  payloadHash(before),
+		AfterHash:   payloadHash(after),
+	}
+	if err != nil {
+		entry.Status = "error"
+		entry.Error = err.Error()
+	}
+	if _auditLog == nil {
+		log.Printf("WARNING: audit log is not initialized, entry %+v", entry)
+		return
+	}
+	if err := _auditLog.Write(entry); err != nil {
+		log.Printf("ERROR: unable to write audit entry %+v, error %v", entry, err)
+	}
+}
+
+// helper function to parse time value provided either as RFC3339 or unix seconds
+func parseAuditTime(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", val)
+}
+
+// AuditHandler provides access to GET /audit endpoint
+func AuditHandler(c *gin.Context) {
+	if _auditLog == nil {
+		handleError(c, http.StatusServiceUnavailable, "audit log is not available", errors.New("audit log is not initialized"))
+		return
+	}
+	filter := AuditFilter{
+		User:   c.Query("user"),
+		Did:    c.Query("did"),
+		Action: c.Query("action"),
+		Limit:  100,
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(c.Query("since")); err != nil {
+		handleError(c, http.StatusBadRequest, "unable to parse since parameter", err)
+		return
+	}
+	if filter.Until, err = parseAuditTime(c.Query("until")); err != nil {
+		handleError(c, http.StatusBadRequest, "unable to parse until parameter", err)
+		return
+	}
+	if val := c.Query("limit"); val != "" {
+		if filter.Limit, err = strconv.Atoi(val); err != nil {
+			handleError(c, http.StatusBadRequest, "unable to parse limit parameter", err)
+			return
+		}
+	}
+	entries, err := _auditLog.Search(filter)
+	if err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to search audit log", err)
+		return
+	}
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Audit")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["Entries"] = entries
+	tmpl["User"] = filter.User
+	tmpl["Did"] = filter.Did
+	tmpl["Action"] = filter.Action
+	tmpl["Since"] = c.Query("since")
+	tmpl["Until"] = c.Query("until")
+	content := server.TmplPage(StaticFs, "audit.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
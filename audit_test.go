@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAuditLog tests audit log rotation and search
+func TestAuditLog(t *testing.T) {
+	alog := &AuditLog{Dir: t.TempDir(), MaxSize: 300}
+	now := time.Now()
+	entries := []AuditEntry{
+		{Time: now.Add(-3 * time.Hour), User: "alice", Action: "amend", Did: "/beamline=3a/btr=btr1/cycle=1"},
+		{Time: now.Add(-2 * time.Hour), User: "bob", Action: "publish", Did: "/beamline=3a/btr=btr2/cycle=1"},
+		{Time: now.Add(-1 * time.Hour), User: "alice", Action: "notes", Did: "/beamline=3b/btr=btr1/cycle=2"},
+	}
+	for _, e := range entries {
+		e.AfterHash = payloadHash(map[string]any{"did": e.Did})
+		if err := alog.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, err := alog.Files(); err != nil || len(files) < 2 {
+		t.Fatalf("expected rotated audit files, got %v error %v", files, err)
+	}
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		expected []string // expected actions
+	}{
+		{name: "All entries", filter: AuditFilter{}, expected: []string{"notes", "publish", "amend"}},
+		{name: "User", filter: AuditFilter{User: "alice"}, expected: []string{"notes", "amend"}},
+		{name: "Did prefix", filter: AuditFilter{Did: "/beamline=3a"}, expected: []string{"publish", "amend"}},
+		{name: "Action", filter: AuditFilter{Action: "publish"}, expected: []string{"publish"}},
+		{name: "Time range", filter: AuditFilter{Since: now.Add(-150 * time.Minute), Until: now.Add(-90 * time.Minute)}, expected: []string{"publish"}},
+		{name: "Limit", filter: AuditFilter{Limit: 1}, expected: []string{"notes"}},
+		{name: "Limit across files", filter: AuditFilter{Limit: 2}, expected: []string{"notes", "publish"}},
+		{name: "Limit of user", filter: AuditFilter{User: "alice", Limit: 1}, expected: []string{"notes"}},
+		{name: "Since", filter: AuditFilter{Since: now.Add(-90 * time.Minute)}, expected: []string{"notes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := alog.Search(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, r := range records {
+				actions = append(actions, r.Action)
+			}
+			if len(actions) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, actions)
+			}
+			for i := range actions {
+				if actions[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, actions)
+				}
+			}
+		})
+	}
+}
+
+// TestAuditRotationTime tests rotated files older than since filter are skipped
+func TestAuditRotationTime(t *testing.T) {
+	alog := &AuditLog{Dir: t.TempDir(), MaxSize: 1}
+	old := time.Now().Add(-time.Hour)
+	for _, action := range []string{"amend", "publish"} {
+		if err := alog.Write(AuditEntry{Time: old, Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := alog.Files()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected rotated audit file, got %v error %v", files, err)
+	}
+	if _, ok := auditRotationTime(files[0]); !ok {
+		t.Errorf("unable to get rotation time of %s", files[0])
+	}
+	if _, ok := auditRotationTime(files[1]); ok {
+		t.Errorf("current audit file %s should not have rotation time", files[1])
+	}
+	// rotated file is skipped since it was rotated before since time
+	records, err := alog.Search(AuditFilter{Since: time.Now().Add(time.Minute)})
+	if err != nil || len(records) != 0 {
+		t.Errorf("expected no entries, got %v error %v", records, err)
+	}
+}
+
+// TestAuditTokenRevoke tests that token revocation is recorded in audit log
+func TestAuditTokenRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	auditLog := _auditLog
+	defer func() { _auditLog = auditLog }()
+	_auditLog = &AuditLog{Dir: t.TempDir(), MaxSize: 1024 * 1024}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("DELETE", "/tokens/unknown", nil)
+	c.Params = gin.Params{{Key: "id", Value: "unknown"}}
+	c.Set("user", "alice")
+	TokenRevokeHandler(c)
+	records, err := _auditLog.Search(AuditFilter{Action: "token_revoke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].User != "alice" || records[0].Status != "error" {
+		t.Errorf("unexpected audit entries %+v", records)
+	}
+}
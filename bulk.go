@@ -0,0 +1,362 @@
+package main
+
+// bulk module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The bulk module provides POST /bulk endpoint which applies single action
+// to list of DIDs selected in datasets table: add a note, amend record
+// fields, export records, create sync requests or add DIDs to collection of
+// the user. Every DID is checked against user's BTRs (and token
+// restrictions) before the action is applied and it gets its own result
+// entry, i.e. failure of one DID does not stop processing of others.
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// bulkActions lists supported bulk actions
+var bulkActions = []string{"note", "amend", "export", "sync", "collection"}
+
+// bulkProtectedKeys lists record keys which can't be changed by bulk amendment
+var bulkProtectedKeys = []string{"did", "_id", "schema", "btr", "user"}
+
+// ErrBulkDenied is returned for DIDs which are not accessible to the user
+var ErrBulkDenied = errors.New("record does not exist or it is not accessible with user BTRs")
+
+// bulk result statuses
+const (
+	bulkOk     = "ok"
+	bulkDenied = "denied"
+	bulkFailed = "failed"
+)
+
+// BulkSync represents parameters of sync requests created by bulk action
+type BulkSync struct {
+	SourceUrl   string `json:"source_url"`
+	SourceToken string `json:"source_token"`
+	TargetUrl   string `json:"target_url"`
+	TargetToken string `json:"target_token"`
+	Continuous  bool   `json:"continuous"`
+}
+
+// BulkRequest represents bulk action request
+type BulkRequest struct {
+	Action     string         `json:"action"`
+	Dids       []string       `json:"dids"`
+	Note       string         `json:"note,omitempty"`       // text of note action
+	Amend      map[string]any `json:"amend,omitempty"`      // fields of amend action
+	Format     string         `json:"format,omitempty"`     // format of export action
+	Attrs      []string       `json:"attrs,omitempty"`      // attributes of export action
+	Collection string         `json:"collection,omitempty"` // name of collection action
+	Sync       BulkSync       `json:"sync"`
+}
+
+// BulkResult represents result of bulk action for single DID
+type BulkResult struct {
+	Did    string `json:"did"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResponse represents results of bulk action
+type BulkResponse struct {
+	Action    string       `json:"action"`
+	Succeeded int          `json:"succeeded"`
+	Denied    int          `json:"denied"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// helper function to add result of single DID to bulk response
+func (r *BulkResponse) add(did string, err error) {
+	res := BulkResult{Did: did, Status: bulkOk}
+	switch {
+	case errors.Is(err, ErrBulkDenied):
+		res.Status = bulkDenied
+		res.Error = err.Error()
+		r.Denied++
+	case err != nil:
+		res.Status = bulkFailed
+		res.Error = err.Error()
+		r.Failed++
+	default:
+		r.Succeeded++
+	}
+	r.Results = append(r.Results, res)
+}
+
+// helper function to parse bulk request either from JSON payload or HTML form
+func bulkRequest(c *gin.Context) (BulkRequest, error) {
+	var req BulkRequest
+	if strings.Contains(c.ContentType(), "json") {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return req, fmt.Errorf("[Frontend.main.bulkRequest] c.ShouldBindJSON error: %w", err)
+		}
+	} else {
+		r := c.Request
+		if err := r.ParseForm(); err != nil {
+			return req, fmt.Errorf("[Frontend.main.bulkRequest] r.ParseForm error: %w", err)
+		}
+		req.Action = r.FormValue("action")
+		req.Dids = r.PostForm["did"]
+		req.Note = r.FormValue("note")
+		req.Format = r.FormValue("format")
+		if attrs := r.FormValue("attrs"); attrs != "" {
+			req.Attrs = strings.Split(attrs, ",")
+		}
+		req.Collection = r.FormValue("collection")
+		if amend := r.FormValue("amend"); amend != "" {
+			if err := json.Unmarshal([]byte(amend), &req.Amend); err != nil {
+				return req, fmt.Errorf("[Frontend.main.bulkRequest] json.Unmarshal error: %w", err)
+			}
+		}
+		req.Sync = BulkSync{
+			SourceUrl:   r.FormValue("source_url"),
+			SourceToken: r.FormValue("source_token"),
+			TargetUrl:   r.FormValue("target_url"),
+			TargetToken: r.FormValue("target_token"),
+			Continuous:  r.FormValue("continuous") != "",
+		}
+	}
+	return req, validateBulkRequest(&req)
+}
+
+// helper function to validate bulk request, it also removes empty and
+// duplicate DIDs
+func validateBulkRequest(req *BulkRequest) error {
+	if !utils.InList(req.Action, bulkActions) {
+		return fmt.Errorf("unsupported bulk action %q, supported actions: %s", req.Action, strings.Join(bulkActions, ", "))
+	}
+	var dids []string
+	for _, did := range req.Dids {
+		if did = strings.TrimSpace(did); did != "" && !utils.InList(did, dids) {
+			dids = append(dids, did)
+		}
+	}
+	req.Dids = dids
+	if len(dids) == 0 {
+		return errors.New("no DIDs are selected")
+	}
+	maxDids := _config.BulkMaxDids
+	if maxDids <= 0 {
+		maxDids = 500
+	}
+	if len(dids) > maxDids {
+		return fmt.Errorf("too many DIDs %d, bulk actions are limited to %d DIDs", len(dids), maxDids)
+	}
+	switch req.Action {
+	case "note":
+		if strings.TrimSpace(req.Note) == "" {
+			return errors.New("note text is not provided")
+		}
+	case "amend":
+		if len(req.Amend) == 0 {
+			return errors.New("record fields to amend are not provided")
+		}
+		for key := range req.Amend {
+			if utils.InList(key, bulkProtectedKeys) {
+				return fmt.Errorf("record field %q can't be amended in bulk", key)
+			}
+		}
+	case "export":
+		if req.Format == "" {
+			req.Format = "csv"
+		}
+		if _, ok := exportFormats[req.Format]; !ok {
+			return fmt.Errorf("unsupported export format %s, please use csv, tsv or ndjson", req.Format)
+		}
+	case "sync":
+		if req.Sync.SourceUrl == "" || req.Sync.SourceToken == "" {
+			return errors.New("sync source url and token are not provided")
+		}
+	case "collection":
+		if strings.TrimSpace(req.Collection) == "" {
+			return ErrCollectionName
+		}
+	}
+	return nil
+}
+
+// helper function to get records of given DIDs which are accessible to the
+// user, it applies the same BTR constraints and token restrictions as
+// search requests do
+func accessibleRecords(c *gin.Context, user string, dids []string) (map[string]map[string]any, error) {
+	spec := map[string]any{"did": map[string]any{"$in": dids}}
+	spec = restrictSpec(c, spec)
+	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
+		fuser, err := getFoxdenUser(c, user)
+		if err != nil {
+			return nil, err
+		}
+		if !hasAccess(fuser) {
+			return nil, fmt.Errorf("[Frontend.main.accessibleRecords] user %s is not associated with any BTRs", user)
+		}
+		spec = updateSpec(spec, fuser, "search")
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.accessibleRecords] json.Marshal error: %w", err)
+	}
+	rec := services.ServiceRequest{
+		Client:       "frontend",
+		ServiceQuery: services.ServiceQuery{Query: string(query), Spec: spec, Limit: len(dids)},
+	}
+	resp, err := chunkOfRecords(rec)
+	if err != nil {
+		return nil, err
+	}
+	if resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+		return nil, fmt.Errorf("[Frontend.main.accessibleRecords] discovery service error: %s", resp.Error)
+	}
+	records := make(map[string]map[string]any)
+	for _, r := range resp.Results.Records {
+		if did := recValue(r, "did"); did != "" {
+			records[did] = r
+		}
+	}
+	return records, nil
+}
+
+// helper function to amend fields of metadata record with given DID
+func bulkAmend(c *gin.Context, user, did string, fields map[string]any) error {
+	orig, err := findMetadataRecord(did)
+	if err != nil {
+		return err
+	}
+	rec := make(map[string]any)
+	for key, val := range orig {
+		rec[key] = val
+	}
+	for key, val := range fields {
+		rec[key] = val
+	}
+	if _, ok := rec["user"]; !ok {
+		rec["user"] = user
+	}
+	err = updateMetadataRecord(did, rec)
+	audit(c, "amend", did, orig, rec, err)
+	return err
+}
+
+// helper function to add note to elog of given DID
+func bulkNote(c *gin.Context, user, did, text string) error {
+	sum := md5.Sum([]byte(did))
+	rec := ELogEntry{User: user, Text: text, Did: did, DidHash: hex.EncodeToString(sum[:])}
+	data, err := insertELogEntry(rec)
+	audit(c, "notes", did, nil, data, err)
+	return err
+}
+
+// helper function to create sync request of given DID
+func bulkSync(c *gin.Context, user, did string, opts BulkSync) error {
+	rec := map[string]any{
+		"source_url":   opts.SourceUrl,
+		"source_token": opts.SourceToken,
+		"target_url":   opts.TargetUrl,
+		"target_token": opts.TargetToken,
+		"continuous":   opts.Continuous,
+		"did":          did,
+	}
+	if opts.TargetUrl == "" {
+		rec["target_url"] = srvConfig.Config.Services.FrontendURL
+	}
+	if opts.TargetToken == "" {
+		token, err := newToken(user, "read+write")
+		if err != nil {
+			return err
+		}
+		rec["target_token"] = token
+	}
+	data, err := createSyncRecord(rec)
+	audit(c, "sync_create", did, nil, data, err)
+	return err
+}
+
+// helper function to export accessible records of bulk request, results of
+// DIDs which were not exported are provided via X-Bulk-Denied header
+func bulkExport(c *gin.Context, req BulkRequest, records map[string]map[string]any) {
+	var exported []map[string]any
+	var denied []string
+	for _, did := range req.Dids {
+		if rec, ok := records[did]; ok {
+			exported = append(exported, rec)
+		} else {
+			denied = append(denied, did)
+		}
+	}
+	if len(denied) > 0 {
+		c.Header("X-Bulk-Denied", strings.Join(denied, ","))
+	}
+	c.Header("Content-Type", exportFormats[req.Format])
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"foxden_export.%s\"", req.Format))
+	c.Status(http.StatusOK)
+	writer := newRecordWriter(c.Writer, req.Format, req.Attrs)
+	if err := writer.Write(exported); err == nil {
+		writer.Flush()
+	}
+}
+
+// BulkHandler provides access to POST /bulk endpoint
+func BulkHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	req, err := bulkRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := accessibleRecords(c, user, req.Dids)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Action == "export" {
+		bulkExport(c, req, records)
+		return
+	}
+
+	resp := BulkResponse{Action: req.Action}
+	var allowed []string
+	for _, did := range req.Dids {
+		if _, ok := records[did]; ok {
+			allowed = append(allowed, did)
+		} else {
+			resp.add(did, ErrBulkDenied)
+		}
+	}
+	if req.Action == "collection" {
+		_, err := _collections.Add(user, req.Collection, allowed)
+		for _, did := range allowed {
+			resp.add(did, err)
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	for _, did := range allowed {
+		var err error
+		switch req.Action {
+		case "note":
+			err = bulkNote(c, user, did, req.Note)
+		case "amend":
+			err = bulkAmend(c, user, did, req.Amend)
+		case "sync":
+			err = bulkSync(c, user, did, req.Sync)
+		}
+		resp.add(did, err)
+	}
+	if req.Action == "amend" && resp.Succeeded > 0 {
+		invalidateWriteCaches()
+	}
+	c.JSON(http.StatusOK, resp)
+}
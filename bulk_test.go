@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestValidateBulkRequest tests validation of bulk action requests
+func TestValidateBulkRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  BulkRequest
+		fail bool
+	}{
+		{"note", BulkRequest{Action: "note", Dids: []string{"/a"}, Note: "checked"}, false},
+		{"empty note", BulkRequest{Action: "note", Dids: []string{"/a"}}, true},
+		{"unknown action", BulkRequest{Action: "delete", Dids: []string{"/a"}}, true},
+		{"no dids", BulkRequest{Action: "note", Dids: []string{" "}, Note: "x"}, true},
+		{"amend", BulkRequest{Action: "amend", Dids: []string{"/a"}, Amend: map[string]any{"sample_name": "Ti"}}, false},
+		{"amend protected", BulkRequest{Action: "amend", Dids: []string{"/a"}, Amend: map[string]any{"btr": "x"}}, true},
+		{"export", BulkRequest{Action: "export", Dids: []string{"/a"}}, false},
+		{"export format", BulkRequest{Action: "export", Dids: []string{"/a"}, Format: "xml"}, true},
+		{"sync", BulkRequest{Action: "sync", Dids: []string{"/a"}}, true},
+		{"collection", BulkRequest{Action: "collection", Dids: []string{"/a"}, Collection: "ti"}, false},
+	}
+	for _, tt := range tests {
+		err := validateBulkRequest(&tt.req)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+// TestBulkRequest tests parsing of bulk request from HTML form
+func TestBulkRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	form := url.Values{
+		"action": {"amend"},
+		"did":    {"/a", "/b", "/a", ""},
+		"amend":  {`{"sample_name":"Ti"}`},
+	}
+	c.Request = httptest.NewRequest("POST", "/bulk", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, err := bulkRequest(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req.Dids, []string{"/a", "/b"}) {
+		t.Errorf("unexpected dids %v", req.Dids)
+	}
+	if req.Amend["sample_name"] != "Ti" {
+		t.Errorf("unexpected amendment %v", req.Amend)
+	}
+}
+
+// TestBulkResponse tests per DID results of bulk action
+func TestBulkResponse(t *testing.T) {
+	var resp BulkResponse
+	resp.add("/a", nil)
+	resp.add("/b", ErrBulkDenied)
+	resp.add("/c", errors.New("service is down"))
+	if resp.Succeeded != 1 || resp.Denied != 1 || resp.Failed != 1 {
+		t.Errorf("unexpected counters %+v", resp)
+	}
+	statuses := []string{bulkOk, bulkDenied, bulkFailed}
+	for i, r := range resp.Results {
+		if r.Status != statuses[i] {
+			t.Errorf("expected status %s of %s, got %s", statuses[i], r.Did, r.Status)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+// cache module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The cache module provides simple in-memory cache with time-to-live (TTL)
+// of its entries and limited size. It is used to keep results of expensive
+// upstream calls, e.g. distinct values of query keys, total number of
+// records, DataHub hashes, FOXDEN user attributes and facility statistics. Concurrent misses of
+// the same key are deduplicated (single-flight), i.e. only one upstream call
+// is made while other callers wait for its result. Caches are registered by
+// kind, their TTL and size can be set via Frontend.CacheTTL and
+// Frontend.CacheSize configuration maps, they are invalidated after our own
+// writes and their hit/miss stats are exposed via /cache/stats endpoint.
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// cacheEntry represents single cache entry
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// cacheCall represents upstream call in flight
+type cacheCall struct {
+	wg    sync.WaitGroup
+	value any
+	err   error
+}
+
+// CacheStats represents cache statistics
+type CacheStats struct {
+	Kind          string  `json:"kind"`
+	TTL           float64 `json:"ttl"` // TTL in seconds
+	MaxSize       int     `json:"max_size"`
+	Size          int     `json:"size"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	Shared        uint64  `json:"shared"` // misses served by call in flight
+	Evictions     uint64  `json:"evictions"`
+	Invalidations uint64  `json:"invalidations"`
+	HitRatio      float64 `json:"hit_ratio"`
+}
+
+// TTLCache represents in-memory cache with expiring entries
+type TTLCache struct {
+	TTL     time.Duration // time-to-live of cache entries
+	MaxSize int           // max number of cache entries, zero means no limit
+	entries map[string]cacheEntry
+	calls   map[string]*cacheCall
+	stats   CacheStats
+	mu      sync.Mutex
+}
+
+// NewTTLCache creates new TTL cache
+func NewTTLCache(ttl time.Duration, maxSize int) *TTLCache {
+	return &TTLCache{
+		TTL:     ttl,
+		MaxSize: maxSize,
+		entries: make(map[string]cacheEntry),
+		calls:   make(map[string]*cacheCall),
+	}
+}
+
+// Get returns cached value for given key
+func (c *TTLCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	val, ok := c.get(key)
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return val, ok
+}
+
+// helper function to get non-expired entry, it should be called with acquired lock
+func (c *TTLCache) get(key string) (any, bool) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// GetOrLoad returns cached value for given key or loads it with given
+// function, concurrent loads of the same key share single call of load
+// function and its errors are not cached
+func (c *TTLCache) GetOrLoad(key string, load func() (any, error)) (any, error) {
+	c.mu.Lock()
+	if val, ok := c.get(key); ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return val, nil
+	}
+	c.stats.Misses++
+	if call, ok := c.calls[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.value, call.err = load()
+
+	c.mu.Lock()
+	// the call may have been dropped by Purge, in that case its result
+	// may be stale and we do not keep it
+	if c.calls[key] == call {
+		delete(c.calls, key)
+		if call.err == nil {
+			c.set(key, call.value)
+		}
+	}
+	c.mu.Unlock()
+	call.wg.Done()
+	return call.value, call.err
+}
+
+// Set stores value for given key
+func (c *TTLCache) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value)
+}
+
+// helper function to store value, it should be called with acquired lock
+func (c *TTLCache) set(key string, value any) {
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && c.MaxSize > 0 && len(c.entries) >= c.MaxSize {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.TTL)}
+}
+
+// Delete removes given key from the cache
+func (c *TTLCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// Purge removes all cache entries and forgets calls in flight, it is used to
+// invalidate cache after our own writes
+func (c *TTLCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.calls = make(map[string]*cacheCall)
+	c.stats.Invalidations++
+}
+
+// Stats returns cache statistics
+func (c *TTLCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.TTL = c.TTL.Seconds()
+	stats.MaxSize = c.MaxSize
+	stats.Size = len(c.entries)
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// Len returns number of cache entries
+func (c *TTLCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// helper function to evict expired entries, if there are none the entry
+// which expires first is removed, it should be called with acquired lock
+func (c *TTLCache) evict(now time.Time) {
+	var oldest string
+	var oldestTime time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			c.stats.Evictions++
+			continue
+		}
+		if oldest == "" || entry.expires.Before(oldestTime) {
+			oldest, oldestTime = key, entry.expires
+		}
+	}
+	if len(c.entries) >= c.MaxSize && oldest != "" {
+		delete(c.entries, oldest)
+		c.stats.Evictions++
+	}
+}
+
+// cache kinds used by frontend
+const (
+	countsCache  = "counts"  // total number of records of upstream services
+	datahubCache = "datahub" // DataHub did hashes
+	usersCache   = "users"   // FOXDEN user attributes
+	suggestCache = "suggest" // distinct values of query keys
+	statsCache   = "stats"   // aggregated facility statistics
+)
+
+// cacheDefaults defines default TTL and size of caches
+var cacheDefaults = map[string]struct {
+	ttl  time.Duration
+	size int
+}{
+	countsCache:  {time.Minute, 100},
+	datahubCache: {time.Minute, 10},
+	usersCache:   {5 * time.Minute, 1000},
+	suggestCache: {5 * time.Minute, 1000},
+	statsCache:   {10 * time.Minute, 100},
+}
+
+// _caches keeps registered caches by their kind
+var _caches = make(map[string]*TTLCache)
+var _cachesMutex sync.RWMutex
+
+// helper function to make cache of given kind, its TTL and size are taken
+// from Frontend.CacheTTL and Frontend.CacheSize configuration or defaults
+func makeCache(kind string) *TTLCache {
+	def := cacheDefaults[kind]
+	ttl, size := def.ttl, def.size
+	if kind == suggestCache && _config.SuggestCacheTTL > 0 {
+		// dedicated TTL option of suggest cache
+		ttl = time.Duration(_config.SuggestCacheTTL) * time.Second
+	}
+	if val, ok := _config.CacheTTL[kind]; ok && val > 0 {
+		ttl = time.Duration(val) * time.Second
+	}
+	if val, ok := _config.CacheSize[kind]; ok && val > 0 {
+		size = val
+	}
+	return NewTTLCache(ttl, size)
+}
+
+// helper function to create and register cache of given kind, existing
+// cache of this kind is replaced
+func newCache(kind string) *TTLCache {
+	cache := makeCache(kind)
+	_cachesMutex.Lock()
+	_caches[kind] = cache
+	_cachesMutex.Unlock()
+	return cache
+}
+
+// helper function to get cache of given kind, the cache is created if it
+// does not exist yet
+func getCache(kind string) *TTLCache {
+	_cachesMutex.RLock()
+	cache, ok := _caches[kind]
+	_cachesMutex.RUnlock()
+	if ok {
+		return cache
+	}
+	_cachesMutex.Lock()
+	defer _cachesMutex.Unlock()
+	// cache could be created by another goroutine while we waited for the lock
+	if cache, ok := _caches[kind]; ok {
+		return cache
+	}
+	cache = makeCache(kind)
+	_caches[kind] = cache
+	return cache
+}
+
+// helper function to initialize caches from frontend configuration
+func initCaches() {
+	for _, kind := range []string{countsCache, datahubCache, usersCache, suggestCache, statsCache} {
+		newCache(kind)
+	}
+}
+
+// helper function to invalidate caches of given kinds, all caches are
+// invalidated if no kind is given
+func invalidateCaches(kinds ...string) {
+	_cachesMutex.RLock()
+	defer _cachesMutex.RUnlock()
+	for kind, cache := range _caches {
+		if len(kinds) == 0 || utils.InList(kind, kinds) {
+			cache.Purge()
+		}
+	}
+}
+
+// helper function to invalidate caches affected by writes to FOXDEN services
+func invalidateWriteCaches() {
+	invalidateCaches(countsCache, datahubCache, suggestCache, statsCache)
+}
+
+// helper function to get stats of all caches
+func cacheStats() []CacheStats {
+	_cachesMutex.RLock()
+	defer _cachesMutex.RUnlock()
+	var stats []CacheStats
+	for kind, cache := range _caches {
+		s := cache.Stats()
+		s.Kind = kind
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Kind < stats[j].Kind })
+	return stats
+}
+
+// cachedUserAttributes caches FOXDEN user attributes of given user attributes
+// implementation
+type cachedUserAttributes struct {
+	services.UserAttributes
+}
+
+// Get returns cached FOXDEN user
+func (u *cachedUserAttributes) Get(user string) (services.User, error) {
+	val, err := getCache(usersCache).GetOrLoad(user, func() (any, error) {
+		return u.UserAttributes.Get(user)
+	})
+	if err != nil {
+		return services.User{}, err
+	}
+	return val.(services.User), nil
+}
+
+// CacheStatsHandler provides access to GET /cache/stats endpoint
+func CacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"caches": cacheStats()})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTTLCache tests expiration and size limit of TTL cache
+func TestTTLCache(t *testing.T) {
+	cache := NewTTLCache(time.Hour, 2)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	if val, ok := cache.Get("a"); !ok || val.(int) != 1 {
+		t.Errorf("expected cached value 1, got %v", val)
+	}
+	// cache is full, the entry which expires first should be evicted
+	cache.Set("c", 3)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected evicted entry a")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+
+	expired := NewTTLCache(-time.Second, 0)
+	expired.Set("a", 1)
+	if _, ok := expired.Get("a"); ok {
+		t.Error("expected expired entry")
+	}
+	cache.Delete("b")
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected deleted entry")
+	}
+}
+
+// TestTTLCacheGetOrLoad tests single-flight loads and stats of TTL cache
+func TestTTLCacheGetOrLoad(t *testing.T) {
+	cache := NewTTLCache(time.Hour, 10)
+	var calls int32
+	release := make(chan struct{})
+	load := func() (any, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val, err := cache.GetOrLoad("a", load); err != nil || val.(int) != 42 {
+				t.Errorf("expected 42, got %v error %v", val, err)
+			}
+		}()
+	}
+	// wait until all goroutines are either loading or waiting for the load
+	for cache.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected single load, got %d", calls)
+	}
+	if val, err := cache.GetOrLoad("a", load); err != nil || val.(int) != 42 {
+		t.Errorf("expected cached value 42, got %v error %v", val, err)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 10 || stats.Shared != 9 || stats.Size != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// errors are not cached
+	fail := func() (any, error) { return nil, errors.New("upstream is down") }
+	if _, err := cache.GetOrLoad("b", fail); err == nil {
+		t.Error("expected load error")
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected error not to be cached")
+	}
+
+	cache.Purge()
+	if stats := cache.Stats(); stats.Size != 0 || stats.Invalidations != 1 {
+		t.Errorf("unexpected stats after purge %+v", stats)
+	}
+}
+
+// TestInvalidateCaches tests invalidation of caches by their kind
+func TestInvalidateCaches(t *testing.T) {
+	getCache(countsCache).Set("metadata", 10)
+	getCache(usersCache).Set("user", 1)
+	invalidateWriteCaches()
+	if _, ok := getCache(countsCache).Get("metadata"); ok {
+		t.Error("expected invalidated counts cache")
+	}
+	if _, ok := getCache(usersCache).Get("user"); !ok {
+		t.Error("expected users cache to be kept")
+	}
+	kinds := make(map[string]bool)
+	for _, s := range cacheStats() {
+		kinds[s.Kind] = true
+	}
+	if !kinds[countsCache] || !kinds[usersCache] {
+		t.Errorf("expected stats of counts and users caches, got %+v", cacheStats())
+	}
+}
+
+// TestGetCacheConcurrent tests that concurrent callers get the same cache
+func TestGetCacheConcurrent(t *testing.T) {
+	kind := "concurrent"
+	_cachesMutex.Lock()
+	delete(_caches, kind)
+	_cachesMutex.Unlock()
+	defer func() {
+		_cachesMutex.Lock()
+		delete(_caches, kind)
+		_cachesMutex.Unlock()
+	}()
+	caches := make([]*TTLCache, 10)
+	var wg sync.WaitGroup
+	for i := range caches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			caches[i] = getCache(kind)
+		}(i)
+	}
+	wg.Wait()
+	for _, cache := range caches[1:] {
+		if cache != caches[0] {
+			t.Fatal("expected single cache of given kind")
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// collections module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The collections module keeps named collections of DIDs of users. DIDs are
+// added to collections via bulk actions of datasets table and collections
+// are available via /collections endpoint.
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrCollectionName is returned when collection name is not provided
+var ErrCollectionName = errors.New("collection name is not provided")
+
+// Collection represents named collection of DIDs of the user
+type Collection struct {
+	Name    string    `json:"name"`
+	User    string    `json:"user"`
+	Dids    []string  `json:"dids"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// CollectionStore represents persistent store of collections
+type CollectionStore struct {
+	store       *JSONStore
+	collections map[string]*Collection
+	mu          sync.Mutex
+}
+
+// _collections holds collections of all users
+var _collections = &CollectionStore{collections: make(map[string]*Collection)}
+
+// helper function to initialize collections from persistent storage
+func initCollections() {
+	_collections.store = NewJSONStore("collections.json")
+	if err := _collections.Load(); err != nil {
+		log.Println("ERROR: unable to load collections", err)
+	}
+}
+
+// helper function to make collection key
+func collectionKey(user, name string) string {
+	return user + "/" + name
+}
+
+// Load loads collections from persistent store
+func (s *CollectionStore) Load() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*Collection
+	if err := s.store.Load(&records); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range records {
+		s.collections[collectionKey(rec.User, rec.Name)] = rec
+	}
+	return nil
+}
+
+// helper function to persist collections, it should be called with acquired lock
+func (s *CollectionStore) save() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*Collection
+	for _, rec := range s.collections {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return s.store.Save(records)
+}
+
+// Add adds DIDs to collection of the user, collection is created if it does
+// not exist, it returns DIDs which were not yet in collection
+func (s *CollectionStore) Add(user, name string, dids []string) ([]string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrCollectionName
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	key := collectionKey(user, name)
+	rec, ok := s.collections[key]
+	if !ok {
+		rec = &Collection{Name: name, User: user, Created: now}
+		s.collections[key] = rec
+	}
+	known := make(map[string]bool)
+	for _, did := range rec.Dids {
+		known[did] = true
+	}
+	var added []string
+	for _, did := range dids {
+		if !known[did] {
+			known[did] = true
+			added = append(added, did)
+		}
+	}
+	rec.Dids = append(rec.Dids, added...)
+	rec.Updated = now
+	return added, s.save()
+}
+
+// Collections returns collections of the user
+func (s *CollectionStore) Collections(user string) []Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []Collection
+	for _, rec := range s.collections {
+		if rec.User == user {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// CollectionsHandler provides access to GET /collections endpoint
+func CollectionsHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	records := _collections.Collections(user)
+	if name := c.Query("name"); name != "" {
+		for _, rec := range records {
+			if rec.Name == name {
+				c.JSON(http.StatusOK, rec)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "collection not found"})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
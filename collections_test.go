@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCollectionStore tests adding DIDs to user collections
+func TestCollectionStore(t *testing.T) {
+	store := &CollectionStore{collections: make(map[string]*Collection)}
+	added, err := store.Add("alice", "ti", []string{"/a", "/b"})
+	if err != nil || len(added) != 2 {
+		t.Fatalf("unexpected result %v error %v", added, err)
+	}
+	added, _ = store.Add("alice", "ti", []string{"/b", "/c", "/c"})
+	if !reflect.DeepEqual(added, []string{"/c"}) {
+		t.Errorf("expected only new DIDs to be added, got %v", added)
+	}
+	if _, err := store.Add("alice", " ", []string{"/a"}); err != ErrCollectionName {
+		t.Errorf("expected ErrCollectionName, got %v", err)
+	}
+	store.Add("bob", "ti", []string{"/x"})
+	records := store.Collections("alice")
+	if len(records) != 1 || !reflect.DeepEqual(records[0].Dids, []string{"/a", "/b", "/c"}) {
+		t.Errorf("unexpected collections %+v", records)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+// columnfilter module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The columnfilter module provides typed per-column filters of dynamic
+// datasets and spec scans tables. Filters are passed as filter=attr:expr
+// parameters (and kept in page URL to share the view), where expression
+// syntax depends on schema type of the attribute:
+//
+//	numbers: 40, >40, >=40, <40, <=40, !=40, 40..50 (inclusive range), 1,2,3
+//	dates:   2024-01-31, >2024-01-01, 2024-01-01..2024-03-31 (date and start_time)
+//	enums:   3a or 3a,id1 (exact match or list of values)
+//	strings: Ti (substring), =Ti-1 (exact match), =Ti-1,Ti-2 (list of values)
+//	bools:   true or false
+//
+// Substrings are escaped before they are used in regular expressions.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	"github.com/gin-gonic/gin"
+)
+
+// dateColumns lists attributes which hold timestamps (seconds since epoch)
+var dateColumns = []string{"date", "start_time"}
+
+// dateLayouts lists supported date formats of date filters
+var dateLayouts = []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// ColumnType represents data type of table column
+type ColumnType struct {
+	Type string   `json:"type"`           // int, float, bool, date or string
+	Enum []string `json:"enum,omitempty"` // allowed values of enum columns
+}
+
+// ColumnFilter represents filter of single table column
+type ColumnFilter struct {
+	Attr string `json:"attr"`
+	Expr string `json:"expr"`
+}
+
+// helper function to get column types from given schema manager, list types
+// are represented by type of their elements
+func schemaColumns(smgr beamlines.SchemaManager) map[string]ColumnType {
+	columns := make(map[string]ColumnType)
+	for _, obj := range smgr.Map {
+		if obj == nil || obj.Schema == nil {
+			continue
+		}
+		for key, rec := range obj.Schema.Map {
+			col := ColumnType{Type: queryDataType(strings.TrimPrefix(strings.ToLower(rec.Type), "list_"))}
+			if col.Type == "struct" || col.Type == "list" {
+				col.Type = "string"
+			}
+			if values, ok := rec.Value.([]any); ok && col.Type == "string" {
+				for _, v := range values {
+					if s := fmt.Sprintf("%v", v); s != "" {
+						col.Enum = append(col.Enum, s)
+					}
+				}
+			}
+			if prev, ok := columns[key]; ok && len(prev.Enum) > 0 {
+				// keep union of enum values defined by different schemas
+				col.Enum = append(prev.Enum, col.Enum...)
+			}
+			columns[key] = col
+		}
+	}
+	for _, key := range dateColumns {
+		columns[key] = ColumnType{Type: "date"}
+	}
+	return columns
+}
+
+// helper function to parse filter=attr:expr parameters of HTTP request
+func columnFilters(c *gin.Context) []ColumnFilter {
+	var filters []ColumnFilter
+	for _, val := range c.QueryArray("filter") {
+		arr := strings.SplitN(val, ":", 2)
+		if len(arr) != 2 || arr[0] == "" || strings.TrimSpace(arr[1]) == "" {
+			continue
+		}
+		filters = append(filters, ColumnFilter{Attr: arr[0], Expr: strings.TrimSpace(arr[1])})
+	}
+	return filters
+}
+
+// helper function to narrow spec with column filters
+func applyColumnFilters(spec map[string]any, filters []ColumnFilter, columns map[string]ColumnType, caseInsensitive bool) (map[string]any, error) {
+	if len(filters) == 0 {
+		return spec, nil
+	}
+	if spec == nil {
+		spec = make(map[string]any)
+	}
+	var conds []any
+	for _, f := range filters {
+		col, ok := columns[f.Attr]
+		if !ok {
+			col = ColumnType{Type: "string"}
+		}
+		cond, err := columnCondition(f.Expr, col, caseInsensitive)
+		if err != nil {
+			return spec, fmt.Errorf("[Frontend.main.applyColumnFilters] filter %s: %w", f.Attr, err)
+		}
+		conds = append(conds, map[string]any{f.Attr: cond})
+	}
+	if len(spec) > 0 {
+		conds = append([]any{spec}, conds...)
+	}
+	if len(conds) == 1 {
+		return conds[0].(map[string]any), nil
+	}
+	return map[string]any{"$and": conds}, nil
+}
+
+// helper function to make condition of column filter expression
+func columnCondition(expr string, col ColumnType, caseInsensitive bool) (any, error) {
+	switch col.Type {
+	case "int", "float":
+		return rangeCondition(expr, func(s string) (any, error) {
+			if col.Type == "int" {
+				return strconv.ParseInt(s, 10, 64)
+			}
+			return strconv.ParseFloat(s, 64)
+		})
+	case "date":
+		return dateCondition(expr)
+	case "bool":
+		val, err := strconv.ParseBool(expr)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", expr)
+		}
+		return val, nil
+	}
+	if len(col.Enum) > 0 || strings.HasPrefix(expr, "=") {
+		values := splitValues(strings.TrimPrefix(expr, "="))
+		if len(values) == 1 {
+			return values[0], nil
+		}
+		return map[string]any{"$in": values}, nil
+	}
+	cond := map[string]any{"$regex": regexp.QuoteMeta(expr)}
+	if caseInsensitive {
+		cond["$options"] = "i"
+	}
+	return cond, nil
+}
+
+// helper function to split comma separated list of values
+func splitValues(expr string) []any {
+	var values []any
+	for _, v := range strings.Split(expr, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// helper function to make condition of comparison, range or list expression
+// whose values are converted by given parse function
+func rangeCondition(expr string, parse func(string) (any, error)) (any, error) {
+	value := func(s string) (any, error) {
+		val, err := parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", s)
+		}
+		return val, nil
+	}
+	if lo, hi, ok := strings.Cut(expr, ".."); ok {
+		cond := make(map[string]any)
+		if strings.TrimSpace(lo) != "" {
+			val, err := value(lo)
+			if err != nil {
+				return nil, err
+			}
+			cond["$gte"] = val
+		}
+		if strings.TrimSpace(hi) != "" {
+			val, err := value(hi)
+			if err != nil {
+				return nil, err
+			}
+			cond["$lte"] = val
+		}
+		if len(cond) == 0 {
+			return nil, fmt.Errorf("empty range %q", expr)
+		}
+		return cond, nil
+	}
+	for _, op := range []struct{ sym, mongo string }{
+		{">=", "$gte"}, {"<=", "$lte"}, {"!=", "$ne"}, {">", "$gt"}, {"<", "$lt"}, {"=", ""},
+	} {
+		if rest, ok := strings.CutPrefix(expr, op.sym); ok {
+			val, err := value(rest)
+			if err != nil {
+				return nil, err
+			}
+			if op.mongo == "" {
+				return val, nil
+			}
+			return map[string]any{op.mongo: val}, nil
+		}
+	}
+	if strings.Contains(expr, ",") {
+		var values []any
+		for _, s := range strings.Split(expr, ",") {
+			val, err := value(s)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+		}
+		return map[string]any{"$in": values}, nil
+	}
+	return value(expr)
+}
+
+// helper function to parse date of date filter
+func parseFilterDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD [HH:MM[:SS]]", s)
+}
+
+// helper function to make condition of date filter, dates are converted to
+// seconds since epoch and date without time covers the whole day
+func dateCondition(expr string) (any, error) {
+	// the end of range and single dates without time include the whole day
+	endOf := func(s string) (int64, error) {
+		t, err := parseFilterDate(s)
+		if err != nil {
+			return 0, err
+		}
+		if len(s) == len("2006-01-02") {
+			return t.AddDate(0, 0, 1).Unix() - 1, nil
+		}
+		return t.Unix(), nil
+	}
+	startOf := func(s string) (int64, error) {
+		t, err := parseFilterDate(s)
+		return t.Unix(), err
+	}
+	if lo, hi, ok := strings.Cut(expr, ".."); ok {
+		cond := make(map[string]any)
+		if lo = strings.TrimSpace(lo); lo != "" {
+			val, err := startOf(lo)
+			if err != nil {
+				return nil, err
+			}
+			cond["$gte"] = val
+		}
+		if hi = strings.TrimSpace(hi); hi != "" {
+			val, err := endOf(hi)
+			if err != nil {
+				return nil, err
+			}
+			cond["$lte"] = val
+		}
+		if len(cond) == 0 {
+			return nil, fmt.Errorf("empty range %q", expr)
+		}
+		return cond, nil
+	}
+	for _, op := range []struct {
+		sym, mongo string
+		bound      func(string) (int64, error)
+	}{
+		{">=", "$gte", startOf}, {"<=", "$lte", endOf}, {">", "$gt", endOf}, {"<", "$lt", startOf},
+	} {
+		if rest, ok := strings.CutPrefix(expr, op.sym); ok {
+			val, err := op.bound(strings.TrimSpace(rest))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{op.mongo: val}, nil
+		}
+	}
+	lo, err := startOf(expr)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := endOf(expr)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"$gte": lo, "$lte": hi}, nil
+}
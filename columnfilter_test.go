@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestColumnCondition tests conditions of typed column filters
+func TestColumnCondition(t *testing.T) {
+	day := func(s string) int64 {
+		d, _ := time.ParseInLocation("2006-01-02", s, time.Local)
+		return d.Unix()
+	}
+	intCol := ColumnType{Type: "int"}
+	floatCol := ColumnType{Type: "float"}
+	tests := []struct {
+		name     string
+		expr     string
+		col      ColumnType
+		expected any
+		fail     bool
+	}{
+		{"greater", ">40", floatCol, map[string]any{"$gt": 40.0}, false},
+		{"less or equal", "<=3", intCol, map[string]any{"$lte": int64(3)}, false},
+		{"range", "40..50.5", floatCol, map[string]any{"$gte": 40.0, "$lte": 50.5}, false},
+		{"open range", "..50", intCol, map[string]any{"$lte": int64(50)}, false},
+		{"list", "1,2", intCol, map[string]any{"$in": []any{int64(1), int64(2)}}, false},
+		{"exact number", "7", intCol, int64(7), false},
+		{"bad number", ">abc", intCol, nil, true},
+		{"bool", "true", ColumnType{Type: "bool"}, true, false},
+		{"date range", "2024-01-01..2024-01-31", ColumnType{Type: "date"},
+			map[string]any{"$gte": day("2024-01-01"), "$lte": day("2024-02-01") - 1}, false},
+		{"single date", "2024-01-01", ColumnType{Type: "date"},
+			map[string]any{"$gte": day("2024-01-01"), "$lte": day("2024-01-02") - 1}, false},
+		{"after date", ">2024-01-01", ColumnType{Type: "date"}, map[string]any{"$gt": day("2024-01-02") - 1}, false},
+		{"bad date", "01/02/2024", ColumnType{Type: "date"}, nil, true},
+		{"enum", "3a, id1", ColumnType{Type: "string", Enum: []string{"3a", "id1"}},
+			map[string]any{"$in": []any{"3a", "id1"}}, false},
+		{"exact string", "=Ti-1", ColumnType{Type: "string"}, "Ti-1", false},
+		{"escaped substring", "Ti(1)*", ColumnType{Type: "string"},
+			map[string]any{"$regex": `Ti\(1\)\*`, "$options": "i"}, false},
+	}
+	for _, tt := range tests {
+		cond, err := columnCondition(tt.expr, tt.col, true)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tt.name, cond)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cond, tt.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.expected, cond)
+		}
+	}
+}
+
+// TestApplyColumnFilters tests narrowing of spec with column filters from HTTP request
+func TestApplyColumnFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/datasets?filter=beam_energy:%3E40&filter=sample_name:Ti&filter=bad&filter=cycle:", nil)
+	filters := columnFilters(c)
+	expected := []ColumnFilter{{Attr: "beam_energy", Expr: ">40"}, {Attr: "sample_name", Expr: "Ti"}}
+	if !reflect.DeepEqual(filters, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, filters)
+	}
+	columns := map[string]ColumnType{"beam_energy": {Type: "float"}}
+	spec, err := applyColumnFilters(map[string]any{"btr": "x"}, filters, columns, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]any{"$and": []any{
+		map[string]any{"btr": "x"},
+		map[string]any{"beam_energy": map[string]any{"$gt": 40.0}},
+		map[string]any{"sample_name": map[string]any{"$regex": "Ti"}},
+	}}
+	if !reflect.DeepEqual(spec, expect) {
+		t.Errorf("expected %+v, got %+v", expect, spec)
+	}
+	if _, err := applyColumnFilters(nil, []ColumnFilter{{Attr: "beam_energy", Expr: "abc"}}, columns, false); err == nil {
+		t.Error("expected error for invalid numeric filter")
+	}
+}
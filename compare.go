@@ -0,0 +1,288 @@
+package main
+
+// compare module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The compare module provides /compare endpoint which shows metadata records
+// of several DIDs side by side, e.g. /compare?did=a&did=b&did=c. Record keys
+// are aligned using sections of beamline schemas, values which differ
+// between records are highlighted and shown with their units. Identical
+// fields can be hidden via hide=identical parameter and comparison is
+// available in JSON format via format=json parameter or Accept header.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// compareMaxDids defines maximum number of records to compare
+const compareMaxDids = 5
+
+// compareOtherSection defines section of keys which are not part of the schema
+const compareOtherSection = "other"
+
+// CompareField represents single record key compared across records
+type CompareField struct {
+	Key     string   `json:"key"`
+	Units   string   `json:"units,omitempty"`
+	Values  []any    `json:"values"`  // values of records, nil if record does not have the key
+	Differs bool     `json:"differs"` // true if values are not identical
+	Display []string `json:"-"`       // text representation of values
+}
+
+// CompareSection represents schema section of compared records
+type CompareSection struct {
+	Name   string         `json:"name"`
+	Fields []CompareField `json:"fields"`
+}
+
+// CompareResult represents comparison of metadata records
+type CompareResult struct {
+	Dids     []string         `json:"dids"`
+	Fields   int              `json:"fields"`  // total number of compared fields
+	Differs  int              `json:"differs"` // number of fields which differ
+	Sections []CompareSection `json:"sections"`
+}
+
+// compareLayout represents ordered keys of schema section
+type compareLayout struct {
+	Section string
+	Keys    []string
+}
+
+// helper function to parse and validate DIDs of compare request
+func compareDids(dids []string) ([]string, error) {
+	var out []string
+	for _, did := range dids {
+		if did = strings.TrimSpace(did); did != "" && !utils.InList(did, out) {
+			out = append(out, did)
+		}
+	}
+	if len(out) < 2 {
+		return out, errors.New("please provide at least two distinct DIDs to compare")
+	}
+	if len(out) > compareMaxDids {
+		return out, fmt.Errorf("too many DIDs %d, comparison is limited to %d records", len(out), compareMaxDids)
+	}
+	return out, nil
+}
+
+// helper function to build section layout of given schema names
+func schemaLayout(snames []string) []compareLayout {
+	var layout []compareLayout
+	for _, fname := range srvConfig.Config.CHESSMetaData.SchemaFiles {
+		sname := strings.ReplaceAll(filepath.Base(fname), ".json", "")
+		if !utils.InList(sname, snames) {
+			continue
+		}
+		schema, err := _smgr.Load(fname)
+		if err != nil {
+			log.Println("ERROR: unable to load", fname, err)
+			continue
+		}
+		sectionKeys, err := schema.SectionKeys()
+		if err != nil {
+			log.Println("ERROR: unable to get section keys of", fname, err)
+			continue
+		}
+		sections, err := schema.Sections()
+		if err != nil {
+			log.Println("ERROR: unable to get sections of", fname, err)
+			continue
+		}
+		for _, s := range beamlineSections(schema, sections) {
+			layout = mergeLayout(layout, s, beamlineSectionKeys(schema, s, sectionKeys))
+		}
+	}
+	return layout
+}
+
+// helper function to merge section keys into layout, keys which are already
+// part of layout are skipped
+func mergeLayout(layout []compareLayout, section string, keys []string) []compareLayout {
+	known := make(map[string]bool)
+	for _, l := range layout {
+		for _, key := range l.Keys {
+			known[key] = true
+		}
+	}
+	var newKeys []string
+	for _, key := range keys {
+		if !known[key] {
+			known[key] = true
+			newKeys = append(newKeys, key)
+		}
+	}
+	if len(newKeys) == 0 {
+		return layout
+	}
+	for i, l := range layout {
+		if l.Section == section {
+			layout[i].Keys = append(layout[i].Keys, newKeys...)
+			return layout
+		}
+	}
+	return append(layout, compareLayout{Section: section, Keys: newKeys})
+}
+
+// helper function to represent value of compared field
+func compareValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "—"
+	case string:
+		return v
+	case map[string]any, []any:
+		data, err := json.Marshal(v)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// helper function to compare records, records should follow order of dids
+// and keys of records which are not part of layout are placed into other
+// section
+func compareRecords(dids []string, records []map[string]any, layout []compareLayout, units map[string]string, hideIdentical bool) CompareResult {
+	result := CompareResult{Dids: dids}
+	var otherKeys []string
+	for _, rec := range records {
+		for key := range rec {
+			otherKeys = append(otherKeys, key)
+		}
+	}
+	sort.Strings(otherKeys)
+	layout = mergeLayout(append([]compareLayout{}, layout...), compareOtherSection, otherKeys)
+
+	for _, l := range layout {
+		section := CompareSection{Name: l.Section}
+		for _, key := range l.Keys {
+			field := CompareField{Key: key, Units: units[key]}
+			var present bool
+			for _, rec := range records {
+				val, ok := rec[key]
+				if ok {
+					present = true
+				}
+				field.Values = append(field.Values, val)
+				field.Display = append(field.Display, compareValue(val))
+			}
+			// skip schema keys which are not present in any record
+			if !present {
+				continue
+			}
+			for _, val := range field.Values[1:] {
+				if !reflect.DeepEqual(val, field.Values[0]) {
+					field.Differs = true
+					break
+				}
+			}
+			result.Fields++
+			if field.Differs {
+				result.Differs++
+			} else if hideIdentical {
+				continue
+			}
+			section.Fields = append(section.Fields, field)
+		}
+		if len(section.Fields) > 0 {
+			result.Sections = append(result.Sections, section)
+		}
+	}
+	return result
+}
+
+// CompareHandler provides access to GET /compare endpoint
+func CompareHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	r := c.Request
+	jsonFormat := r.FormValue("format") == "json" || r.Header.Get("Accept") == "application/json"
+	dids, err := compareDids(c.QueryArray("did"))
+	if err != nil {
+		if jsonFormat {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		handleError(c, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	hideIdentical := r.FormValue("hide") == "identical"
+
+	// check that user has access to all records before fetching them
+	accessible, err := accessibleRecords(c, user, dids)
+	if err != nil {
+		if jsonFormat {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		handleError(c, http.StatusBadRequest, "unable to look-up records", err)
+		return
+	}
+	var records []map[string]any
+	var snames []string
+	units := make(map[string]string)
+	for _, did := range dids {
+		var rec map[string]any
+		if _, ok := accessible[did]; ok {
+			rec, err = findMetadataRecord(did)
+		} else {
+			err = ErrBulkDenied
+		}
+		if err != nil {
+			err = fmt.Errorf("[Frontend.main.CompareHandler] did=%s error: %w", did, err)
+			if jsonFormat {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			handleError(c, http.StatusBadRequest, "unable to get metadata record", err)
+			return
+		}
+		records = append(records, rec)
+		if sname := recValue(rec, "schema"); sname != "" && !utils.InList(sname, snames) {
+			snames = append(snames, sname)
+			for key, unit := range _metaManager.Units(sname) {
+				if _, ok := units[key]; !ok && unit != "" {
+					units[key] = unit
+				}
+			}
+		}
+	}
+	result := compareRecords(dids, records, schemaLayout(snames), units, hideIdentical)
+	if jsonFormat {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Compare")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["Result"] = result
+	tmpl["HideIdentical"] = hideIdentical
+	vals := url.Values{"did": dids}
+	if hideIdentical {
+		vals.Set("hide", "identical")
+	}
+	tmpl["JsonURL"] = template.URL(base("/compare") + "?" + vals.Encode() + "&format=json")
+	vals.Del("hide")
+	if !hideIdentical {
+		vals.Set("hide", "identical")
+	}
+	tmpl["ToggleURL"] = template.URL(base("/compare") + "?" + vals.Encode())
+	tmpl["NColumns"] = len(dids) + 1
+	content := server.TmplPage(StaticFs, "compare.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCompareDids tests validation of DIDs of compare requests
+func TestCompareDids(t *testing.T) {
+	tests := []struct {
+		name string
+		dids []string
+		want []string
+		fail bool
+	}{
+		{"two dids", []string{"/a", "/b"}, []string{"/a", "/b"}, false},
+		{"duplicates", []string{"/a", " /a", "/b", ""}, []string{"/a", "/b"}, false},
+		{"single did", []string{"/a", "/a"}, []string{"/a"}, true},
+		{"too many", []string{"/1", "/2", "/3", "/4", "/5", "/6"}, nil, true},
+	}
+	for _, tt := range tests {
+		dids, err := compareDids(tt.dids)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		} else if !reflect.DeepEqual(dids, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, dids, tt.want)
+		}
+	}
+}
+
+// TestCompareRecords tests alignment and diff of compared records
+func TestCompareRecords(t *testing.T) {
+	dids := []string{"/a", "/b"}
+	records := []map[string]any{
+		{"did": "/a", "beamline": "3a", "energy": 10.0, "sample_name": "Ti", "extra": "x"},
+		{"did": "/b", "beamline": "3a", "energy": 12.0, "sample_name": "Ti", "tags": []any{"t"}},
+	}
+	layout := []compareLayout{
+		{Section: "beam", Keys: []string{"beamline", "energy", "missing"}},
+		{Section: "sample", Keys: []string{"sample_name"}},
+	}
+	units := map[string]string{"energy": "keV"}
+	tests := []struct {
+		name     string
+		hide     bool
+		sections []string
+		keys     []string
+		differs  int
+	}{
+		{"all fields", false, []string{"beam", "sample", "other"}, []string{"beamline", "energy", "sample_name", "did", "extra", "tags"}, 4},
+		{"hide identical", true, []string{"beam", "other"}, []string{"energy", "did", "extra", "tags"}, 4},
+	}
+	for _, tt := range tests {
+		res := compareRecords(dids, records, layout, units, tt.hide)
+		var sections, keys []string
+		for _, s := range res.Sections {
+			sections = append(sections, s.Name)
+			for _, f := range s.Fields {
+				keys = append(keys, f.Key)
+				if f.Key == "energy" && f.Units != "keV" {
+					t.Errorf("%s: wrong units %q of energy", tt.name, f.Units)
+				}
+				if f.Key == "extra" && f.Display[1] != "—" {
+					t.Errorf("%s: wrong representation %q of missing value", tt.name, f.Display[1])
+				}
+			}
+		}
+		if !reflect.DeepEqual(sections, tt.sections) {
+			t.Errorf("%s: sections %v, want %v", tt.name, sections, tt.sections)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.keys)
+		}
+		if res.Fields != 6 || res.Differs != tt.differs {
+			t.Errorf("%s: fields=%d differs=%d, want 6 and %d", tt.name, res.Fields, res.Differs, tt.differs)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+// config module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The golib configuration defines common FOXDEN settings, here we read
+// additional Frontend specific options from the same configuration file.
+
+import (
+	"log"
+
+	"github.com/spf13/viper"
+)
+
+// OIDCRecord represents generic OpenID Connect provider configuration, it is
+// defined as part of Frontend.OAuth list and distinguished by Issuer attribute
+type OIDCRecord struct {
+	Provider     string   `mapstructure:"Provider"`     // name of the provider
+	Name         string   `mapstructure:"Name"`         // display name of the provider
+	ClientID     string   `mapstructure:"ClientId"`     // client id
+	ClientSecret string   `mapstructure:"ClientSecret"` // client secret
+	RedirectURL  string   `mapstructure:"RedirectUrl"`  // redirect url
+	Issuer       string   `mapstructure:"Issuer"`       // OIDC issuer url used for discovery
+	Scopes       []string `mapstructure:"Scopes"`       // list of requested scopes
+	UserClaim    string   `mapstructure:"UserClaim"`    // ID token claim which maps to FOXDEN user
+}
+
+// FrontendConfig represents Frontend specific configuration options
+type FrontendConfig struct {
+	OAuth      []OIDCRecord `mapstructure:"OAuth"`      // generic OIDC providers
+	StorageDir string       `mapstructure:"StorageDir"` // area to keep frontend persistent data
+	PolicyFile string       `mapstructure:"PolicyFile"` // route authorization policy file
+
+	// service map file which defines query keys of FOXDEN services and max
+	// number of records requested from a service per federated sub-query
+	ServiceMapFile      string `mapstructure:"ServiceMapFile"`
+	FederatedMaxRecords int    `mapstructure:"FederatedMaxRecords"`
+
+	// audit log area and max size of audit file (in bytes) before its rotation
+	AuditDir     string `mapstructure:"AuditDir"`
+	AuditMaxSize int64  `mapstructure:"AuditMaxSize"`
+
+	// saved searches watch mode: interval in seconds (0 disables it), max number
+	// of records to check per search and optional webhook URL for notifications
+	SearchWatchInterval int    `mapstructure:"SearchWatchInterval"`
+	SearchWatchLimit    int    `mapstructure:"SearchWatchLimit"`
+	SearchWebhook       string `mapstructure:"SearchWebhook"`
+
+	// number of records fetched from Discovery service per export chunk
+	ExportChunkSize int `mapstructure:"ExportChunkSize"`
+
+	// facets shown in search and dstable views and max number of records used to count them
+	FacetAttributes []string `mapstructure:"FacetAttributes"`
+	FacetMaxRecords int      `mapstructure:"FacetMaxRecords"`
+
+	// TTL (in seconds) of cached distinct values of query keys and max number of records to scan
+	SuggestCacheTTL   int `mapstructure:"SuggestCacheTTL"`
+	SuggestMaxRecords int `mapstructure:"SuggestMaxRecords"`
+
+	// TTL (in seconds) and max number of entries of caches by their kind, e.g.
+	// counts, datahub, users, suggest or stats
+	CacheTTL  map[string]int `mapstructure:"CacheTTL"`
+	CacheSize map[string]int `mapstructure:"CacheSize"`
+
+	// max number of DIDs processed by single bulk action
+	BulkMaxDids int `mapstructure:"BulkMaxDids"`
+
+	// max number of records aggregated by statistics dashboard
+	StatsMaxRecords int `mapstructure:"StatsMaxRecords"`
+
+	// kerberos password login throttling: number of failures before lockout and
+	// lockout duration in seconds
+	LoginMaxFailures int `mapstructure:"LoginMaxFailures"`
+	LoginLockout     int `mapstructure:"LoginLockout"`
+
+	// IPs or CIDRs of reverse proxies allowed to set client IP via
+	// X-Forwarded-For header, by default no proxy is trusted
+	TrustedProxies []string `mapstructure:"TrustedProxies"`
+
+	// kerberos principal used from Kerberos.Keytab to validate SPNEGO tokens, e.g. HTTP/host
+	KeytabPrincipal string `mapstructure:"KeytabPrincipal"`
+}
+
+// _config holds Frontend specific configuration
+var _config FrontendConfig
+
+// helper function to read Frontend specific configuration
+func initConfig() {
+	if err := viper.UnmarshalKey("Frontend", &_config); err != nil {
+		log.Println("WARNING: unable to read Frontend configuration", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+// cursor module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The cursor module provides cursor (keyset) based pagination of search
+// results. A cursor is an opaque token which encodes sort keys, sort order
+// and values of the sort keys of the last (or first) seen record. Instead of
+// skipping idx records Discovery service is asked for records which follow
+// (or precede) these values, therefore deep pages are as fast as the first
+// one and they are not shifted by records inserted in the meantime. Sort
+// keys are always complemented by unique tie-breaker keys, e.g. did, to have
+// stable order of records with the same sort value. The idx/limit offset
+// pagination is still supported when no cursor is provided.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrInvalidCursor is returned when cursor token can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor represents position within sorted search results
+type Cursor struct {
+	Keys   []string `json:"k"`           // sort keys, last keys are tie-breakers
+	Order  int      `json:"o"`           // sort order, 1 ascending, -1 descending
+	Values []any    `json:"v,omitempty"` // sort key values of last (or first) seen record
+	Prev   bool     `json:"p,omitempty"` // cursor points to records preceding values
+	Idx    int      `json:"i"`           // index of the first record of the page, used for display
+	Total  int      `json:"t,omitempty"` // total number of records known when cursor was made
+}
+
+// Encode returns opaque cursor token
+func (c Cursor) Encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// helper function to decode cursor token
+func decodeCursor(token string) (Cursor, error) {
+	var cur Cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cur, fmt.Errorf("[Frontend.main.decodeCursor] %w: %v", ErrInvalidCursor, err)
+	}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return cur, fmt.Errorf("[Frontend.main.decodeCursor] %w: %v", ErrInvalidCursor, err)
+	}
+	if len(cur.Keys) == 0 || (cur.Order != 1 && cur.Order != -1) ||
+		(len(cur.Values) > 0 && len(cur.Values) != len(cur.Keys)) {
+		return cur, fmt.Errorf("[Frontend.main.decodeCursor] %w: malformed cursor", ErrInvalidCursor)
+	}
+	return cur, nil
+}
+
+// helper function to get cursor of HTTP request, nil is returned if request
+// does not provide cursor, i.e. offset pagination is used
+func requestCursor(c *gin.Context) (*Cursor, error) {
+	token := c.Request.FormValue("cursor")
+	if token == "" {
+		return nil, nil
+	}
+	cur, err := decodeCursor(token)
+	if err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// helper function to make list of cursor keys out of sort keys and tie-breakers
+func cursorKeys(sortKeys, tieKeys []string) []string {
+	var keys []string
+	all := append(append([]string{}, sortKeys...), tieKeys...)
+	for _, key := range all {
+		dup := false
+		for _, k := range keys {
+			if k == key {
+				dup = true
+				break
+			}
+		}
+		if !dup && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// helper function to create condition selecting records which follow given
+// values in given sort order. Records without sort key (null) come first in
+// ascending and last in descending order.
+func cursorCondition(keys []string, values []any, order int) map[string]any {
+	var branches []any
+	for i, key := range keys {
+		prefix := make(map[string]any)
+		for j := 0; j < i; j++ {
+			prefix[keys[j]] = values[j]
+		}
+		val := values[i]
+		var conds []any
+		if order == 1 {
+			if val == nil {
+				conds = append(conds, map[string]any{"$ne": nil})
+			} else {
+				conds = append(conds, map[string]any{"$gt": val})
+			}
+		} else if val != nil {
+			conds = append(conds, map[string]any{"$lt": val}, nil)
+		}
+		for _, cond := range conds {
+			branch := make(map[string]any)
+			for k, v := range prefix {
+				branch[k] = v
+			}
+			branch[key] = cond
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		// nothing follows the values, select no records
+		return map[string]any{"$or": []any{map[string]any{keys[0]: map[string]any{"$in": []any{}}}}}
+	}
+	return map[string]any{"$or": branches}
+}
+
+// helper function to apply cursor to service request, it narrows request
+// spec with cursor condition and sets sort keys and order
+func applyCursor(rec *services.ServiceRequest, cur Cursor) error {
+	spec := rec.ServiceQuery.Spec
+	if spec == nil {
+		spec = make(map[string]any)
+		if query := cleanQuery(rec.ServiceQuery.Query); query != "" {
+			if err := json.Unmarshal([]byte(query), &spec); err != nil {
+				return fmt.Errorf("[Frontend.main.applyCursor] json.Unmarshal error: %w", err)
+			}
+		}
+	}
+	order := cur.Order
+	if cur.Prev {
+		// records preceding the values are the ones following them in reverse order
+		order = -order
+	}
+	if len(cur.Values) > 0 {
+		cond := cursorCondition(cur.Keys, cur.Values, order)
+		if len(spec) == 0 {
+			spec = cond
+		} else {
+			spec = map[string]any{"$and": []any{spec, cond}}
+		}
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.applyCursor] json.Marshal error: %w", err)
+	}
+	rec.ServiceQuery.Spec = spec
+	rec.ServiceQuery.Query = string(data)
+	rec.ServiceQuery.SortKeys = cur.Keys
+	rec.ServiceQuery.SortOrder = order
+	rec.ServiceQuery.Idx = 0
+	return nil
+}
+
+// helper function to set up cursor pagination of JSON endpoints, it adds
+// tie-breakers to sort keys of service request (defaultKey is used if request
+// does not have sort keys) and applies request cursor if it is provided.
+// It returns request cursor along with sort keys and order of the results.
+func cursorRequest(c *gin.Context, rec *services.ServiceRequest, defaultKey string, tieKeys []string) (*Cursor, []string, int, error) {
+	skeys := rec.ServiceQuery.SortKeys
+	if len(skeys) == 0 {
+		skeys = []string{defaultKey}
+	}
+	keys := cursorKeys(skeys, tieKeys)
+	order := rec.ServiceQuery.SortOrder
+	if order != 1 {
+		order = -1
+	}
+	rec.ServiceQuery.SortKeys = keys
+	rec.ServiceQuery.SortOrder = order
+	cur, err := requestCursor(c)
+	if err != nil || cur == nil {
+		return nil, keys, order, err
+	}
+	if err := applyCursor(rec, *cur); err != nil {
+		return nil, keys, order, err
+	}
+	return cur, cur.Keys, cur.Order, nil
+}
+
+// helper function to put records fetched with cursor in page order
+func cursorRecords(cur *Cursor, records []map[string]any) []map[string]any {
+	if cur == nil || !cur.Prev {
+		return records
+	}
+	out := make([]map[string]any, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		out = append(out, records[i])
+	}
+	return out
+}
+
+// PageCursors represents cursors of pages around the current one
+type PageCursors struct {
+	First string `json:"first"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last"`
+}
+
+// helper function to make cursors of first, previous, next and last pages
+// for given page of records which starts at idx
+func pageCursors(keys []string, order int, records []map[string]any, idx, limit, total int) PageCursors {
+	values := func(rec map[string]any) []any {
+		var vals []any
+		for _, key := range keys {
+			vals = append(vals, rec[key])
+		}
+		return vals
+	}
+	last := total - limit
+	if last < 0 {
+		last = 0
+	}
+	cursors := PageCursors{
+		First: Cursor{Keys: keys, Order: order, Idx: 0, Total: total}.Encode(),
+		Last:  Cursor{Keys: keys, Order: order, Prev: true, Idx: last, Total: total}.Encode(),
+	}
+	if len(records) == 0 {
+		return cursors
+	}
+	if idx > 0 {
+		prev := idx - limit
+		if prev < 0 {
+			prev = 0
+		}
+		cursors.Prev = Cursor{Keys: keys, Order: order, Values: values(records[0]),
+			Prev: true, Idx: prev, Total: total}.Encode()
+	}
+	if len(records) == limit && idx+limit < total {
+		cursors.Next = Cursor{Keys: keys, Order: order, Values: values(records[len(records)-1]),
+			Idx: idx + limit, Total: total}.Encode()
+	}
+	return cursors
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// TestCursorEncoding tests encoding and decoding of cursor tokens
+func TestCursorEncoding(t *testing.T) {
+	cur := Cursor{Keys: []string{"date", "did"}, Order: -1, Values: []any{float64(123), "/a/b"}, Idx: 10, Total: 42}
+	out, err := decodeCursor(cur.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cur, out) {
+		t.Errorf("expected %+v, got %+v", cur, out)
+	}
+	for _, token := range []string{"bla", "e30", Cursor{Keys: []string{"did"}, Order: 2}.Encode()} {
+		if _, err := decodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("token %s: expected invalid cursor error, got %v", token, err)
+		}
+	}
+}
+
+// TestCursorCondition tests keyset conditions of cursors
+func TestCursorCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []any
+		order    int
+		expected map[string]any
+	}{
+		{"ascending", []any{1, "x"}, 1, map[string]any{"$or": []any{
+			map[string]any{"date": map[string]any{"$gt": 1}},
+			map[string]any{"date": 1, "did": map[string]any{"$gt": "x"}},
+		}}},
+		{"descending", []any{1, "x"}, -1, map[string]any{"$or": []any{
+			map[string]any{"date": map[string]any{"$lt": 1}},
+			map[string]any{"date": nil},
+			map[string]any{"date": 1, "did": map[string]any{"$lt": "x"}},
+			map[string]any{"date": 1, "did": nil},
+		}}},
+		{"ascending null", []any{nil, "x"}, 1, map[string]any{"$or": []any{
+			map[string]any{"date": map[string]any{"$ne": nil}},
+			map[string]any{"date": nil, "did": map[string]any{"$gt": "x"}},
+		}}},
+		{"descending null", []any{nil, "x"}, -1, map[string]any{"$or": []any{
+			map[string]any{"date": nil, "did": map[string]any{"$lt": "x"}},
+			map[string]any{"date": nil, "did": nil},
+		}}},
+	}
+	for _, tt := range tests {
+		cond := cursorCondition([]string{"date", "did"}, tt.values, tt.order)
+		if !reflect.DeepEqual(cond, tt.expected) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.expected, cond)
+		}
+	}
+}
+
+// TestApplyCursor tests narrowing of service request with cursor
+func TestApplyCursor(t *testing.T) {
+	rec := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: `{"beamline":"3a"}`, Idx: 20}}
+	cur := Cursor{Keys: []string{"did"}, Order: 1, Values: []any{"x"}, Prev: true}
+	if err := applyCursor(&rec, cur); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"$and": []any{
+		map[string]any{"beamline": "3a"},
+		map[string]any{"$or": []any{map[string]any{"did": map[string]any{"$lt": "x"}}, map[string]any{"did": nil}}},
+	}}
+	if !reflect.DeepEqual(rec.ServiceQuery.Spec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rec.ServiceQuery.Spec)
+	}
+	if rec.ServiceQuery.SortOrder != -1 || rec.ServiceQuery.Idx != 0 {
+		t.Errorf("unexpected sort order %d or idx %d", rec.ServiceQuery.SortOrder, rec.ServiceQuery.Idx)
+	}
+}
+
+// TestPageCursors tests cursors of pages around the current one
+func TestPageCursors(t *testing.T) {
+	keys := []string{"date", "did"}
+	records := []map[string]any{{"date": 3, "did": "c"}, {"date": 2, "did": "b"}}
+	cursors := pageCursors(keys, -1, records, 2, 2, 7)
+	next, err := decodeCursor(cursors.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Idx != 4 || next.Prev || !reflect.DeepEqual(next.Values, []any{float64(2), "b"}) {
+		t.Errorf("unexpected next cursor %+v", next)
+	}
+	prev, err := decodeCursor(cursors.Prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Idx != 0 || !prev.Prev || !reflect.DeepEqual(prev.Values, []any{float64(3), "c"}) {
+		t.Errorf("unexpected prev cursor %+v", prev)
+	}
+	last, err := decodeCursor(cursors.Last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Idx != 5 || !last.Prev || last.Values != nil {
+		t.Errorf("unexpected last cursor %+v", last)
+	}
+	// last page does not have next cursor and first page does not have prev one
+	if c := pageCursors(keys, -1, records, 5, 2, 7); c.Next != "" {
+		t.Errorf("unexpected next cursor on last page")
+	}
+	if c := pageCursors(keys, -1, records, 0, 2, 7); c.Prev != "" {
+		t.Errorf("unexpected prev cursor on first page")
+	}
+	reversed := cursorRecords(&Cursor{Prev: true}, records)
+	if reversed[0]["did"] != "b" {
+		t.Errorf("prev cursor records should be reversed, got %+v", reversed)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+// explain module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The explain module provides explain (dry-run) mode of /search and /datasets
+// endpoints, enabled by explain=true parameter. Instead of records it returns
+// final spec sent to Discovery service, i.e. the spec after BTR constraints
+// (updateSpec), token restrictions and federated sub-queries are applied,
+// along with BTRs which were kept, dropped or added to the user query, sort
+// options and number of matching records. It helps users to understand why
+// their query returns no records.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// BtrExplanation explains how BTR constraints change the user query
+type BtrExplanation struct {
+	Keys         []string `json:"keys"`                // record keys used to restrict access, e.g. btr
+	Allowed      []string `json:"allowed"`             // BTRs (or groups) of the user
+	Requested    []string `json:"requested,omitempty"` // BTRs provided in user query
+	Kept         []string `json:"kept,omitempty"`      // requested BTRs which are allowed to the user
+	Dropped      []string `json:"dropped,omitempty"`   // requested BTRs which are not allowed to the user
+	Added        []string `json:"added,omitempty"`     // BTRs added since query did not provide any
+	Unrestricted bool     `json:"unrestricted"`        // true if user can see all records
+	Message      string   `json:"message,omitempty"`   // human readable summary
+}
+
+// Explanation represents explain mode results
+type Explanation struct {
+	Query     string          `json:"query,omitempty"` // user query
+	Spec      map[string]any  `json:"spec"`            // final spec sent to Discovery service
+	Btrs      *BtrExplanation `json:"btrs,omitempty"`
+	SortKeys  []string        `json:"sort_keys"`
+	SortOrder int             `json:"sort_order"`
+	Idx       int             `json:"idx"`
+	Limit     int             `json:"limit"`
+	NRecords  int             `json:"nrecords"`
+	Notes     []string        `json:"notes,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// helper function to make a copy of spec which is not affected by updateSpec
+func copySpec(spec map[string]any) map[string]any {
+	out := make(map[string]any)
+	if data, err := json.Marshal(spec); err == nil {
+		json.Unmarshal(data, &out)
+	}
+	return out
+}
+
+// helper function to extract BTR values from spec value
+func btrValues(val any) []string {
+	var out []string
+	switch v := val.(type) {
+	case string:
+		out = append(out, v)
+	case []string:
+		out = append(out, v...)
+	case []any:
+		for _, item := range v {
+			out = append(out, btrValues(item)...)
+		}
+	case map[string]any:
+		for key, item := range v {
+			if key == "$in" || key == "$or" || key == "$eq" {
+				out = append(out, btrValues(item)...)
+			}
+		}
+	}
+	return out
+}
+
+// helper function to subtract list b from list a
+func listDiff(a, b []string) []string {
+	var out []string
+	for _, v := range a {
+		if !utils.InList(v, b) && !utils.InList(v, out) {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// helper function to explain BTR constraints of given spec (as provided by
+// the user) for given access keys and list of allowed BTRs, it follows logic
+// of chessUpdateSpec and maglabUpdateSpec functions
+func explainBtrs(spec map[string]any, keys, allowed []string, admin bool, useCase string) BtrExplanation {
+	exp := BtrExplanation{Keys: keys, Allowed: allowed}
+	if admin {
+		exp.Unrestricted = true
+		exp.Message = "user is allowed to see all records, query is not restricted"
+		return exp
+	}
+	for _, key := range keys {
+		val, ok := spec[key]
+		if !ok {
+			continue
+		}
+		requested := btrValues(val)
+		exp.Requested = append(exp.Requested, requested...)
+		if useCase == "search" {
+			exp.Kept = append(exp.Kept, finalBtrs(val, allowed)...)
+		} else {
+			// filters are combined with all allowed BTRs
+			for _, btr := range requested {
+				if utils.InList(btr, allowed) {
+					exp.Kept = append(exp.Kept, btr)
+				}
+			}
+		}
+	}
+	exp.Dropped = listDiff(exp.Requested, exp.Kept)
+	if len(exp.Requested) == 0 || useCase != "search" {
+		exp.Added = allowed
+	}
+	switch {
+	case len(exp.Requested) > 0 && len(exp.Kept) == 0:
+		exp.Message = fmt.Sprintf("none of requested %s values are allowed to the user, query matches no records",
+			strings.Join(keys, "/"))
+	case len(exp.Dropped) > 0:
+		exp.Message = fmt.Sprintf("%s values %s are not allowed to the user and were dropped",
+			strings.Join(keys, "/"), strings.Join(exp.Dropped, ", "))
+	case len(allowed) == 0:
+		exp.Message = "user is not associated with any BTRs, query matches no records"
+	case len(exp.Requested) == 0:
+		exp.Message = fmt.Sprintf("query is restricted to %d %s values of the user", len(allowed), strings.Join(keys, "/"))
+	default:
+		exp.Message = "all requested values are allowed to the user"
+	}
+	return exp
+}
+
+// helper function to explain BTR constraints for given user, nil is returned
+// if BTR constraints are not applied in this deployment
+func userBtrExplanation(c *gin.Context, user string, spec map[string]any, useCase string) (*BtrExplanation, error) {
+	if user == "test" || !srvConfig.Config.Frontend.CheckBtrs || srvConfig.Config.Embed.DocDb != "" {
+		return nil, nil
+	}
+	fuser, err := getFoxdenUser(c, user)
+	if err != nil {
+		return nil, err
+	}
+	adminGroup := srvConfig.Config.AccessRules.AdminGroup
+	allowAdmins := srvConfig.Config.Frontend.CheckAdmins || srvConfig.Config.Frontend.AllowAllRecords
+	keys, allowed := accessAttributes(fuser)
+	admin := allowAdmins && utils.InList(adminGroup, fuser.FoxdenGroups)
+	if maglabDeployment() {
+		admin = allowAdmins && (utils.InList(adminGroup, fuser.FoxdenGroups) || utils.InList(adminGroup, fuser.Groups))
+	}
+	exp := explainBtrs(spec, keys, allowed, admin, useCase)
+	return &exp, nil
+}
+
+// helper function to explain service request, userSpec is spec provided by
+// the user before BTR constraints were applied
+func explainRequest(c *gin.Context, user, query string, userSpec map[string]any, rec services.ServiceRequest, useCase string) Explanation {
+	exp := Explanation{
+		Query:     query,
+		Spec:      rec.ServiceQuery.Spec,
+		SortKeys:  rec.ServiceQuery.SortKeys,
+		SortOrder: rec.ServiceQuery.SortOrder,
+		Idx:       rec.ServiceQuery.Idx,
+		Limit:     rec.ServiceQuery.Limit,
+	}
+	if exp.Spec == nil {
+		if err := json.Unmarshal([]byte(cleanQuery(rec.ServiceQuery.Query)), &exp.Spec); err != nil {
+			exp.Error = fmt.Sprintf("unable to parse query: %v", err)
+			return exp
+		}
+	}
+	btrs, err := userBtrExplanation(c, user, userSpec, useCase)
+	if err != nil {
+		exp.Notes = append(exp.Notes, fmt.Sprintf("unable to obtain user BTRs: %v", err))
+	} else if btrs == nil {
+		exp.Notes = append(exp.Notes, "BTR constraints are not applied")
+	}
+	exp.Btrs = btrs
+	if !requestRestrictions(c).Empty() {
+		exp.Notes = append(exp.Notes, "spec is narrowed by restrictions of the access token")
+	}
+	nrecords, err := numberOfRecords(rec)
+	if err != nil {
+		exp.Error = fmt.Sprintf("unable to count records: %v", err)
+	}
+	exp.NRecords = nrecords
+	return exp
+}
+
+// helper function to explain search request, it applies the same token
+// restrictions and federated sub-queries as processResults does
+func explainSearch(c *gin.Context, user, query string, userSpec map[string]any, rec services.ServiceRequest) {
+	var notes []string
+	if err := restrictRequest(c, &rec); err != nil {
+		handleError(c, http.StatusBadRequest, "unable to apply token restrictions", err)
+		return
+	}
+	before := rec.ServiceQuery.Query
+	partial, err := federateRequest(&rec)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "unable to federate query", err)
+		return
+	}
+	if before != rec.ServiceQuery.Query {
+		notes = append(notes, "query keys of other FOXDEN services were resolved into list of DIDs")
+	}
+	if partial {
+		notes = append(notes, federatedPartialNote)
+	}
+	exp := explainRequest(c, user, query, userSpec, rec, "search")
+	exp.Notes = append(exp.Notes, notes...)
+	if c.GetHeader("Accept") == "application/json" || c.Request.FormValue("format") == "json" {
+		c.JSON(http.StatusOK, exp)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Explain")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["User"] = user
+	tmpl["Query"] = query
+	tmpl["Explanation"] = exp
+	if data, err := json.MarshalIndent(exp.Spec, "", "   "); err == nil {
+		tmpl["Spec"] = string(data)
+	}
+	page := server.TmplPage(StaticFs, "explain.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+page+footer()))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestExplainBtrs tests explanation of BTR constraints
+func TestExplainBtrs(t *testing.T) {
+	allowed := []string{"btr1", "btr2"}
+	tests := []struct {
+		name     string
+		spec     map[string]any
+		admin    bool
+		useCase  string
+		expected BtrExplanation
+	}{
+		{"added", map[string]any{"beamline": "3a"}, false, "search", BtrExplanation{
+			Added: allowed}},
+		{"kept and dropped", map[string]any{"btr": map[string]any{"$in": []any{"btr1", "btr3"}}}, false, "search",
+			BtrExplanation{Requested: []string{"btr1", "btr3"}, Kept: []string{"btr1"}, Dropped: []string{"btr3"}}},
+		{"all dropped", map[string]any{"btr": "btr3"}, false, "search",
+			BtrExplanation{Requested: []string{"btr3"}, Dropped: []string{"btr3"}}},
+		{"filter", map[string]any{"btr": "btr2"}, false, "filter",
+			BtrExplanation{Requested: []string{"btr2"}, Kept: []string{"btr2"}, Added: allowed}},
+		{"admin", map[string]any{"btr": "btr3"}, true, "search", BtrExplanation{Unrestricted: true}},
+	}
+	for _, tt := range tests {
+		exp := explainBtrs(tt.spec, []string{"btr"}, allowed, tt.admin, tt.useCase)
+		if exp.Message == "" {
+			t.Errorf("%s: empty message", tt.name)
+		}
+		tt.expected.Keys = []string{"btr"}
+		tt.expected.Allowed = allowed
+		tt.expected.Message = exp.Message
+		if !reflect.DeepEqual(exp, tt.expected) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.expected, exp)
+		}
+	}
+}
+
+// TestCopySpec tests that spec copy is not affected by changes of original spec
+func TestCopySpec(t *testing.T) {
+	spec := map[string]any{"btr": "btr1"}
+	out := copySpec(spec)
+	spec["btr"] = map[string]any{"$in": []string{"btr1"}}
+	if out["btr"] != "btr1" {
+		t.Errorf("spec copy was modified, got %+v", out)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+// export module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The export module provides /export endpoint which streams search or
+// dstable results as CSV, TSV or NDJSON. It accepts either SearchHandler
+// parameters (query, sort_keys, sort_order) or DatasetsHandler parameters
+// (search, attrs, btr, caseInsensitive, sortKey, sortDirection), pages
+// through Discovery service and writes records as they arrive. Nested
+// fields are flattened into dot separated attributes, e.g. a.b.0.c, and
+// the same BTR restrictions as in search and dstable views are applied.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// export formats and their content types
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"tsv":    "text/tab-separated-values; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// helper function to flatten nested record into dot separated attributes
+func flattenRecord(prefix string, val any, out map[string]string) {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, item := range v {
+			flattenRecord(joinKey(prefix, key), item, out)
+		}
+	case []any:
+		// lists of scalar values are kept in single attribute
+		if scalarList(v) {
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprintf("%v", item))
+			}
+			out[prefix] = strings.Join(items, ";")
+			return
+		}
+		for idx, item := range v {
+			flattenRecord(joinKey(prefix, strconv.Itoa(idx)), item, out)
+		}
+	case []string:
+		out[prefix] = strings.Join(v, ";")
+	case nil:
+		out[prefix] = ""
+	case float64:
+		// JSON numbers are decoded as float64, avoid exponent notation for integers
+		out[prefix] = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		out[prefix] = fmt.Sprintf("%v", v)
+	}
+}
+
+// helper function to join parent and child keys of flattened record
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// helper function to check if all list items are scalars
+func scalarList(list []any) bool {
+	for _, item := range list {
+		switch item.(type) {
+		case map[string]any, []any:
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to get value of flattened record for given attribute, if
+// attribute refers to nested object we collect all its flattened values
+func flatValue(rec map[string]string, attr string) string {
+	if val, ok := rec[attr]; ok {
+		return val
+	}
+	var keys []string
+	for key := range rec {
+		if strings.HasPrefix(key, attr+".") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var items []string
+	for _, key := range keys {
+		items = append(items, fmt.Sprintf("%s=%s", strings.TrimPrefix(key, attr+"."), rec[key]))
+	}
+	return strings.Join(items, ";")
+}
+
+// helper function to build service request for search or dstable results from
+// HTTP request parameters, it is shared by export and facets endpoints
+func resultsRequest(c *gin.Context, user string) (services.ServiceRequest, []string, error) {
+	var attrs []string
+	if val := c.Query("attrs"); val != "" {
+		attrs = strings.Split(val, ",")
+	}
+	var sortKeys []string
+	sortOrder := -1
+	if val := c.Query("sort_keys"); val != "" {
+		for _, k := range strings.Split(val, ",") {
+			k = strings.Replace(k, "-ascending", "", -1)
+			k = strings.Replace(k, "-descending", "", -1)
+			sortKeys = append(sortKeys, k)
+		}
+	} else if val := c.Query("sortKey"); val != "" {
+		sortKeys = []string{val}
+	}
+	switch strings.ToLower(c.DefaultQuery("sort_order", c.Query("sortDirection"))) {
+	case "ascending", "asc", "1":
+		sortOrder = 1
+	}
+	if len(sortKeys) == 0 {
+		sortKeys = []string{"date"}
+	}
+	// results are paged in chunks, therefore we use did as tie-breaker to keep
+	// order of records with the same sort values stable between chunks
+
+	var spec map[string]any
+	useCase := "search"
+	if query := c.Query("query"); query != "" {
+		// search use-case, query can be provided either in JSON data-format or compact syntax
+		var err error
+		if spec, err = querySpec(query); err != nil {
+			return services.ServiceRequest{}, attrs, err
+		}
+	} else {
+		// dstable use-case, spec is made out of filter and list of attributes
+		useCase = "filter"
+		if len(attrs) == 0 {
+			attrs = []string{"beamline", "btr", "cycle", "sample_name", "date", "user"}
+		}
+		spec = makeSpec(c.Query("search"), attrs, c.Query("caseInsensitive") != "")
+		if btr := c.Query("btr"); btr != "" {
+			spec["btr"] = btr
+		}
+		spec = applyFacets(spec, facetFilters(c))
+		var err error
+		spec, err = applyColumnFilters(spec, columnFilters(c), schemaColumns(_smgr), c.Query("caseInsensitive") != "")
+		if err != nil {
+			return services.ServiceRequest{}, attrs, err
+		}
+		if !utils.InList("did", attrs) {
+			attrs = append(attrs, "did")
+		}
+	}
+	spec = restrictSpec(c, spec)
+	// request only user's specific data (check user attributes)
+	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
+		fuser, err := getFoxdenUser(c, user)
+		if err != nil {
+			return services.ServiceRequest{}, attrs, err
+		}
+		if useCase == "search" && !hasAccess(fuser) {
+			return services.ServiceRequest{}, attrs,
+				fmt.Errorf("[Frontend.main.resultsRequest] user %s is not associated with any BTRs", user)
+		}
+		spec = updateSpec(spec, fuser, useCase)
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return services.ServiceRequest{}, attrs, fmt.Errorf("[Frontend.main.resultsRequest] json.Marshal error: %w", err)
+	}
+	rec := services.ServiceRequest{
+		Client: "frontend",
+		ServiceQuery: services.ServiceQuery{
+			Query:     string(query),
+			Spec:      spec,
+			SortKeys:  cursorKeys(sortKeys, []string{"did"}),
+			SortOrder: sortOrder,
+		},
+	}
+	if useCase == "search" {
+		partial, err := federateRequest(&rec)
+		if err != nil {
+			return services.ServiceRequest{}, attrs, err
+		}
+		if partial {
+			c.Header("X-Federated-Partial", "true")
+		}
+	}
+	return rec, attrs, nil
+}
+
+// recordWriter writes exported records in specific format
+type recordWriter interface {
+	Write(records []map[string]any) error
+	Flush() error
+}
+
+// tableWriter writes records as CSV or TSV table
+type tableWriter struct {
+	writer *csv.Writer
+	attrs  []string
+}
+
+// Write writes records as table rows, if table columns are not known we use
+// sorted list of flattened attributes of the first chunk of records
+func (w *tableWriter) Write(records []map[string]any) error {
+	var rows []map[string]string
+	for _, rec := range records {
+		row := make(map[string]string)
+		flattenRecord("", rec, row)
+		rows = append(rows, row)
+	}
+	if len(w.attrs) == 0 {
+		keys := make(map[string]struct{})
+		for _, row := range rows {
+			for key := range row {
+				keys[key] = struct{}{}
+			}
+		}
+		for key := range keys {
+			w.attrs = append(w.attrs, key)
+		}
+		sort.Strings(w.attrs)
+		if err := w.writer.Write(w.attrs); err != nil {
+			return err
+		}
+	}
+	for _, row := range rows {
+		var values []string
+		for _, attr := range w.attrs {
+			values = append(values, flatValue(row, attr))
+		}
+		if err := w.writer.Write(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush flushes buffered table rows
+func (w *tableWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// ndjsonWriter writes records as new-line delimited JSON
+type ndjsonWriter struct {
+	encoder *json.Encoder
+	attrs   []string
+}
+
+// Write writes records as JSON lines, records are projected to given attributes
+func (w *ndjsonWriter) Write(records []map[string]any) error {
+	for _, rec := range records {
+		if len(w.attrs) > 0 {
+			prec := make(map[string]any)
+			for _, attr := range w.attrs {
+				if val, ok := rec[attr]; ok {
+					prec[attr] = val
+				}
+			}
+			rec = prec
+		}
+		if err := w.encoder.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush implements recordWriter interface
+func (w *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// helper function to create record writer for given format
+func newRecordWriter(w io.Writer, format string, attrs []string) recordWriter {
+	switch format {
+	case "ndjson":
+		return &ndjsonWriter{encoder: json.NewEncoder(w), attrs: attrs}
+	}
+	writer := csv.NewWriter(w)
+	if format == "tsv" {
+		writer.Comma = '\t'
+	}
+	if len(attrs) > 0 {
+		writer.Write(attrs)
+	}
+	return &tableWriter{writer: writer, attrs: attrs}
+}
+
+// ExportHandler provides access to GET /export endpoint
+func ExportHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	format := strings.ToLower(c.DefaultQuery("format", "csv"))
+	contentType, ok := exportFormats[format]
+	if !ok {
+		msg := fmt.Sprintf("unsupported export format %s, please use csv, tsv or ndjson", format)
+		handleError(c, http.StatusBadRequest, msg, errors.New("unsupported format"))
+		return
+	}
+	rec, attrs, err := resultsRequest(c, user)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "unable to create export request", err)
+		return
+	}
+	chunkSize := _config.ExportChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 500
+	}
+	maxRecords, _ := strconv.Atoi(c.Query("limit"))
+
+	var writer recordWriter
+	var total int
+	for idx := 0; ; idx += chunkSize {
+		if c.Request.Context().Err() != nil {
+			log.Printf("export of user %s is canceled after %d records", user, total)
+			return
+		}
+		rec.ServiceQuery.Idx = idx
+		rec.ServiceQuery.Limit = chunkSize
+		if maxRecords > 0 && maxRecords-total < chunkSize {
+			rec.ServiceQuery.Limit = maxRecords - total
+		}
+		resp, err := chunkOfRecords(rec)
+		if err == nil && resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+			err = fmt.Errorf("[Frontend.main.ExportHandler] discovery service error: %s", resp.Error)
+		}
+		if err != nil {
+			if writer == nil {
+				handleError(c, http.StatusBadRequest, "unable to export records", err)
+				return
+			}
+			// we already streamed part of the records, can only stop here
+			log.Printf("ERROR: export of user %s failed after %d records, error %v", user, total, err)
+			return
+		}
+		if writer == nil {
+			c.Header("Content-Type", contentType)
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"foxden_export.%s\"", format))
+			c.Status(http.StatusOK)
+			writer = newRecordWriter(c.Writer, format, attrs)
+		}
+		records := resp.Results.Records
+		if err := writer.Write(records); err != nil {
+			log.Printf("ERROR: unable to write export records, error %v", err)
+			return
+		}
+		if err := writer.Flush(); err != nil {
+			log.Printf("ERROR: unable to flush export records, error %v", err)
+			return
+		}
+		c.Writer.Flush()
+		total += len(records)
+		if len(records) < rec.ServiceQuery.Limit || (maxRecords > 0 && total >= maxRecords) {
+			break
+		}
+	}
+	if Verbose > 0 {
+		log.Printf("user %s exported %d records in %s format", user, total, format)
+	}
+}
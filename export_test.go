@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestFlattenRecord tests flattening of nested records
+func TestFlattenRecord(t *testing.T) {
+	rec := map[string]any{
+		"did":    "/beamline=3a",
+		"run":    float64(123456789),
+		"tags":   []any{"a", "b"},
+		"sample": map[string]any{"name": "x", "scans": []any{map[string]any{"id": float64(1)}}},
+		"empty":  nil,
+	}
+	out := make(map[string]string)
+	flattenRecord("", rec, out)
+
+	tests := []struct {
+		attr     string
+		expected string
+	}{
+		{attr: "did", expected: "/beamline=3a"},
+		{attr: "run", expected: "123456789"},
+		{attr: "tags", expected: "a;b"},
+		{attr: "sample.name", expected: "x"},
+		{attr: "sample.scans.0.id", expected: "1"},
+		{attr: "sample", expected: "name=x;scans.0.id=1"},
+		{attr: "empty", expected: ""},
+		{attr: "missing", expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.attr, func(t *testing.T) {
+			if val := flatValue(out, tt.attr); val != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, val)
+			}
+		})
+	}
+}
+
+// TestRecordWriter tests export record writers
+func TestRecordWriter(t *testing.T) {
+	records := []map[string]any{
+		{"did": "/a", "cycle": "2024-1", "user": "alice"},
+		{"did": "/b", "cycle": "2024-2", "user": "bob"},
+	}
+	tests := []struct {
+		format   string
+		attrs    []string
+		expected string
+	}{
+		{format: "csv", attrs: []string{"did", "cycle"}, expected: "did,cycle\n/a,2024-1\n/b,2024-2\n"},
+		{format: "tsv", attrs: nil, expected: "cycle\tdid\tuser\n2024-1\t/a\talice\n2024-2\t/b\tbob\n"},
+		{format: "ndjson", attrs: []string{"did"}, expected: "{\"did\":\"/a\"}\n{\"did\":\"/b\"}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := newRecordWriter(&buf, tt.format, tt.attrs)
+			if err := writer.Write(records); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+// TestResultsRequestSortKeys tests that results are sorted with did tie-breaker
+func TestResultsRequestSortKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	tests := []struct {
+		query    string
+		sortKeys []string
+	}{
+		{"", []string{"date", "did"}},
+		{"sortKey=beamline", []string{"beamline", "did"}},
+		{"sort_keys=date-descending,did", []string{"date", "did"}},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/export?"+tt.query, nil)
+		rec, _, err := resultsRequest(c, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec.ServiceQuery.SortKeys, tt.sortKeys) {
+			t.Errorf("%s: sort keys %v, want %v", tt.query, rec.ServiceQuery.SortKeys, tt.sortKeys)
+		}
+	}
+}
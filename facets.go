@@ -0,0 +1,201 @@
+package main
+
+// facets module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The facets module provides /facets endpoint which returns value counts of
+// configurable attributes (Frontend.FacetAttributes, by default beamline,
+// cycle, btr, schema and technique) for records matching search or dstable
+// request. Counts are obtained from the same BTR restricted spec as records
+// shown to the user, and at most Frontend.FacetMaxRecords records are used
+// to compute them. Selected facets are passed back to dstable and export
+// endpoints as facet=attr:value parameters which narrow the spec.
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// FacetValue represents single facet value and its count
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facet represents value counts of single attribute
+type Facet struct {
+	Name   string       `json:"name"`
+	Values []FacetValue `json:"values"`
+}
+
+// FacetResults represents facets of search results
+type FacetResults struct {
+	NRecords int     `json:"nrecords"` // number of records used to count facets
+	Partial  bool    `json:"partial"`  // true if not all matching records were counted
+	Facets   []Facet `json:"facets"`
+}
+
+// helper function to get list of facet attributes
+func facetAttributes(c *gin.Context) []string {
+	if val := c.Query("facets"); val != "" {
+		return strings.Split(val, ",")
+	}
+	if len(_config.FacetAttributes) > 0 {
+		return _config.FacetAttributes
+	}
+	return []string{"beamline", "cycle", "btr", "schema", "technique"}
+}
+
+// helper function to parse facet=attr:value parameters of HTTP request
+func facetFilters(c *gin.Context) map[string]string {
+	filters := make(map[string]string)
+	for _, val := range c.QueryArray("facet") {
+		arr := strings.SplitN(val, ":", 2)
+		if len(arr) != 2 || arr[0] == "" {
+			continue
+		}
+		filters[arr[0]] = arr[1]
+	}
+	return filters
+}
+
+// helper function to narrow spec with selected facets, facets are added as
+// separate $and conditions (like column filters) since BTR constraints of
+// filter use-case treat top-level keys of the spec as alternatives
+func applyFacets(spec map[string]any, filters map[string]string) map[string]any {
+	if len(filters) == 0 {
+		return spec
+	}
+	var attrs []string
+	for attr := range filters {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	var conds []any
+	if len(spec) > 0 {
+		conds = append(conds, spec)
+	}
+	for _, attr := range attrs {
+		conds = append(conds, map[string]any{attr: filters[attr]})
+	}
+	if len(conds) == 1 {
+		return conds[0].(map[string]any)
+	}
+	return map[string]any{"$and": conds}
+}
+
+// helper function to update facet counts with given records, list values
+// are counted per item
+func countFacets(records []map[string]any, attrs []string, counts map[string]map[string]int) {
+	for _, rec := range records {
+		for _, attr := range attrs {
+			val, ok := rec[attr]
+			if !ok || val == nil {
+				continue
+			}
+			if _, ok := counts[attr]; !ok {
+				counts[attr] = make(map[string]int)
+			}
+			switch v := val.(type) {
+			case []any:
+				for _, item := range v {
+					counts[attr][fmt.Sprintf("%v", item)]++
+				}
+			case []string:
+				for _, item := range v {
+					counts[attr][item]++
+				}
+			default:
+				counts[attr][fmt.Sprintf("%v", v)]++
+			}
+		}
+	}
+}
+
+// helper function to convert facet counts into list of facets, values are
+// ordered by their counts
+func makeFacets(attrs []string, counts map[string]map[string]int) []Facet {
+	var facets []Facet
+	for _, attr := range attrs {
+		facet := Facet{Name: attr}
+		for val, count := range counts[attr] {
+			facet.Values = append(facet.Values, FacetValue{Value: val, Count: count})
+		}
+		sort.Slice(facet.Values, func(i, j int) bool {
+			if facet.Values[i].Count == facet.Values[j].Count {
+				return facet.Values[i].Value < facet.Values[j].Value
+			}
+			return facet.Values[i].Count > facet.Values[j].Count
+		})
+		facets = append(facets, facet)
+	}
+	return facets
+}
+
+// helper function to count facets of records matching given service request,
+// at most maxRecords records are used to count facets
+func scanFacets(rec services.ServiceRequest, attrs []string, maxRecords int) (FacetResults, error) {
+	var results FacetResults
+	chunkSize := 1000
+	if chunkSize > maxRecords {
+		chunkSize = maxRecords
+	}
+	// we only need facet attributes of records, and records are paged in
+	// stable order to count every record once, see aggregateStats
+	projection := map[string]any{"did": 1}
+	for _, attr := range attrs {
+		projection[attr] = 1
+	}
+	rec.ServiceQuery.Projection = projection
+	rec.ServiceQuery.SortKeys = []string{"date", "did"}
+	rec.ServiceQuery.SortOrder = 1
+	counts := make(map[string]map[string]int)
+	for idx := 0; idx < maxRecords; idx += chunkSize {
+		rec.ServiceQuery.Idx = idx
+		rec.ServiceQuery.Limit = chunkSize
+		resp, err := chunkOfRecords(rec)
+		if err == nil && resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+			err = fmt.Errorf("[Frontend.main.scanFacets] discovery service error: %s", resp.Error)
+		}
+		if err != nil {
+			return results, err
+		}
+		records := resp.Results.Records
+		countFacets(records, attrs, counts)
+		results.NRecords += len(records)
+		if len(records) < chunkSize {
+			break
+		}
+		if idx+chunkSize >= maxRecords {
+			results.Partial = true
+		}
+	}
+	results.Facets = makeFacets(attrs, counts)
+	return results, nil
+}
+
+// FacetsHandler provides access to GET /facets endpoint
+func FacetsHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	rec, _, err := resultsRequest(c, user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxRecords := _config.FacetMaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 10000
+	}
+	results, err := scanFacets(rec, facetAttributes(c), maxRecords)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
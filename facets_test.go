@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// TestFacets tests facet counts of records
+func TestFacets(t *testing.T) {
+	records := []map[string]any{
+		{"beamline": []any{"3a"}, "cycle": "2024-1", "btr": "btr1"},
+		{"beamline": []any{"3a", "id1"}, "cycle": "2024-2", "btr": "btr1"},
+		{"beamline": []any{"id1"}, "cycle": "2024-2"},
+	}
+	counts := make(map[string]map[string]int)
+	countFacets(records, []string{"beamline", "cycle", "btr", "schema"}, counts)
+	facets := makeFacets([]string{"beamline", "cycle", "btr", "schema"}, counts)
+
+	expected := []Facet{
+		{Name: "beamline", Values: []FacetValue{{Value: "3a", Count: 2}, {Value: "id1", Count: 2}}},
+		{Name: "cycle", Values: []FacetValue{{Value: "2024-2", Count: 2}, {Value: "2024-1", Count: 1}}},
+		{Name: "btr", Values: []FacetValue{{Value: "btr1", Count: 2}}},
+		{Name: "schema"},
+	}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Errorf("expected %+v, got %+v", expected, facets)
+	}
+}
+
+// TestFacetFilters tests narrowing of spec with selected facets
+func TestFacetFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		url      string
+		spec     map[string]any
+		expected map[string]any
+	}{
+		{name: "No facets", url: "/datasets", spec: map[string]any{"btr": "btr1"},
+			expected: map[string]any{"btr": "btr1"}},
+		{name: "Facets", url: "/datasets?facet=beamline:3a&facet=cycle:2024-1&facet=bad", spec: nil,
+			expected: map[string]any{"$and": []any{
+				map[string]any{"beamline": "3a"}, map[string]any{"cycle": "2024-1"}}}},
+		{name: "Facet and spec", url: "/datasets?facet=beamline:3a", spec: map[string]any{"btr": "btr1"},
+			expected: map[string]any{"$and": []any{
+				map[string]any{"btr": "btr1"}, map[string]any{"beamline": "3a"}}}},
+		{name: "Facet value with colon", url: "/datasets?facet=schema:ID3A:1", spec: map[string]any{},
+			expected: map[string]any{"schema": "ID3A:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", tt.url, nil)
+			spec := applyFacets(tt.spec, facetFilters(c))
+			if !reflect.DeepEqual(spec, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, spec)
+			}
+		})
+	}
+}
+
+// helper function to match record against spec, it supports subset of
+// MongoDB operators produced by frontend specs
+func matchSpec(rec map[string]any, spec map[string]any) bool {
+	for key, val := range spec {
+		switch key {
+		case "$and", "$or":
+			conds := specFilters(val)
+			matched := key == "$and"
+			for _, cond := range conds {
+				if key == "$and" && !matchSpec(rec, cond) {
+					return false
+				}
+				if key == "$or" && matchSpec(rec, cond) {
+					matched = true
+				}
+			}
+			if !matched {
+				return false
+			}
+		default:
+			value := fmt.Sprintf("%v", rec[key])
+			switch cond := val.(type) {
+			case map[string]any:
+				if pat, ok := cond["$regex"].(*regexp.Regexp); ok && !pat.MatchString(value) {
+					return false
+				}
+				if list, ok := cond["$in"].([]string); ok && !utils.InList(value, list) {
+					return false
+				}
+			default:
+				if value != fmt.Sprintf("%v", cond) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// TestFacetsUpdateSpec tests that facets narrow dstable spec after BTR
+// constraints of filter use-case are applied
+func TestFacetsUpdateSpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	records := []map[string]any{
+		{"did": "/a", "beamline": "3a", "cycle": "2024-1", "btr": "btr1", "sample_name": "Ti"},
+		{"did": "/b", "beamline": "3a", "cycle": "2024-2", "btr": "btr1", "sample_name": "Ti"},
+		{"did": "/c", "beamline": "id1", "cycle": "2024-1", "btr": "btr1", "sample_name": "Cu"},
+		{"did": "/d", "beamline": "3a", "cycle": "2024-1", "btr": "btr2", "sample_name": "Ti"},
+	}
+	fuser := services.User{Btrs: []string{"btr1"}}
+	tests := []struct {
+		name   string
+		url    string
+		search string
+		dids   []string
+	}{
+		{"single facet", "/datasets?facet=beamline:3a", "", []string{"/a", "/b"}},
+		{"two facets", "/datasets?facet=beamline:3a&facet=cycle:2024-1", "", []string{"/a"}},
+		{"facet and search", "/datasets?facet=cycle:2024-1", "Cu", []string{"/c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", tt.url, nil)
+			spec := makeSpec(tt.search, []string{"beamline", "sample_name"}, false)
+			spec = applyFacets(spec, facetFilters(c))
+			spec = updateSpec(spec, fuser, "filter")
+			var dids []string
+			for _, rec := range records {
+				if matchSpec(rec, spec) {
+					dids = append(dids, rec["did"].(string))
+				}
+			}
+			if !reflect.DeepEqual(dids, tt.dids) {
+				t.Errorf("expected %v, got %v for spec %+v", tt.dids, dids, spec)
+			}
+		})
+	}
+}
+
+// TestScanFacets tests that facets are counted from projected records in stable order
+func TestScanFacets(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	var query services.ServiceQuery
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec services.ServiceRequest
+		json.NewDecoder(r.Body).Decode(&rec)
+		query = rec.ServiceQuery
+		var records []map[string]any
+		for i := rec.ServiceQuery.Idx; i < 3 && i < rec.ServiceQuery.Idx+rec.ServiceQuery.Limit; i++ {
+			records = append(records, map[string]any{"did": fmt.Sprintf("/%d", i), "beamline": "3a"})
+		}
+		json.NewEncoder(w).Encode(services.ServiceResponse{Results: services.ServiceResults{Records: records}})
+	}))
+	defer srv.Close()
+
+	srvServices := srvConfig.Config.Services
+	httpRequest := _httpReadRequest
+	defer func() {
+		srvConfig.Config.Services = srvServices
+		_httpReadRequest = httpRequest
+	}()
+	srvConfig.Config.Services.DiscoveryURL = srv.URL
+	_httpReadRequest = &services.HttpRequest{Token: "token", Expires: time.Now().Add(time.Hour)}
+
+	rec := services.ServiceRequest{ServiceQuery: services.ServiceQuery{SortKeys: []string{"beamline"}, SortOrder: -1}}
+	results, err := scanFacets(rec, []string{"beamline", "cycle"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.NRecords != 3 || results.Partial {
+		t.Errorf("unexpected results %+v", results)
+	}
+	projection := map[string]any{"did": float64(1), "beamline": float64(1), "cycle": float64(1)}
+	if !reflect.DeepEqual(query.Projection, projection) {
+		t.Errorf("projection %v, want %v", query.Projection, projection)
+	}
+	if !reflect.DeepEqual(query.SortKeys, []string{"date", "did"}) || query.SortOrder != 1 {
+		t.Errorf("sort keys %v order %d", query.SortKeys, query.SortOrder)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+// federated module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The federated module provides federated search across FOXDEN services.
+// Service map (static/config/service_map_file.json or Frontend.ServiceMapFile)
+// defines which query keys belong to which service. User query is split by
+// key ownership, sub-queries of Provenance, SpecScans and UserMetaData
+// services are sent concurrently to the owning services and their results
+// are joined on DID with MetaData part of the query which is resolved by
+// Discovery service, e.g. the query
+//
+//	{"beamline":"3a", "motor":"samx", "position":{"$gt":5}, "config":"foo"}
+//
+// finds MetaData records of beamline 3a whose DIDs have spec scans with samx
+// motor position above 5 and provenance config foo.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// ServiceMap defines which query keys belong to FOXDEN services
+type ServiceMap map[string][]string
+
+// _serviceMap holds query keys ownership of FOXDEN services
+var _serviceMap = ServiceMap{}
+
+// metaDataService is the service which owns keys not listed in service map,
+// its part of the query is resolved by Discovery service
+const metaDataService = "MetaData"
+
+// federatedPartialNote is shown to the user when federated results are incomplete
+const federatedPartialNote = "Results are partial: sub-queries of other FOXDEN services matched too many records and only part of them was joined, please narrow your query"
+
+// userMetaDataKey is the key under which user meta-data is stored in records,
+// sub-keys of it are queried directly in UserMetaData service
+const userMetaDataKey = "user_metadata"
+
+// helper function to load service map, by default we use service map
+// embedded into static area, it can be overwritten by Frontend.ServiceMapFile
+func initServiceMap() {
+	var data []byte
+	var err error
+	if _config.ServiceMapFile != "" {
+		data, err = os.ReadFile(_config.ServiceMapFile)
+	} else {
+		data, err = StaticFs.ReadFile("static/config/service_map_file.json")
+	}
+	if err != nil {
+		log.Fatalf("unable to read service map, error %v", err)
+	}
+	smap := ServiceMap{}
+	if err := json.Unmarshal(data, &smap); err != nil {
+		log.Fatalf("unable to parse service map, error %v", err)
+	}
+	_serviceMap = smap
+}
+
+// helper function to get URL of service which resolves federated sub-queries
+func federatedServiceURL(srv string) (string, error) {
+	var rurl string
+	switch srv {
+	case "Provenance":
+		rurl = srvConfig.Config.Services.DataBookkeepingURL
+	case "SpecScans":
+		rurl = srvConfig.Config.Services.SpecScansURL
+	case "UserMetaData":
+		rurl = srvConfig.Config.Services.UserMetaDataURL
+	default:
+		return "", fmt.Errorf("[Frontend.main.federatedServiceURL] service %s does not support search", srv)
+	}
+	if rurl == "" {
+		return "", fmt.Errorf("[Frontend.main.federatedServiceURL] service %s is not configured", srv)
+	}
+	return rurl, nil
+}
+
+// helper function to find service which owns given query key, the key is
+// owned by a service if it is listed in service map or it is a sub-key of
+// listed key. The did key is shared by all services and empty string is
+// returned for it.
+func keyOwner(key string, smap ServiceMap) string {
+	if key == "did" {
+		return ""
+	}
+	// user meta-data is embedded in MetaData records unless there is dedicated service
+	if srvConfig.Config != nil && srvConfig.Config.Services.UserMetaDataURL != "" &&
+		(key == userMetaDataKey || strings.HasPrefix(key, userMetaDataKey+".")) {
+		return "UserMetaData"
+	}
+	var srvs []string
+	for srv := range smap {
+		if srv != metaDataService {
+			srvs = append(srvs, srv)
+		}
+	}
+	sort.Strings(srvs)
+	for _, srv := range srvs {
+		for _, skey := range smap[srv] {
+			if skey != "did" && (key == skey || strings.HasPrefix(key, skey+".")) {
+				return srv
+			}
+		}
+	}
+	return metaDataService
+}
+
+// helper function to find owners of all keys used in given condition
+func conditionOwners(val any, smap ServiceMap, owners map[string]bool) {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if !strings.HasPrefix(key, "$") {
+				if owner := keyOwner(key, smap); owner != "" {
+					owners[owner] = true
+				}
+			}
+			conditionOwners(item, smap, owners)
+		}
+	case []any:
+		for _, item := range v {
+			conditionOwners(item, smap, owners)
+		}
+	case []map[string]any:
+		for _, item := range v {
+			conditionOwners(item, smap, owners)
+		}
+	}
+}
+
+// helper function to get list of conditions of $and operator, conditions
+// decoded from JSON are []any while specs built by frontend, e.g. by token
+// restrictions or MagLab access rules, use []map[string]any
+func andConditions(val any) ([]map[string]any, error) {
+	switch v := val.(type) {
+	case []map[string]any:
+		return v, nil
+	case []any:
+		conds := make([]map[string]any, 0, len(v))
+		for _, item := range v {
+			cond, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("[Frontend.main.andConditions] $and expects list of conditions, got %v", item)
+			}
+			conds = append(conds, cond)
+		}
+		return conds, nil
+	}
+	return nil, fmt.Errorf("[Frontend.main.andConditions] $and expects list of conditions, got %v", val)
+}
+
+// helper function to split query spec into service specs by key ownership,
+// top-level keys and conditions of top-level $and are split while other
+// logical conditions must use keys of a single service. Conditions on did
+// are added to every service spec.
+func splitSpec(spec map[string]any, smap ServiceMap) (map[string]map[string]any, error) {
+	specs := make(map[string]map[string]any)
+	var shared []map[string]any
+	add := func(srv, key string, val any) {
+		sspec, ok := specs[srv]
+		if !ok {
+			sspec = make(map[string]any)
+			specs[srv] = sspec
+		}
+		if key == "$and" {
+			sspec[key] = append(asList(sspec[key]), val.([]any)...)
+		} else if _, ok := sspec[key]; ok {
+			// repeated key, e.g. coming from different $and conditions
+			sspec["$and"] = append(asList(sspec["$and"]), map[string]any{key: val})
+		} else {
+			sspec[key] = val
+		}
+	}
+	var walk func(spec map[string]any) error
+	walk = func(spec map[string]any) error {
+		for key, val := range spec {
+			if key == "$and" {
+				conds, err := andConditions(val)
+				if err != nil {
+					return err
+				}
+				for _, cspec := range conds {
+					if err := walk(cspec); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			owners := make(map[string]bool)
+			if strings.HasPrefix(key, "$") {
+				conditionOwners(val, smap, owners)
+			} else if owner := keyOwner(key, smap); owner != "" {
+				owners[owner] = true
+			}
+			switch len(owners) {
+			case 0:
+				shared = append(shared, map[string]any{key: val})
+			case 1:
+				for srv := range owners {
+					if strings.HasPrefix(key, "$") {
+						// logical conditions of the same service are combined with $and
+						add(srv, "$and", []any{map[string]any{key: val}})
+					} else {
+						add(srv, key, val)
+					}
+				}
+			default:
+				var srvs []string
+				for srv := range owners {
+					srvs = append(srvs, srv)
+				}
+				sort.Strings(srvs)
+				return fmt.Errorf("[Frontend.main.splitSpec] condition %s combines keys of %s services, it can't be federated",
+					key, strings.Join(srvs, ", "))
+			}
+		}
+		return nil
+	}
+	if err := walk(spec); err != nil {
+		return nil, err
+	}
+	if _, ok := specs[metaDataService]; !ok {
+		specs[metaDataService] = make(map[string]any)
+	}
+	// shared did conditions narrow every service spec
+	for srv := range specs {
+		for _, cond := range shared {
+			for key, val := range cond {
+				add(srv, key, val)
+			}
+		}
+		if srv == "UserMetaData" {
+			specs[srv] = stripKeyPrefix(specs[srv], userMetaDataKey+".").(map[string]any)
+		}
+	}
+	return specs, nil
+}
+
+// helper function to convert value to list of conditions
+func asList(val any) []any {
+	if list, ok := val.([]any); ok {
+		return list
+	}
+	return []any{}
+}
+
+// helper function to remove given prefix from all keys of the spec
+func stripKeyPrefix(val any, prefix string) any {
+	switch v := val.(type) {
+	case map[string]any:
+		out := make(map[string]any)
+		for key, item := range v {
+			out[strings.TrimPrefix(key, prefix)] = stripKeyPrefix(item, prefix)
+		}
+		return out
+	case []any:
+		var out []any
+		for _, item := range v {
+			out = append(out, stripKeyPrefix(item, prefix))
+		}
+		return out
+	case []map[string]any:
+		var out []any
+		for _, item := range v {
+			out = append(out, stripKeyPrefix(item, prefix))
+		}
+		return out
+	}
+	return val
+}
+
+// helper function to get records out of service response, services either
+// return ServiceResponse or plain list of records
+func serviceRecords(data []byte) ([]map[string]any, error) {
+	var records []map[string]any
+	if err := json.Unmarshal(data, &records); err == nil {
+		return records, nil
+	}
+	var resp services.ServiceResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("[Frontend.main.serviceRecords] json.Unmarshal error: %w", err)
+	}
+	if resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+		return nil, fmt.Errorf("[Frontend.main.serviceRecords] service error: %s", resp.Error)
+	}
+	return resp.Results.Records, nil
+}
+
+// helper function to find DIDs of records matching given spec in given
+// service, it also reports if sub-query matches more than maxRecords records
+// and its DIDs are truncated
+func serviceDids(srv string, spec map[string]any, maxRecords int) (map[string]bool, bool, error) {
+	rurl, err := federatedServiceURL(srv)
+	if err != nil {
+		return nil, false, err
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("[Frontend.main.serviceDids] json.Marshal error: %w", err)
+	}
+	rec := services.ServiceRequest{
+		Client: "frontend",
+		ServiceQuery: services.ServiceQuery{
+			Query:      string(query),
+			Spec:       spec,
+			Projection: map[string]any{"did": 1},
+			// one extra record tells us if sub-query results are truncated
+			Limit: maxRecords + 1,
+		},
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, fmt.Errorf("[Frontend.main.serviceDids] json.Marshal error: %w", err)
+	}
+	resp, err := _httpReadRequest.Post(rurl+"/search", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("[Frontend.main.serviceDids] %s search error: %w", srv, err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("[Frontend.main.serviceDids] io.ReadAll error: %w", err)
+	}
+	records, err := serviceRecords(data)
+	if err != nil {
+		return nil, false, err
+	}
+	partial := len(records) > maxRecords
+	if partial {
+		log.Printf("WARNING: %s sub-query %s matches more than %d records, results are truncated", srv, query, maxRecords)
+		records = records[:maxRecords]
+	}
+	dids := make(map[string]bool)
+	for _, r := range records {
+		if did, ok := r["did"].(string); ok && did != "" {
+			dids[did] = true
+		}
+	}
+	return dids, partial, nil
+}
+
+// helper function to resolve federated sub-queries concurrently and join
+// their DIDs with MetaData spec, it reports if any sub-query was truncated
+func federatedSpec(specs map[string]map[string]any, maxRecords int,
+	lookup func(srv string, spec map[string]any, maxRecords int) (map[string]bool, bool, error)) (map[string]any, bool, error) {
+	var srvs []string
+	for srv := range specs {
+		if srv != metaDataService {
+			srvs = append(srvs, srv)
+		}
+	}
+	sort.Strings(srvs)
+	results := make([]map[string]bool, len(srvs))
+	truncated := make([]bool, len(srvs))
+	errs := make([]error, len(srvs))
+	var wg sync.WaitGroup
+	for i, srv := range srvs {
+		wg.Add(1)
+		go func(i int, srv string) {
+			defer wg.Done()
+			results[i], truncated[i], errs[i] = lookup(srv, specs[srv], maxRecords)
+		}(i, srv)
+	}
+	wg.Wait()
+	var dids map[string]bool
+	var partial bool
+	for i, srv := range srvs {
+		if errs[i] != nil {
+			return nil, false, fmt.Errorf("[Frontend.main.federatedSpec] %s sub-query error: %w", srv, errs[i])
+		}
+		partial = partial || truncated[i]
+		if dids == nil {
+			dids = results[i]
+			continue
+		}
+		for did := range dids {
+			if !results[i][did] {
+				delete(dids, did)
+			}
+		}
+	}
+	didList := []any{}
+	for did := range dids {
+		didList = append(didList, did)
+	}
+	sort.Slice(didList, func(i, j int) bool { return didList[i].(string) < didList[j].(string) })
+	cond := map[string]any{"did": map[string]any{"$in": didList}}
+	spec := specs[metaDataService]
+	if len(spec) == 0 {
+		return cond, partial, nil
+	}
+	return map[string]any{"$and": []any{spec, cond}}, partial, nil
+}
+
+// helper function to federate service request, if its query uses keys of
+// services other than MetaData the request spec is replaced by MetaData spec
+// joined with DIDs matching sub-queries of other services. It reports if
+// results are partial, i.e. some sub-query matched more than
+// Frontend.FederatedMaxRecords records and its DIDs were truncated.
+func federateRequest(rec *services.ServiceRequest) (bool, error) {
+	spec := rec.ServiceQuery.Spec
+	if spec == nil {
+		if query := cleanQuery(rec.ServiceQuery.Query); query != "" {
+			if err := json.Unmarshal([]byte(query), &spec); err != nil {
+				// malformed queries are reported by the caller
+				return false, nil
+			}
+		}
+	}
+	specs, err := splitSpec(spec, _serviceMap)
+	if err != nil {
+		return false, err
+	}
+	if len(specs) == 1 {
+		// query only uses MetaData keys, there is nothing to federate
+		return false, nil
+	}
+	maxRecords := _config.FederatedMaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 10000
+	}
+	_httpReadRequest.GetToken()
+	spec, partial, err := federatedSpec(specs, maxRecords, serviceDids)
+	if err != nil {
+		return false, err
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return false, fmt.Errorf("[Frontend.main.federateRequest] json.Marshal error: %w", err)
+	}
+	if Verbose > 0 {
+		log.Printf("federated query %s", query)
+	}
+	rec.ServiceQuery.Spec = spec
+	rec.ServiceQuery.Query = string(query)
+	return partial, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// testServiceMap represents service map used in federated search tests
+var testServiceMap = ServiceMap{
+	"MetaData":   {"did", "beamline", "cycle"},
+	"Provenance": {"did", "config", "file"},
+	"SpecScans":  {"motor", "position", "did"},
+}
+
+// TestSplitSpec tests split of query spec by key ownership
+func TestSplitSpec(t *testing.T) {
+	spec := map[string]any{
+		"beamline": "3a",
+		"did":      "/a/b",
+		"$and": []any{
+			map[string]any{"motor": "samx"},
+			map[string]any{"position": map[string]any{"$gt": 5.0}},
+			map[string]any{"cycle": "2024-1"},
+		},
+		"$or": []any{map[string]any{"config": "foo"}, map[string]any{"file": "bar"}},
+	}
+	specs, err := splitSpec(spec, testServiceMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]any{
+		"MetaData": {"beamline": "3a", "cycle": "2024-1", "did": "/a/b"},
+		"SpecScans": {
+			"motor": "samx", "position": map[string]any{"$gt": 5.0}, "did": "/a/b",
+		},
+		"Provenance": {
+			"$and": []any{map[string]any{
+				"$or": []any{map[string]any{"config": "foo"}, map[string]any{"file": "bar"}},
+			}},
+			"did": "/a/b",
+		},
+	}
+	if !reflect.DeepEqual(specs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, specs)
+	}
+
+	// logical conditions can't combine keys of different services
+	spec = map[string]any{"$or": []any{map[string]any{"beamline": "3a"}, map[string]any{"motor": "samx"}}}
+	if _, err := splitSpec(spec, testServiceMap); err == nil {
+		t.Error("expected error for $or across services")
+	}
+
+	// queries with MetaData keys only are not federated
+	specs, err = splitSpec(map[string]any{"beamline": "3a", "foo": 1}, testServiceMap)
+	if err != nil || len(specs) != 1 {
+		t.Errorf("unexpected federation of MetaData query %+v, error %v", specs, err)
+	}
+}
+
+// TestFederatedSpec tests join of federated sub-queries on did
+func TestFederatedSpec(t *testing.T) {
+	specs := map[string]map[string]any{
+		"MetaData":   {"beamline": "3a"},
+		"SpecScans":  {"motor": "samx"},
+		"Provenance": {"config": "foo"},
+	}
+	lookup := func(srv string, spec map[string]any, maxRecords int) (map[string]bool, bool, error) {
+		if srv == "SpecScans" {
+			return map[string]bool{"/a": true, "/b": true, "/c": true}, false, nil
+		}
+		return map[string]bool{"/b": true, "/c": true, "/d": true}, srv == "Provenance", nil
+	}
+	spec, partial, err := federatedSpec(specs, 10, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !partial {
+		t.Error("truncated sub-query should make results partial")
+	}
+	expected := map[string]any{"$and": []any{
+		map[string]any{"beamline": "3a"},
+		map[string]any{"did": map[string]any{"$in": []any{"/b", "/c"}}},
+	}}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, spec)
+	}
+
+	failure := func(srv string, spec map[string]any, maxRecords int) (map[string]bool, bool, error) {
+		return nil, false, errors.New("service is down")
+	}
+	if _, _, err := federatedSpec(specs, 10, failure); err == nil {
+		t.Error("expected error of failed sub-query")
+	}
+}
+
+// TestServiceRecords tests parsing of service responses
+func TestServiceRecords(t *testing.T) {
+	expected := []map[string]any{{"did": "/a"}}
+	for _, data := range []string{
+		`[{"did":"/a"}]`,
+		`{"http_code":200,"results":{"nrecords":1,"records":[{"did":"/a"}]}}`,
+	} {
+		records, err := serviceRecords([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("expected %+v, got %+v", expected, records)
+		}
+	}
+}
+
+// TestFederateRestrictedRequest tests federation of requests narrowed by
+// token restrictions whose $and conditions are built by frontend
+func TestFederateRestrictedRequest(t *testing.T) {
+	smap := _serviceMap
+	_serviceMap = testServiceMap
+	defer func() { _serviceMap = smap }()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/search", nil)
+	c.Set("restrictions", &TokenRestrictions{Btrs: []string{"btr1"}, DidPrefix: "/beamline=3a"})
+	rec := services.ServiceRequest{
+		ServiceQuery: services.ServiceQuery{Spec: map[string]any{"beamline": "3a"}},
+	}
+	if err := restrictRequest(c, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.ServiceQuery.Spec["$and"].([]map[string]any); !ok {
+		t.Fatalf("unexpected restricted spec %+v", rec.ServiceQuery.Spec)
+	}
+	before := rec.ServiceQuery.Query
+	partial, err := federateRequest(&rec)
+	if err != nil {
+		t.Fatalf("unable to federate restricted request: %v", err)
+	}
+	if partial || rec.ServiceQuery.Query != before {
+		t.Errorf("MetaData only query should not be federated, got %s", rec.ServiceQuery.Query)
+	}
+
+	// restricted conditions are split by key ownership as well
+	spec := restrictSpec(c, map[string]any{"beamline": "3a", "motor": "samx"})
+	specs, err := splitSpec(spec, testServiceMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs["SpecScans"]["motor"] != "samx" || specs["MetaData"]["beamline"] != "3a" {
+		t.Errorf("unexpected split of restricted spec %+v", specs)
+	}
+	if _, ok := specs["MetaData"]["btr"]; !ok {
+		t.Errorf("token restrictions are lost in MetaData spec %+v", specs["MetaData"])
+	}
+}
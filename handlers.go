@@ -66,7 +66,9 @@ func GithubOauthLoginHandler(c *gin.Context) {
 
 // GithubCallBackHandler provides kerberos authentication handler
 func GithubCallBackHandler(c *gin.Context) {
-	authz.GithubCallBack(c, DEFAULT_END_POINT, Verbose)
+	oauthSession(c, func(c *gin.Context) {
+		authz.GithubCallBack(c, DEFAULT_END_POINT, Verbose)
+	})
 }
 
 // GoogleOauthLoginHandler provides kerberos authentication handler
@@ -76,7 +78,9 @@ func GoogleOauthLoginHandler(c *gin.Context) {
 
 // GoogleCallBackHandler provides kerberos authentication handler
 func GoogleCallBackHandler(c *gin.Context) {
-	authz.GoogleCallBack(c, DEFAULT_END_POINT, Verbose)
+	oauthSession(c, func(c *gin.Context) {
+		authz.GoogleCallBack(c, DEFAULT_END_POINT, Verbose)
+	})
 }
 
 // FacebookOauthLoginHandler provides kerberos authentication handler
@@ -86,7 +90,9 @@ func FacebookOauthLoginHandler(c *gin.Context) {
 
 // FacebookCallBackHandler provides kerberos authentication handler
 func FacebookCallBackHandler(c *gin.Context) {
-	authz.FacebookCallBack(c, DEFAULT_END_POINT, Verbose)
+	oauthSession(c, func(c *gin.Context) {
+		authz.FacebookCallBack(c, DEFAULT_END_POINT, Verbose)
+	})
 }
 
 // helper function to get user from gin context
@@ -96,6 +102,10 @@ func getUser(c *gin.Context) (string, error) {
 	if srvConfig.Config.Frontend.TestMode {
 		return "test", nil
 	}
+	// user already authenticated by policy middleware
+	if user = c.GetString("user"); user != "" {
+		return user, nil
+	}
 	token := authz.BearerToken(c.Request)
 	if token != "" {
 		// if we received HTTP request with token
@@ -105,9 +115,19 @@ func getUser(c *gin.Context) (string, error) {
 			log.Printf("Token=%s user=%s, error=%v", token, user, e)
 			log.Println("Claims", claims)
 		}
+		if e == nil {
+			// reject tokens revoked via token registry
+			if err := _tokenRegistry.Check(claims); err != nil {
+				return "", fmt.Errorf("[Frontend.main.getUser] error: %w", err)
+			}
+			// enforce restrictions of fine-grained tokens
+			if err := checkRestrictions(c, token); err != nil {
+				return "", fmt.Errorf("[Frontend.main.getUser] error: %w", err)
+			}
+		}
 		return user, e
 	}
-	user, err = c.Cookie("user")
+	user, err = sessionUser(c)
 	if err != nil {
 		return user, fmt.Errorf("[Frontend.main.getUser] error: %w", err)
 	}
@@ -121,8 +141,7 @@ func getUser(c *gin.Context) (string, error) {
 
 // KAuthHandler provides kerberos authentication handler
 func KAuthHandler(c *gin.Context) {
-	// get http request/writer
-	w := c.Writer
+	// get http request
 	r := c.Request
 	redirectTo := c.GetHeader("Referer")
 	if redirectTo == "" {
@@ -137,21 +156,21 @@ func KAuthHandler(c *gin.Context) {
 		log.Println("redirect HTTP request to:", redirectTo)
 	}
 
-	//     user, err := c.Cookie("user")
 	user, err := getUser(c)
 	if err == nil && user != "" {
-		log.Println("found user cookie", user)
+		log.Println("found user session", user)
 		c.Redirect(http.StatusFound, "/dstable")
 		return
 	}
 
-	expiration := time.Now().Add(24 * time.Hour)
 	// in test mode we'll set user as TestUser
 	if srvConfig.Config.Frontend.TestMode {
 		log.Println("frontend test mode")
-		c.Set("user", "TestUser")
-		cookie := http.Cookie{Name: "user", Value: "TestUser", Expires: expiration}
-		http.SetCookie(w, &cookie)
+		if err := startSession(c, "TestUser"); err != nil {
+			content := server.ErrorPage(StaticFs, "unable to start user session", err)
+			c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", []byte(header()+content+footer()))
+			return
+		}
 		c.Redirect(http.StatusFound, DEFAULT_END_POINT)
 		return
 	}
@@ -167,8 +186,17 @@ func KAuthHandler(c *gin.Context) {
 	password := r.FormValue("password")
 	var creds *credentials.Credentials
 	if name != "" && password != "" {
+		ip := c.ClientIP()
+		if wait, err := _loginThrottle.Allow(name, ip); err != nil {
+			log.Printf("SECURITY: rejected login attempt user=%s ip=%s, error %v", name, ip, err)
+			c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+			content := server.ErrorPage(StaticFs, "login is temporarily not allowed", err)
+			c.Data(http.StatusTooManyRequests, "text/html; charset=utf-8", []byte(header()+content+footer()))
+			return
+		}
 		creds, err = kuser(name, password)
 		if err != nil {
+			_loginThrottle.Failure(name, ip)
 			content := server.ErrorPage(StaticFs, "wrong user credentials", err)
 			c.Data(http.StatusBadRequest, "text/html; charset=utf-8", []byte(header()+content+footer()))
 			return
@@ -184,11 +212,15 @@ func KAuthHandler(c *gin.Context) {
 		return
 	}
 
-	// store user name in c.Context
-	c.Set("user", name)
-	cookie := http.Cookie{Name: "user", Value: name, Expires: expiration}
-	http.SetCookie(w, &cookie)
-	log.Println("KAuthHandler set cookie user", name)
+	_loginThrottle.Success(name)
+
+	// start new user session, it also stores user name in c.Context
+	if err := startSession(c, name); err != nil {
+		content := server.ErrorPage(StaticFs, "unable to start user session", err)
+		c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", []byte(header()+content+footer()))
+		return
+	}
+	log.Println("KAuthHandler started session for user", name)
 	c.Redirect(http.StatusFound, redirectTo)
 }
 
@@ -199,8 +231,15 @@ func MainHandler(c *gin.Context) {
 	user, err := getUser(c)
 	if err == nil {
 		c.Set("user", user)
-		// switch to default handler
-		DatasetsTableHandler(c)
+		// switch to default view of the user
+		switch userPreferences(user).SearchView {
+		case "search":
+			SearchHandler(c)
+		case "specscans":
+			SpecScansHandler(c)
+		default:
+			DatasetsTableHandler(c)
+		}
 		//         ServicesHandler(c)
 	} else {
 		LoginHandler(c)
@@ -219,6 +258,11 @@ func NotImplementedHandler(c *gin.Context) {
 
 // LoginHandler provides access to GET /login endpoint
 func LoginHandler(c *gin.Context) {
+	loginPage(c, http.StatusOK)
+}
+
+// helper function to render login page with given HTTP status code
+func loginPage(c *gin.Context, code int) {
 	tmpl := server.MakeTmpl(StaticFs, "Login")
 	base := srvConfig.Config.Frontend.WebServer.Base
 	tmpl["Base"] = base
@@ -235,12 +279,27 @@ func LoginHandler(c *gin.Context) {
 			tmpl["FacebookLogin"] = fmt.Sprintf("%s/facebook/login", base)
 		}
 	}
+	var oidcLogins []map[string]string
+	for _, p := range _oidcProviders {
+		oidcLogins = append(oidcLogins, map[string]string{
+			"Name": p.Record.Name,
+			"URL":  fmt.Sprintf("%s/%s/login", base, p.Record.Provider),
+		})
+	}
+	tmpl["OIDCLogins"] = oidcLogins
+	tmpl["KerberosLogin"] = ""
+	if _keytab != nil {
+		tmpl["KerberosLogin"] = fmt.Sprintf("%s/kerberos/login", base)
+	}
 	content := server.TmplPage(StaticFs, "login.tmpl", tmpl)
-	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+	c.Data(code, "text/html; charset=utf-8", []byte(header()+content+footer()))
 }
 
 // LogoutHandler provides access to GET /logout endpoint
 func LogoutHandler(c *gin.Context) {
+	// revoke server side session and clear its cookie
+	endSession(c)
+	// clear legacy user cookie set by earlier versions of frontend
 	c.SetCookie("user", "", -1, "/", utils.Domain(), false, true)
 	cookie := &http.Cookie{
 		Name:     "user",
@@ -255,11 +314,7 @@ func LogoutHandler(c *gin.Context) {
 
 // ServicesHandler provides access to GET / end-point
 func ServicesHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	if Verbose > 0 {
 		log.Printf("user from c.Cookie: '%s'", user)
 	}
@@ -275,11 +330,7 @@ func ServicesHandler(c *gin.Context) {
 
 // SyncHandler provides access to GET /sync endpoint
 func SyncHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	tmpl := server.MakeTmpl(StaticFs, "Sync")
 	base := srvConfig.Config.Frontend.WebServer.Base
 	tmpl["Base"] = base
@@ -288,11 +339,12 @@ func SyncHandler(c *gin.Context) {
 	// request only user's specific data (check user attributes)
 	var btrs []string
 	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
-		fuser, err := _foxdenUser.Get(user)
+		fuser, err := getFoxdenUser(c, user)
 		btrs = fuser.Btrs
 		if err == nil {
-			// check user btrs and return error if user does not have any associations with Btrs
-			if len(btrs) == 0 {
+			// check user access attributes (BTRs or MagLab groups) and return
+			// error if user does not have any of them
+			if !hasAccess(fuser) {
 				msg := fmt.Sprintf("User %s does not associated with any BTRs, search access is deined", user)
 				handleError(c, http.StatusBadRequest, msg, err)
 				return
@@ -331,11 +383,6 @@ func SyncHandler(c *gin.Context) {
 
 // SyncStatusHandler provides access to GET /sync/status/:uuid endpoint
 func SyncStatusHandler(c *gin.Context) {
-	_, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
 	status := "unknown"
 	style := "success.tmpl"
 	suuid := c.Param("uuid")
@@ -391,13 +438,9 @@ func SyncStatusHandler(c *gin.Context) {
 
 // SyncDeleteHandler provides access to DELETE /sync/delete/:uuid endpoint
 func SyncDeleteHandler(c *gin.Context) {
-	_, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
 	suuid := c.Param("uuid")
-	err = deleteSyncRecord(suuid)
+	err := deleteSyncRecord(suuid)
+	audit(c, "sync_delete", "", map[string]any{"uuid": suuid}, nil, err)
 	if err != nil {
 		log.Println("ERROR: unable to delete sync record", suuid, err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -473,13 +516,9 @@ func DocsLocalHandler(c *gin.Context) {
 
 // PostProvenanceHandler provides access to GET /provenance endpoint
 func PostProvenanceHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("PostProvenanceHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("PostProvenanceHandler %s user=%s", c.Request.Method, user)
 	}
 	// read HTTP POST payload for this API
 	var record map[string]any
@@ -506,12 +545,14 @@ func PostProvenanceHandler(c *gin.Context) {
 	}
 	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
 	if err != nil {
+		audit(c, "provenance_insert", recValue(record, "did"), nil, data, err)
 		msg := "unable to submit record to FOXDEN metadata service"
 		log.Println("ERROR:", msg, err)
 		handleError(c, http.StatusBadRequest, msg, err)
 		return
 	}
 	if resp.StatusCode != 200 {
+		audit(c, "provenance_insert", recValue(record, "did"), nil, data, fmt.Errorf("response status %s", resp.Status))
 		defer resp.Body.Close()
 		if data, err = io.ReadAll(resp.Body); err == nil {
 			log.Printf("WARNING: unable to successfully submit provenance record, response=%+v, payload data=%v", resp, string(data))
@@ -521,6 +562,8 @@ func PostProvenanceHandler(c *gin.Context) {
 		c.JSON(resp.StatusCode, nil)
 		return
 	}
+	audit(c, "provenance_insert", recValue(record, "did"), nil, data, nil)
+	invalidateWriteCaches()
 	if Verbose > 0 {
 		log.Printf("INFO: response=%s", resp.Status)
 	}
@@ -529,13 +572,9 @@ func PostProvenanceHandler(c *gin.Context) {
 
 // ParentsHandler provides access to GET /parents endpoint
 func ParentsHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("ProvenanceHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("ProvenanceHandler %s user=%s", c.Request.Method, user)
 	}
 	r := c.Request
 	did := r.FormValue("did") // extract did from post form or from /provenance?did=did
@@ -555,13 +594,9 @@ func ParentsHandler(c *gin.Context) {
 
 // ProvenanceHandler provides access to GET /provenance endpoint
 func ProvenanceHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("ProvenanceHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("ProvenanceHandler %s user=%s", c.Request.Method, user)
 	}
 	r := c.Request
 	did := r.FormValue("did") // extract did from post form or from /provenance?did=did
@@ -697,11 +732,7 @@ func ProvenanceHandler(c *gin.Context) {
 
 // DMFiles provides access to GET /dm end-point
 func DMFilesHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	c.Set("user", user)
 	ext := c.Request.FormValue("ext")
 	did := c.Request.FormValue("did")
@@ -785,96 +816,81 @@ func DMFilesHandler(c *gin.Context) {
 
 // DataManagementHandler provides access to GET /dm end-point
 func DataManagementHandler(c *gin.Context) {
-	// check if user cookie is set, this is necessary as we do not
-	// use authorization handler for / end-point
-	user, err := getUser(c)
-	if err == nil {
-		c.Set("user", user)
+	user, _ := getUser(c)
 
-		path := c.Query("path")
-		fname := c.Query("file")
-		did := c.Query("did")
-		if did == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'did' parameter"})
-			return
-		}
-		did = url.QueryEscape(did)
-		attr := c.Query("attr")
+	path := c.Query("path")
+	fname := c.Query("file")
+	did := c.Query("did")
+	if did == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'did' parameter"})
+		return
+	}
+	did = url.QueryEscape(did)
+	attr := c.Query("attr")
 
-		// Prepare redirection URL
-		targetURL := fmt.Sprintf("%s/data?did=%s", srvConfig.Config.DataManagementURL, did)
-		if attr != "" {
-			targetURL = fmt.Sprintf("%s&attr=%s", targetURL, url.QueryEscape(attr))
-		}
-		if path != "" {
-			targetURL = fmt.Sprintf("%s&path=%s", targetURL, url.QueryEscape(path))
-		}
-		if fname != "" {
-			targetURL = fmt.Sprintf("%s&file=%s", targetURL, url.QueryEscape(fname))
-		}
+	// Prepare redirection URL
+	targetURL := fmt.Sprintf("%s/data?did=%s", srvConfig.Config.DataManagementURL, did)
+	if attr != "" {
+		targetURL = fmt.Sprintf("%s&attr=%s", targetURL, url.QueryEscape(attr))
+	}
+	if path != "" {
+		targetURL = fmt.Sprintf("%s&path=%s", targetURL, url.QueryEscape(path))
+	}
+	if fname != "" {
+		targetURL = fmt.Sprintf("%s&file=%s", targetURL, url.QueryEscape(fname))
+	}
 
-		// get new read token
-		token, err := newToken(user, "read")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
+	// get new read token
+	token, err := newToken(user, "read")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		// Create a new HTTP request to the target URL
-		req, err := http.NewRequest(http.MethodGet, targetURL, nil)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-			return
-		}
+	// Create a new HTTP request to the target URL
+	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		return
+	}
 
-		// Set custom headers
-		req.Header.Set("Authorization", "Bearer "+token)
-		req.Header.Set("X-Custom-Header", "DataManagementRequest")
+	// Set custom headers
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Custom-Header", "DataManagementRequest")
 
-		// Copy headers from the original request
-		for key, values := range c.Request.Header {
-			for _, value := range values {
-				req.Header.Add(key, value)
-			}
+	// Copy headers from the original request
+	for key, values := range c.Request.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
 		}
+	}
 
-		// Send the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forward request"})
-			return
-		}
-		defer resp.Body.Close()
+	// Send the request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forward request"})
+		return
+	}
+	defer resp.Body.Close()
 
-		// Copy response headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				c.Writer.Header().Add(key, value)
-			}
+	// Copy response headers
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
 		}
+	}
 
-		// Set response status code
-		c.Status(resp.StatusCode)
-
-		// Copy response body to Gin's response writer
-		io.Copy(c.Writer, resp.Body)
+	// Set response status code
+	c.Status(resp.StatusCode)
 
-	} else {
-		LoginHandler(c)
-	}
+	// Copy response body to Gin's response writer
+	io.Copy(c.Writer, resp.Body)
 }
 
 // DataHubHandler provides access to GET /datahub end-point
 func DataHubHandler(c *gin.Context) {
-	// check if user cookie is set, this is necessary as we do not
-	// use authorization handler for / end-point
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
-	c.Set("user", user)
+	user, _ := getUser(c)
 
 	did := c.Query("did")
 	if did == "" {
@@ -946,13 +962,9 @@ func ToolsHandler(c *gin.Context) {
 
 // UsersHandler provides access to GET /users endpoint
 func UsersHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("UsersHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("UsersHandler %s user=%s", c.Request.Method, user)
 	}
 	// get user info from ClasseInfoService
 	var users []ldap.UserInfo
@@ -983,13 +995,10 @@ func UsersHandler(c *gin.Context) {
 
 // TokenHandler provides access to GET /token endpoint
 func TokenHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
+	var err error
 	if Verbose > 1 {
-		log.Printf("TokenHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("TokenHandler %s user=%s", c.Request.Method, user)
 	}
 	var token string
 	scope := "read"
@@ -1008,10 +1017,13 @@ func TokenHandler(c *gin.Context) {
 		App:     "FOXDEN frontend",
 		Kind:    "client_credentials",
 	}
-	if fuser, err := _foxdenUser.Get(user); err == nil {
+	// attributes (BTRs or MagLab groups) which can be used in token restrictions
+	var allowed []string
+	if fuser, err := getFoxdenUser(c, user); err == nil {
 		rec.Btrs = fuser.Btrs
 		rec.Groups = fuser.Groups
 		rec.Scopes = fuser.Scopes
+		_, allowed = accessAttributes(fuser)
 		if c.Query("scope") == "write" {
 			if utils.InList(srvConfig.Config.AccessRules.WriteGroup, fuser.FoxdenGroups) {
 				rec.Scope = "write"
@@ -1023,16 +1035,32 @@ func TokenHandler(c *gin.Context) {
 				c.Data(http.StatusNotAcceptable,
 					"text/html; charset=utf-8",
 					[]byte(header()+content+footer()))
+				return
 			}
 		}
 	}
+	// fine-grained token restrictions, restricted token can't be used to obtain new tokens
+	if !requestRestrictions(c).Empty() {
+		err := errors.New("restricted token is not allowed to issue new tokens")
+		handleError(c, http.StatusForbidden, "token restrictions violation", err)
+		return
+	}
+	restrictions, err := parseRestrictions(c, allowed)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "invalid token restrictions", err)
+		return
+	}
 	var content string
 	tmpl := server.MakeTmpl(StaticFs, "Token")
 	base := srvConfig.Config.Frontend.WebServer.Base
 	tmpl["Base"] = base
 	var tmplName string
-	if tmap, err := rec.TokenMap(); err == nil {
+	if tmap, err := tokenMap(rec, restrictions); err == nil {
 		token = tmap.AccessToken
+		// keep record of issued token to allow its revocation
+		if _, err := _tokenRegistry.Register(token, c.Request.FormValue("name")); err != nil {
+			log.Println("ERROR: unable to register token", err)
+		}
 		tmpl["Token"] = token
 		tmpl["Expires"] = time.Now().Add(time.Duration(expires) * time.Second)
 		claims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
@@ -1044,6 +1072,12 @@ func TokenHandler(c *gin.Context) {
 				tmpl["TokenData"] = errorTmpl(c, "unable to process token claims, error", err)
 			}
 		}
+		tmpl["Restrictions"] = ""
+		if !restrictions.Empty() {
+			if data, err := json.MarshalIndent(restrictions, "", "   "); err == nil {
+				tmpl["Restrictions"] = string(data)
+			}
+		}
 		tmplName = "token.tmpl"
 	} else {
 		tmplName = "error.tmpl"
@@ -1058,13 +1092,9 @@ func TokenHandler(c *gin.Context) {
 
 // DidsHandler provides access to GET /dids endpoint
 func DidsHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("DidsHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("DidsHandler %s user=%s", c.Request.Method, user)
 	}
 	// get all dids from Metadata service
 	_httpReadRequest.GetToken()
@@ -1111,13 +1141,9 @@ func DidsHandler(c *gin.Context) {
 
 // PostRecordHandler provides access to POST /record endpoint
 func PostRecordHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("PostRecordHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("PostRecordHandler %s user=%s", c.Request.Method, user)
 	}
 	// read HTTP POST payload for this API
 	var record map[string]any
@@ -1144,12 +1170,14 @@ func PostRecordHandler(c *gin.Context) {
 	}
 	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
 	if err != nil {
+		audit(c, "metadata_insert", recValue(record, "did"), nil, data, err)
 		msg := "unable to submit record to FOXDEN metadata service"
 		log.Println("ERROR:", msg, err)
 		handleError(c, http.StatusBadRequest, msg, err)
 		return
 	}
 	if resp.StatusCode != 200 {
+		audit(c, "metadata_insert", recValue(record, "did"), nil, data, fmt.Errorf("response status %s", resp.Status))
 		defer resp.Body.Close()
 		if data, err = io.ReadAll(resp.Body); err == nil {
 			log.Printf("WARNING: unable to successfully submit metadata record, response=%+v, payload data=%v", resp, string(data))
@@ -1159,6 +1187,8 @@ func PostRecordHandler(c *gin.Context) {
 		c.JSON(resp.StatusCode, nil)
 		return
 	}
+	audit(c, "metadata_insert", recValue(record, "did"), nil, data, nil)
+	invalidateWriteCaches()
 	if Verbose > 0 {
 		log.Printf("INFO: response=%s", resp.Status)
 	}
@@ -1168,13 +1198,9 @@ func PostRecordHandler(c *gin.Context) {
 // RecordHandler provides access to GET /search endpoint
 func RecordHandler(c *gin.Context) {
 	r := c.Request
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("RecordHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("RecordHandler %s user=%s", c.Request.Method, user)
 	}
 	ajaxHtml := r.FormValue("ajaxHtml")
 	did := r.FormValue("did") // extract did from post form or from /provenance?did=did
@@ -1187,11 +1213,12 @@ func RecordHandler(c *gin.Context) {
 	// request only user's specific data (check user attributes)
 	var btrs []string
 	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
-		fuser, err := _foxdenUser.Get(user)
+		fuser, err := getFoxdenUser(c, user)
 		btrs = fuser.Btrs
 		if err == nil {
-			// check user btrs and return error if user does not have any associations with Btrs
-			if len(btrs) == 0 {
+			// check user access attributes (BTRs or MagLab groups) and return
+			// error if user does not have any of them
+			if !hasAccess(fuser) {
 				msg := fmt.Sprintf("User %s does not associated with any BTRs, search access is deined", user)
 				handleError(c, http.StatusBadRequest, msg, err)
 				return
@@ -1213,18 +1240,14 @@ func RecordHandler(c *gin.Context) {
 	// based on user query process request from all FOXDEN services
 	idx := 0
 	limit := 1
-	processResults(c, rec, user, idx, limit, btrs)
+	processResults(c, rec, user, idx, limit, btrs, nil)
 }
 
 // AdvancedSearchHandler provides access to GET /search endpoint
 func AdvancedSearchHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("AdvancedSearchHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("AdvancedSearchHandler %s user=%s", c.Request.Method, user)
 	}
 	// create map of schema names vs its keys
 	smap := make(map[string][]string)
@@ -1257,18 +1280,14 @@ func AdvancedSearchHandler(c *gin.Context) {
 // NotesHandler provides access to POST /notesform endpoint
 func NotesHandler(c *gin.Context) {
 	r := c.Request
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("SearchHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("SearchHandler %s user=%s", c.Request.Method, user)
 	}
 
 	tmpl := server.MakeTmpl(StaticFs, "Notes")
 	// parse multipart form
-	err = r.ParseMultipartForm(10 << 20) // 10 MB
+	err := r.ParseMultipartForm(10 << 20) // 10 MB
 	if err != nil {
 		content := errorTmpl(c, "unable to parse multipart form, error", err)
 		c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", []byte(header()+content+footer()))
@@ -1307,29 +1326,16 @@ func NotesHandler(c *gin.Context) {
 		imagePath = fmt.Sprintf("%s/datahub/%s/%s", srvConfig.Config.DataHubURL, didhash, filename)
 	}
 
-	rec := ELogEntry{User: user, Text: entry, Did: did, ImageURL: imagePath, DidHash: didhash}
-	data, err := json.Marshal(rec)
-	if err != nil {
-		content := errorTmpl(c, "unable to insert notes record, error", err)
-		c.Data(http.StatusBadRequest, "text/html; charset=utf-8", []byte(header()+content+footer()))
-	}
 	// send data to ELogService
-	_httpWriteRequest.GetToken()
-
-	// insert provenance record
-	rurl := fmt.Sprintf("%s/update", srvConfig.Config.Services.ELogServiceURL)
-	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
+	rec := ELogEntry{User: user, Text: entry, Did: did, ImageURL: imagePath, DidHash: didhash}
+	data, err := insertELogEntry(rec)
+	audit(c, "notes", did, nil, data, err)
 	if err != nil {
 		content := errorTmpl(c, "unable to insert notes entry, error", err)
 		c.Data(http.StatusBadRequest, "text/html; charset=utf-8", []byte(header()+content+footer()))
 		return
 	}
-	if resp.StatusCode != 200 {
-		err := errors.New(fmt.Sprintf("ELogService response %+v", resp))
-		content := errorTmpl(c, "unable to insert notes entry, error", err)
-		c.Data(http.StatusBadRequest, "text/html; charset=utf-8", []byte(header()+content+footer()))
-		return
-	}
+	invalidateWriteCaches()
 	tmpl["Title"] = "success"
 	tmpl["Content"] = "updated Elog entry, you'll be redirected to elog form shortly..."
 	base := srvConfig.Config.Frontend.WebServer.Base
@@ -1341,13 +1347,9 @@ func NotesHandler(c *gin.Context) {
 // NotesFormHandler provides access to POST /notesform endpoint
 func NotesFormHandler(c *gin.Context) {
 	r := c.Request
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("SearchHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("SearchHandler %s user=%s", c.Request.Method, user)
 	}
 	var did, description string
 	// if we get GET request we'll extract URL parameters
@@ -1379,13 +1381,9 @@ func NotesFormHandler(c *gin.Context) {
 
 // TmplRecordsFormHandler provides access to POST /notesform endpoint
 func TmplRecordsFormHandler(c *gin.Context) {
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("SearchHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("SearchHandler %s user=%s", c.Request.Method, user)
 	}
 	btr := c.Query("btr")
 	label := c.Query("label")
@@ -1457,13 +1455,9 @@ func RecordsGraphHandler(c *gin.Context) {
 // SearchHandler provides access to GET /search endpoint
 func SearchHandler(c *gin.Context) {
 	r := c.Request
-	user, err := getUser(c)
+	user, _ := getUser(c)
 	if Verbose > 1 {
-		log.Printf("SearchHandler %s user=%s error=%v", c.Request.Method, user, err)
-	}
-	if err != nil {
-		LoginHandler(c)
-		return
+		log.Printf("SearchHandler %s user=%s", c.Request.Method, user)
 	}
 
 	// create search template form
@@ -1525,6 +1519,33 @@ func SearchHandler(c *gin.Context) {
 			log.Printf("WARNING: user %s used empty query, substitue to {}\n", user)
 		}
 	}
+	// compile compact query, e.g. beamline:3a beam_energy>40, into JSON spec
+	if !jsonQuery(query) {
+		spec, err := querySpec(query)
+		if err != nil {
+			tmpl := server.MakeTmpl(StaticFs, "Data")
+			tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+			tmpl["Query"] = query
+			msg := err.Error()
+			var qerr *QueryError
+			if errors.As(err, &qerr) {
+				msg = qerr.Details()
+			}
+			tmpl["Content"] = template.HTML("<pre>" + template.HTMLEscapeString(msg) + "</pre>")
+			page := server.TmplPage(StaticFs, "query_error.tmpl", tmpl)
+			handleError(c, http.StatusBadRequest, page, err)
+			return
+		}
+		data, err := json.Marshal(spec)
+		if err != nil {
+			handleError(c, http.StatusBadRequest, "unable to marshal query spec", err)
+			return
+		}
+		if Verbose > 0 {
+			log.Printf("compiled query '%s' into %s", query, string(data))
+		}
+		query = string(data)
+	}
 	dataTypes := []string{"STRING", "INT", "INTEGER", "FLOAT", "LIST", "BOOL"}
 	for _, key := range dataTypes {
 		if strings.Contains(query, key) {
@@ -1538,6 +1559,13 @@ func SearchHandler(c *gin.Context) {
 			return
 		}
 	}
+	// validate query keys, value types and operators against FOXDEN schemas
+	if r.FormValue("validate") != "false" {
+		if problems := validateQuery(query); len(problems) > 0 {
+			queryProblemsError(c, query, problems)
+			return
+		}
+	}
 
 	// obtain valid token
 	_httpReadRequest.GetToken()
@@ -1554,9 +1582,12 @@ func SearchHandler(c *gin.Context) {
 		limit, err = strconv.Atoi(limitStr)
 		log.Println("limit", limit, err)
 	}
+	// default page size and sort options are taken from user preferences
+	prefs := userPreferences(user)
 	if limit == 0 {
-		limit = 10
+		limit = prefs.Limit()
 	}
+	defaultKey, defaultOrder := prefs.Sort()
 	// parse sort keys which are provided as comma separated list
 	sortKeys := r.FormValue("sort_keys")
 	var skeys []string
@@ -1570,12 +1601,12 @@ func SearchHandler(c *gin.Context) {
 			skeys = append(skeys, k)
 		}
 	}
-	// use date as default sort key
+	// use preferred sort key (date by default)
 	if len(skeys) == 0 {
-		skeys = append(skeys, "date")
+		skeys = append(skeys, defaultKey)
 	}
 	sortOrder := r.FormValue("sort_order")
-	order := -1 // descending order for MongoDB (default value)
+	order := defaultOrder // descending order for MongoDB unless user prefers otherwise
 	if sortOrder != "" {
 		// in pagination.tmpl we use ascending/descending which we translates to 1/-1 for MongoDB
 		if sortOrder == "ascending" || sortOrder == "asc" {
@@ -1594,10 +1625,16 @@ func SearchHandler(c *gin.Context) {
 		Client:       "frontend",
 		ServiceQuery: services.ServiceQuery{Query: query, Idx: idx, Limit: limit, SortKeys: skeys, SortOrder: order},
 	}
+	// keep user spec intact to explain which BTR constraints were applied
+	explain := r.FormValue("explain") == "true"
+	var userSpec map[string]any
+	if explain {
+		json.Unmarshal([]byte(query), &userSpec)
+	}
 	// request only user's specific data (check user attributes)
 	var btrs []string
 	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
-		fuser, err := _foxdenUser.Get(user)
+		fuser, err := getFoxdenUser(c, user)
 		btrs = fuser.Btrs
 		if err == nil {
 			var spec map[string]any
@@ -1607,8 +1644,9 @@ func SearchHandler(c *gin.Context) {
 				handleError(c, http.StatusBadRequest, msg, err)
 				return
 			}
-			// check user btrs and return error if user does not have any associations with Btrs
-			if len(btrs) == 0 {
+			// check user access attributes (BTRs or MagLab groups) and return
+			// error if user does not have any of them
+			if !hasAccess(fuser) {
 				msg := fmt.Sprintf("User %s does not associated with any BTRs, search access is deined", user)
 				handleError(c, http.StatusBadRequest, msg, err)
 				return
@@ -1631,30 +1669,42 @@ func SearchHandler(c *gin.Context) {
 			}
 		}
 	}
+	// in explain mode we show final spec and number of records without fetching them
+	if explain {
+		explainSearch(c, user, r.FormValue("query"), userSpec, rec)
+		return
+	}
+	// cursor, if provided, takes precedence over idx offset
+	cur, err := requestCursor(c)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "unable to decode cursor", err)
+		return
+	}
 	// based on user query process request from all FOXDEN services
-	processResults(c, rec, user, idx, limit, btrs)
+	processResults(c, rec, user, idx, limit, btrs, cur)
 }
 
 // SpecScansHandler provides access to GET /specscans endpoint.
 // With ?did=xxx: shows a DataTable of SpecScan records for that DID.
 // Without ?did: shows a DataTable of all SpecScan records matching the user's BTR groups.
 func SpecScansHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	_specScanAttrs := specScanAttrs()
 	tmpl := server.MakeTmpl(StaticFs, "CHESS spec scans")
 	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
 	tmpl["Columns"] = _specScanAttrs
+	tmpl["ColumnTypes"] = schemaColumns(_spec_schema)
 	tmpl["DataAttributes"] = strings.Join(_specScanAttrs, ",")
 	tmpl["User"] = user
-	tmpl["CookieName"] = "scanAttrs"
+	prefs := userPreferences(user)
+	tmpl["PrefsKey"] = "specscans_columns"
+	tmpl["UserAttrs"] = strings.Join(prefs.SpecScansColumns, ",")
 	tmpl["DefaultAttrs"] = "start_time,spec_file,scan_number,command"
+	tmpl["PageSize"] = prefs.Limit()
+	tmpl["SortKey"], tmpl["SortOrder"] = prefs.SortKey, prefs.SortOrder
 	if user != "test" {
-		if fuser, ferr := _foxdenUser.Get(user); ferr == nil {
+		if fuser, ferr := getFoxdenUser(c, user); ferr == nil {
 			tmpl["Btrs"] = fuser.Btrs
 		}
 	}
@@ -1716,11 +1766,7 @@ func fetchSpecScans(req services.ServiceRequest) ([]map[string]any, error) {
 // With ?did=xxx: returns records for that DID.
 // Without ?did: returns records filtered by the user's BTR groups.
 func SpecScansDataHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{})
-		return
-	}
+	user, _ := getUser(c)
 
 	idx, _ := strconv.Atoi(c.DefaultQuery("idx", "0"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -1751,6 +1797,12 @@ func SpecScansDataHandler(c *gin.Context) {
 	}
 
 	spec := makeSpec(searchFilter, attrs, caseInsensitive)
+	// narrow spec with typed column filters
+	spec, err := applyColumnFilters(spec, columnFilters(c), schemaColumns(_spec_schema), caseInsensitive)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if did := c.Query("did"); did != "" {
 		// DID-scoped: add DID constraint to spec
@@ -1758,8 +1810,8 @@ func SpecScansDataHandler(c *gin.Context) {
 	} else {
 		// BTR-filtered: use updateSpec to add btr:{$in:[...]} constraint for all BTRs at once
 		if user != "test" {
-			fuser, ferr := _foxdenUser.Get(user)
-			if ferr != nil || len(fuser.Btrs) == 0 {
+			fuser, ferr := getFoxdenUser(c, user)
+			if ferr != nil || !hasAccess(fuser) {
 				c.JSON(http.StatusOK, gin.H{
 					"total":    0,
 					"records":  []map[string]any{},
@@ -1771,6 +1823,8 @@ func SpecScansDataHandler(c *gin.Context) {
 			spec = updateSpec(spec, fuser, "filter")
 		}
 	}
+	// narrow spec with fine-grained token restrictions
+	spec = restrictSpec(c, spec)
 
 	query, merr := json.Marshal(spec)
 	if merr != nil {
@@ -1786,7 +1840,7 @@ func SpecScansDataHandler(c *gin.Context) {
 		return
 	}
 
-	allRecords, err := fetchSpecScans(services.ServiceRequest{
+	rec := services.ServiceRequest{
 		Client: "frontend",
 		ServiceQuery: services.ServiceQuery{
 			Query:     string(query),
@@ -1796,12 +1850,26 @@ func SpecScansDataHandler(c *gin.Context) {
 			SortKeys:  sortKeys,
 			SortOrder: sortOrder,
 		},
-	})
+	}
+	// scans are identified by did, spec file and scan number, which are used
+	// as cursor tie-breakers; cursor, if provided, takes precedence over idx
+	cur, ckeys, corder, err := cursorRequest(c, &rec, "start_time",
+		[]string{"did", "spec_file", "scan_number"})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if cur != nil {
+		idx = cur.Idx
+	}
+	allRecords, err := fetchSpecScans(rec)
 	if err != nil {
 		log.Printf("ERROR: SpecScansDataHandler: fetchSpecScans: %v", err)
 		c.JSON(http.StatusBadGateway, gin.H{})
 		return
 	}
+	allRecords = cursorRecords(cur, allRecords)
+	cursors := pageCursors(ckeys, corder, allRecords, idx, limit, total)
 
 	// Project requested attributes
 	var records []map[string]any
@@ -1825,16 +1893,13 @@ func SpecScansDataHandler(c *gin.Context) {
 		"records":  records,
 		"columns":  attrs,
 		"pageSize": limit,
+		"cursors":  cursors,
 	})
 }
 
 // MetaDataHandler provides access to GET /meta endpoint
 func MetaDataHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	tmpl := server.MakeTmpl(StaticFs, "Data")
 	tmpl["Base"] = srvConfig.Config.CHESSMetaData.WebServer.Base
@@ -2122,11 +2187,7 @@ func MetaFormUploadHandler(c *gin.Context) {
 
 // MetaTmplSubmitHandler provides access to POST /meta/tmpl/submit endpoint
 func MetaTmplSubmitHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	// construct record
 	rec, err := parseTmplUploadForm(c)
 	if err != nil {
@@ -2150,6 +2211,8 @@ func MetaTmplSubmitHandler(c *gin.Context) {
 		return
 	}
 
+	invalidateWriteCaches()
+
 	// redirect HTTP to /tmpl/records end-point
 	msg := "record submitted to FOXDEN"
 	c.SetCookie("redirect_reason", msg, 3, "/", "", false, true)
@@ -2245,11 +2308,7 @@ func MetaFileUploadHandler(c *gin.Context) {
 // UserUploadHandler manages upload of user record to Metadata service
 func UserUploadHandler(c *gin.Context, mrec services.MetaRecord, updateMetadata bool) {
 	class := "alert alert-success"
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	tmpl := server.MakeTmpl(StaticFs, "Upload")
 	mrec.Record["user"] = user
 	if Verbose > 0 {
@@ -2352,6 +2411,8 @@ func UserUploadHandler(c *gin.Context, mrec services.MetaRecord, updateMetadata
 		class = "alert alert-error"
 		msg = fmt.Sprintf("<pre class=\"no-horizontal-scroll\">%s</pre>", sresp.HtmlString())
 	}
+	audit(c, metadataAction(updateMetadata), did, nil, mrec.Record, serviceError(sresp))
+	invalidateWriteCaches()
 
 	// we should use metadata json record instead of services.MetaRecord for web form
 	if data, err := json.MarshalIndent(mrec.Record, "", "  "); err == nil {
@@ -2375,11 +2436,7 @@ func UserUploadHandler(c *gin.Context, mrec services.MetaRecord, updateMetadata
 
 // MetaUploadHandler manages upload of record to MetaData service
 func MetaUploadHandler(c *gin.Context, mrec services.MetaRecord, updateMetadata bool) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	tmpl := server.MakeTmpl(StaticFs, "Upload")
 
 	// prepare http writer
@@ -2421,6 +2478,8 @@ func MetaUploadHandler(c *gin.Context, mrec services.MetaRecord, updateMetadata
 	if sresp.SrvCode != 0 || sresp.HttpCode != http.StatusOK {
 		msg = fmt.Sprintf("<pre class=\"no-horizontal-scroll\">%s</pre>", sresp.HtmlString())
 	}
+	audit(c, metadataAction(updateMetadata), recValue(mrec.Record, "did"), nil, mrec.Record, serviceError(sresp))
+	invalidateWriteCaches()
 
 	// we should use metadata json record instead of services.MetaRecord for web form
 	if data, err := json.MarshalIndent(mrec.Record, "", "  "); err == nil {
@@ -2474,9 +2533,13 @@ func NotebookHandler(c *gin.Context) {
 	chapbookUrl := fmt.Sprintf("%s/notebook", srvConfig.Config.Services.CHAPBookURL)
 	if chapbookUrl != "" {
 		if c.Request.Header.Get("Authorization") == "" {
-			user, _ := c.Cookie("user")
+			user, _ := getUser(c)
 			token, err := newToken(user, "read")
-			if err == nil {
+			if err == nil && user != "" {
+				// the token leaves frontend, therefore we register it
+				if _, err := _tokenRegistry.Register(token, "notebook"); err != nil {
+					log.Println("ERROR: unable to register token", err)
+				}
 				// pass token as paramter to CHAPBook /notebook end-point
 				// since HTTP standard does not pass through HTTP headers on redirect
 				// see discussion: https://stackoverflow.com/questions/36345696/http-redirect-with-headers
@@ -2534,11 +2597,7 @@ func DataHandler(c *gin.Context) {
 
 // DatasetsHandler provides access to GET /datasets endpoint
 func DatasetsHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	// Parse query parameters
 	idx, _ := strconv.Atoi(c.DefaultQuery("idx", "0"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -2587,6 +2646,16 @@ func DatasetsHandler(c *gin.Context) {
 	if btr != "" {
 		spec["btr"] = btr
 	}
+	// narrow spec with facets selected by the user
+	spec = applyFacets(spec, facetFilters(c))
+	// narrow spec with typed column filters
+	spec, err := applyColumnFilters(spec, columnFilters(c), schemaColumns(_smgr), caseInsensitive)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// narrow spec with fine-grained token restrictions
+	spec = restrictSpec(c, spec)
 	if data, e := json.Marshal(spec); e == nil {
 		query = string(data)
 	}
@@ -2621,9 +2690,15 @@ func DatasetsHandler(c *gin.Context) {
 			SortKeys:  sortKeys,
 			SortOrder: sortOrder},
 	}
+	// keep user spec intact to explain which BTR constraints were applied
+	explain := c.Query("explain") == "true"
+	var userSpec map[string]any
+	if explain {
+		userSpec = copySpec(spec)
+	}
 	// request only user's specific data (check user attributes)
 	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
-		if fuser, ferr := _foxdenUser.Get(user); ferr == nil {
+		if fuser, ferr := getFoxdenUser(c, user); ferr == nil {
 			// reduce BTRs based on searchFilter
 			//updateBTRs(&fuser, searchFilter)
 			// in filters use-case we update spec with filters
@@ -2646,6 +2721,20 @@ func DatasetsHandler(c *gin.Context) {
 			}
 		}
 	}
+	// cursor, if provided, takes precedence over idx offset
+	cur, ckeys, corder, err := cursorRequest(c, &rec, "date", []string{"did"})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if cur != nil {
+		idx = cur.Idx
+	}
+	// in explain mode we return final spec and number of records without fetching them
+	if explain {
+		c.JSON(http.StatusOK, explainRequest(c, user, "", userSpec, rec, "filter"))
+		return
+	}
 	resp, err := chunkOfRecords(rec)
 	if resp.HttpCode != http.StatusOK {
 		log.Printf("ERROR: failed request to discovery service, query %+v, response %+v", rec, resp)
@@ -2655,10 +2744,12 @@ func DatasetsHandler(c *gin.Context) {
 		log.Printf("ERROR: failed to get chunk of data, query %+v, error %v", rec, err)
 		c.JSON(http.StatusBadRequest, gin.H{})
 	}
+	rawRecords := cursorRecords(cur, resp.Results.Records)
+	cursors := pageCursors(ckeys, corder, rawRecords, idx, limit, total)
 
 	// filter outgoing records based on our attributes
 	var records []map[string]any
-	for _, rec := range resp.Results.Records {
+	for _, rec := range rawRecords {
 		frec := make(map[string]any)
 		for _, attr := range attrs {
 			frec[attr] = rec[attr]
@@ -2677,7 +2768,7 @@ func DatasetsHandler(c *gin.Context) {
 			dids = append(dids, did)
 		}
 	}
-	notes := getNotes(dids)
+	notes := getNotes(dids, userPreferences(user).Location())
 
 	// Send JSON response
 	c.JSON(http.StatusOK, gin.H{
@@ -2686,6 +2777,7 @@ func DatasetsHandler(c *gin.Context) {
 		"columns":  columns,
 		"pageSize": limit,
 		"notes":    notes,
+		"cursors":  cursors,
 	})
 
 }
@@ -2721,23 +2813,29 @@ func specScanAttrs() []string {
 
 // DatasetsTableHandler provides access to GET /dstable endpoint
 func DatasetsTableHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	tmpl := server.MakeTmpl(StaticFs, "CHESS datasets")
 	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
 	tmpl["PageTitle"] = "FOXDEN: datasets"
 	tmpl["Columns"] = _foxdenAttrs
+	tmpl["ColumnTypes"] = schemaColumns(_smgr)
 	tmpl["DataAttributes"] = strings.Join(_foxdenAttrs, ",")
 	tmpl["User"] = user
 	tmpl["DataURL"] = "/datasets"
-	tmpl["CookieName"] = "userAttrs"
+	tmpl["ExportURL"] = "/export"
+	tmpl["ExplainURL"] = "/datasets"
+	tmpl["BulkURL"] = "/bulk"
+	tmpl["CompareURL"] = "/compare"
+	prefs := userPreferences(user)
+	tmpl["PrefsKey"] = "dstable_columns"
+	tmpl["UserAttrs"] = strings.Join(prefs.DatasetColumns, ",")
 	tmpl["DefaultAttrs"] = "date,beamline,btr,cycle,sample_name"
+	tmpl["PageSize"] = prefs.Limit()
+	tmpl["SortKey"], tmpl["SortOrder"] = prefs.SortKey, prefs.SortOrder
 	tmpl["UserBtr"] = c.Query("btr")
+	tmpl["Facets"] = c.QueryArray("facet")
 	if user != "test" {
-		if fuser, err := _foxdenUser.Get(user); err == nil {
+		if fuser, err := getFoxdenUser(c, user); err == nil {
 			tmpl["Btrs"] = fuser.Btrs
 		}
 	}
@@ -2751,11 +2849,7 @@ func DatasetsTableHandler(c *gin.Context) {
 // POST handlers
 // PublishHandler handles publish request for did
 func PublishHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	// defaults
 	r := c.Request
@@ -2803,6 +2897,7 @@ func PublishHandler(c *gin.Context) {
 		log.Printf("### publish did=%s doiprovider=%s doi=%s doiLink=%s error=%v", did, doiprovider, doi, doiLink, err)
 	}
 	content := fmt.Sprintf("SUCCESS:<br/><b>did=%s</b><br/>is published with<br/><b>DOI=%s</b><br/><b>URL=<a href=\"%s\">%s</a></b><br/>Please note: it will take some time for DOI record to appear", did, doi, doiLink, doiLink)
+	auditErr := err
 	if err != nil {
 		templateName = "error.tmpl"
 		httpCode = http.StatusBadRequest
@@ -2811,6 +2906,7 @@ func PublishHandler(c *gin.Context) {
 		templateName = "error.tmpl"
 		httpCode = http.StatusBadRequest
 		content = fmt.Sprintf("ERROR:<br/>unable to get DOI info for <br/>did=%s<br/> from %s DOI provider", did, doiprovider)
+		auditErr = fmt.Errorf("unable to get DOI info from %s DOI provider", doiprovider)
 	} else {
 		// update metadata with DOI information
 		err = updateMetaDataDOI(user, did, schema, license, doiprovider, doi, doiLink, doiPublic, publishmetadata, parents)
@@ -2818,8 +2914,14 @@ func PublishHandler(c *gin.Context) {
 			templateName = "error.tmpl"
 			httpCode = http.StatusBadRequest
 			content = fmt.Sprintf("ERROR:<br/>fail to update MetaData DOI for<br/>did=%s<br/>error=%v", did, err)
+			auditErr = err
 		}
 	}
+	doiRecord := map[string]any{"doi": doi, "doi_link": doiLink, "doi_provider": doiprovider, "public": doiPublic}
+	audit(c, "publish", did, nil, doiRecord, auditErr)
+	if auditErr == nil {
+		invalidateWriteCaches()
+	}
 	rec := services.Response("FrontendService", httpCode, srvCode, err)
 	if r.Header.Get("Accept") == "application/json" {
 		if err != nil {
@@ -2837,11 +2939,7 @@ func PublishHandler(c *gin.Context) {
 
 // PublishFormHandler handles publish request for did
 func PublishFormHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	r := c.Request
 	w := c.Writer
@@ -2886,11 +2984,7 @@ func PublishFormHandler(c *gin.Context) {
 
 // DoiPublicHandler handles publishing given DOI as public record
 func DoiPublicHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	r := c.Request
 	w := c.Writer
 	doi := r.FormValue("doi")
@@ -2904,20 +2998,28 @@ func DoiPublicHandler(c *gin.Context) {
 	content := fmt.Sprintf("SUCCESS:<br/><b>DOI=%s</b><br/>is published with %s as public DOI<br/><b>URL=<a href=\"%s\">%s</a></b><br/>Please note: it will take some time for public DOI record to appear", doi, doiprovider, doiLink, doiLink)
 
 	// update dataset info in DOI provider
+	var auditErr error
 	if err := makePublic(doi, doiprovider); err == nil {
 		// update DOI info in MetaData service to make it public
 		doiPublic := true
 		doiParents := []string{}
 		if err := updateMetaDataDOI(user, did, schema, license, doiprovider, doi, doiLink, doiPublic, "preserve", doiParents); err != nil {
+			auditErr = err
 			templateName = "error.tmpl"
 			content = fmt.Sprintf("ERROR:<br/>fail to update Metadata DOI information<br/>DOI=%s<br/>error=%v", doi, err)
 			w.WriteHeader(http.StatusNotFound)
 		}
 	} else {
+		auditErr = err
 		templateName = "error.tmpl"
 		content = fmt.Sprintf("ERROR:<br/>fail to create public DOI record<br/>DOI=%s<br/>error=%v", doi, err)
 		w.WriteHeader(http.StatusBadRequest)
 	}
+	doiRecord := map[string]any{"doi": doi, "doi_link": doiLink, "doi_provider": doiprovider, "public": true}
+	audit(c, "doi_public", did, nil, doiRecord, auditErr)
+	if auditErr == nil {
+		invalidateWriteCaches()
+	}
 	tmpl["Content"] = template.HTML(content)
 	page := server.TmplPage(StaticFs, templateName, tmpl)
 	w.Write([]byte(header() + page + footer()))
@@ -2926,11 +3028,7 @@ func DoiPublicHandler(c *gin.Context) {
 // UploadJSONHandler handles upload of JSON record
 func UploadJSONHandler(c *gin.Context) {
 
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	r := c.Request
 	w := c.Writer
@@ -3036,13 +3134,8 @@ func TmplRecordUpdateHandler(c *gin.Context) {
 
 // TmplRecordHandler handles updates of tmp records
 func TmplRecordHandler(c *gin.Context, action string) {
-	_, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
 	// Parse form data
-	err = c.Request.ParseForm()
+	err := c.Request.ParseForm()
 	if err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
@@ -3106,9 +3199,15 @@ func TmplRecordHandler(c *gin.Context, action string) {
 				msg = fmt.Sprintf("unable to update template record, status %s, %v", resp.Status, string(data))
 			}
 		}
+		if action != "validate" {
+			audit(c, "tmpl_"+action, recValue(record, "did"), nil, record, errors.New(msg))
+		}
 		handleError(c, http.StatusBadRequest, msg, err)
 		return
 	}
+	if action != "validate" {
+		audit(c, "tmpl_"+action, recValue(record, "did"), nil, record, nil)
+	}
 
 	// redirect HTTP to /tmpl/records end-point
 	msg := fmt.Sprintf("BTR=%s sample=%s record action %s", btr, sample, action)
@@ -3119,11 +3218,7 @@ func TmplRecordHandler(c *gin.Context, action string) {
 
 // TmplRecordDeleteHandler handles updates of tmpl record
 func TmplRecordDeleteHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	_httpDeleteRequest.GetToken()
 	did := c.PostForm("did")
 	btr := c.PostForm("btr")
@@ -3140,7 +3235,7 @@ func TmplRecordDeleteHandler(c *gin.Context) {
 	}
 	// check user's btr and decide if (s)he can delete the template record
 	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
-		fuser, err := _foxdenUser.Get(user)
+		fuser, err := getFoxdenUser(c, user)
 		if err != nil {
 			msg := fmt.Sprintf("unable to find foxden user %s", user)
 			handleError(c, http.StatusBadRequest, msg, err)
@@ -3157,14 +3252,18 @@ func TmplRecordDeleteHandler(c *gin.Context) {
 		url.QueryEscape(did), url.QueryEscape(btr), url.QueryEscape(label))
 	data := []byte{}
 	resp, err := _httpDeleteRequest.Delete(rurl, "application/json", bytes.NewBuffer(data))
+	tmplRecord := map[string]any{"did": did, "btr": btr, "label": label}
 	if err != nil || resp.StatusCode != 200 {
 		msg := fmt.Sprintf("unable to delete template record, status %s", resp.Status)
 		if err != nil {
 			msg += fmt.Sprintf(", error=%v", err)
 		}
+		audit(c, "tmpl_delete", did, tmplRecord, nil, errors.New(msg))
 		handleError(c, http.StatusBadRequest, msg, err)
 		return
 	}
+	audit(c, "tmpl_delete", did, tmplRecord, nil, nil)
+	invalidateWriteCaches()
 	msg := fmt.Sprintf("did=%s record deletion is scheduled", did)
 	c.SetCookie("redirect_reason", msg, 3, "/", "", false, true)
 	c.Redirect(http.StatusFound, "/tmpl/records")
@@ -3172,11 +3271,7 @@ func TmplRecordDeleteHandler(c *gin.Context) {
 
 // AIChatHandler handles requests from AI assitance chat
 func AIChatHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 
 	var req ChatRequest
 
@@ -3199,11 +3294,6 @@ func AIChatHandler(c *gin.Context) {
 
 // AmendFormHandler provides access to GET /amend endpoint
 func AmendFormHandler(c *gin.Context) {
-	_, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
 	w := c.Writer
 	tmpl := server.MakeTmpl(StaticFs, "Amend")
 	base := srvConfig.Config.Frontend.WebServer.Base
@@ -3233,11 +3323,7 @@ func AmendFormHandler(c *gin.Context) {
 
 // AddAuxDataHandler provides access to POST /addauxdata endpoint
 func AddAuxDataHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	tmpl := server.MakeTmpl(StaticFs, "AmendForm")
 	r := c.Request
 	did := r.FormValue("did")
@@ -3310,6 +3396,7 @@ func AddAuxDataHandler(c *gin.Context) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		audit(c, "aux_data", did, nil, body, err)
 		msg := "failed to upload data to DataHub"
 		handleError(c, http.StatusBadRequest, msg, err)
 		return
@@ -3319,6 +3406,11 @@ func AddAuxDataHandler(c *gin.Context) {
 	if resp.StatusCode != 200 {
 		content = fmt.Sprintf("record with did=%s failed to upload aux data", did)
 		template = "error.tmpl"
+		err = fmt.Errorf("DataHub response status %s", resp.Status)
+	}
+	audit(c, "aux_data", did, nil, body, err)
+	if err == nil {
+		invalidateWriteCaches()
 	}
 	tmpl["Content"] = content
 	page := server.TmplPage(StaticFs, template, tmpl)
@@ -3327,11 +3419,8 @@ func AddAuxDataHandler(c *gin.Context) {
 
 // AmendRecordHandler provides access to POST /amend endpoint
 func AmendRecordHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
+	var err error
 	tmpl := server.MakeTmpl(StaticFs, "AmendForm")
 	r := c.Request
 	w := c.Writer
@@ -3348,14 +3437,23 @@ func AmendRecordHandler(c *gin.Context) {
 		if _, ok := rec["user"]; !ok {
 			rec["user"] = user
 		}
+		// keep original record to audit the change
+		var orig any
+		if val, err := findMetadataRecord(did); err == nil {
+			orig = val
+		}
 		// update meta-data record
 		err := updateMetadataRecord(did, rec)
+		audit(c, "amend", did, orig, rec, err)
 		if err != nil {
 			content = fmt.Sprintf("Record %s update fails with error=%v", did, err)
 			template = "error.tmpl"
 			status = http.StatusBadRequest
+		} else {
+			invalidateWriteCaches()
 		}
 	} else {
+		audit(c, "amend", did, nil, recStr, err)
 		content = fmt.Sprintf("Record %s update fails with error=%v", did, err)
 		template = "error.tmpl"
 		status = http.StatusBadRequest
@@ -3381,11 +3479,7 @@ func AmendRecordHandler(c *gin.Context) {
 
 // SyncFormHandler provides access to POST /sync endpoint
 func SyncFormHandler(c *gin.Context) {
-	user, err := getUser(c)
-	if err != nil {
-		LoginHandler(c)
-		return
-	}
+	user, _ := getUser(c)
 	// prepare our data
 	tmpl := server.MakeTmpl(StaticFs, "Sync")
 	base := srvConfig.Config.Frontend.WebServer.Base
@@ -3406,19 +3500,11 @@ func SyncFormHandler(c *gin.Context) {
 		rec["btrs"] = c.Request.Form["btrs"]
 	}
 
-	// serialize sync record
-	data, err := json.Marshal(rec)
-	if err != nil {
-		handleError(c, http.StatusBadRequest, "unable to process sync request form", err)
-		return
-	}
 	// insert sync record
-	_httpWriteRequest.GetToken()
-	rurl := fmt.Sprintf("%s/record", srvConfig.Config.Services.SyncServiceURL)
-	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
-	if err != nil || resp.StatusCode != 200 {
-		msg := fmt.Sprintf("unable to process sync request, status %s", resp.Status)
-		handleError(c, http.StatusBadRequest, msg, err)
+	data, err := createSyncRecord(rec)
+	audit(c, "sync_create", recValue(rec, "did"), nil, data, err)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "unable to process sync request", err)
 		return
 	}
 	if c.Request.Header.Get("Accept") == "application/json" {
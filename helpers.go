@@ -77,41 +77,52 @@ func recValue(rec map[string]any, attr string) string {
 	return "Not available"
 }
 
-// helper function to get dids from DataHub service
+// helper function to get dids from DataHub service, results are cached
 func datahubDidHashes() []string {
 	var didHashes []string
 	if srvConfig.Config.DataHubURL == "" {
 		return didHashes
 	}
+	val, err := getCache(datahubCache).GetOrLoad("dids", func() (any, error) {
+		return fetchDatahubDidHashes()
+	})
+	if err != nil {
+		log.Println("WARNING: unable to get datahub didHashes, error:", err)
+		return didHashes
+	}
+	return val.([]string)
+}
+
+// helper function to fetch dids from DataHub service
+func fetchDatahubDidHashes() ([]string, error) {
+	var didHashes []string
 	_httpReadRequest.GetToken()
 	rurl := fmt.Sprintf("%s/datahub", srvConfig.Config.DataHubURL)
 	resp, err := _httpReadRequest.Get(rurl)
 	if err != nil {
-		log.Println("WARNING: unable to get datahub didHashes, error:", err)
-		return didHashes
+		return didHashes, err
 	}
 	defer resp.Body.Close()
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("WARNING: unable to get datahub didHashes, error:", err)
-		return didHashes
+		return didHashes, err
 	}
 	var arr []string
 	err = json.Unmarshal(data, &arr)
 	if err != nil {
-		log.Println("WARNING: unable to get datahub didHashes, error:", err)
-		return didHashes
+		return didHashes, err
 	}
 	for _, entry := range arr {
 		if did, err := url.QueryUnescape(entry); err == nil {
 			didHashes = append(didHashes, did)
 		}
 	}
-	return didHashes
+	return didHashes, nil
 }
 
-// helper function to prepare HTML page for given services records
-func records2html(user string, records []map[string]any, attrs2show []string) string {
+// helper function to prepare HTML page for given services records, timestamps
+// are shown in given time zone (UTC if it is not set)
+func records2html(user string, records []map[string]any, attrs2show []string, loc *time.Location) string {
 	var out []string
 	didhashes := datahubDidHashes()
 	for _, rec := range records {
@@ -148,7 +159,7 @@ func records2html(user string, records []map[string]any, attrs2show []string) st
 		tmpl["RecordDescription"] = reprRecord(rec, "description")
 		tmpl["RecordJSON"] = reprRecord(rec, "json")
 		tmpl["Description"] = recValue(rec, "description")
-		if val, err := lastModified(rec); err == nil {
+		if val, err := lastModified(rec, loc); err == nil {
 			tmpl["TimeStamp"] = val
 		} else {
 			tmpl["TimeStamp"] = "Not Available"
@@ -301,17 +312,24 @@ func reprRecord(rec map[string]any, format string) string {
 	return out
 }
 
-// helper function to make pagination
-func pagination(c *gin.Context, query string, nres, startIdx, limit int, sortKey, sortOrder string, btrs []string) string {
+// helper function to make pagination, if cursors are provided page links
+// use them instead of idx offsets
+func pagination(c *gin.Context, query string, nres, startIdx, limit int, sortKey, sortOrder string, btrs []string, cursors *PageCursors) string {
 	tmpl := server.MakeTmpl(StaticFs, "Search")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
 	if user, err := getUser(c); err == nil {
 		tmpl["User"] = user
 		tmpl["DataAttributes"] = strings.Join(_foxdenAttrs, ",")
+		tmpl["UserAttrs"] = strings.Join(userPreferences(user).DatasetColumns, ",")
 	}
 	urlValues := url.Values{}
 	urlValues.Set("query", query)
 	urlValues.Set("sort_keys", sortKey)
 	urlValues.Set("sort_order", sortOrder)
+	// keep query validation disabled if user explicitly asked for it
+	if c.Request.FormValue("validate") == "false" {
+		urlValues.Set("validate", "false")
+	}
 
 	//  eQuery := url.QueryEscape(query)
 	//	href := "/search?" + urlValues.Encode()
@@ -347,6 +365,28 @@ func pagination(c *gin.Context, query string, nres, startIdx, limit int, sortKey
 	urlValues.Set("limit", strconv.Itoa(fLimit))
 	tmpl["LastUrl"] = "/search?" + urlValues.Encode()
 
+	if cursors != nil {
+		urlValues.Del("idx")
+		cursorUrl := func(cursor, fallback string) string {
+			if cursor == "" {
+				cursor = fallback
+			}
+			urlValues.Set("cursor", cursor)
+			return "/search?" + urlValues.Encode()
+		}
+		tmpl["FirstUrl"] = cursorUrl(cursors.First, "")
+		tmpl["PrevUrl"] = cursorUrl(cursors.Prev, cursors.First)
+		tmpl["NextUrl"] = cursorUrl(cursors.Next, cursors.Last)
+		tmpl["LastUrl"] = cursorUrl(cursors.Last, "")
+		urlValues.Del("cursor")
+	}
+
+	urlValues.Del("idx")
+	urlValues.Del("limit")
+	tmpl["ExportUrl"] = "/export?" + urlValues.Encode()
+	urlValues.Set("explain", "true")
+	tmpl["ExplainUrl"] = "/search?" + urlValues.Encode()
+
 	tmpl["Query"] = template.HTML(query)
 	tmpl["SortKey"] = sortKey
 	tmpl["SortOrder"] = sortOrder
@@ -1137,6 +1177,26 @@ func fetchELogEntries(did, user string) []ELogEntry {
 	return entries
 }
 
+// helper function to insert elog entry into ELog service, it returns
+// serialized entry which is used to audit the change
+func insertELogEntry(rec ELogEntry) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return data, fmt.Errorf("[Frontend.main.insertELogEntry] json.Marshal error: %w", err)
+	}
+	_httpWriteRequest.GetToken()
+	rurl := fmt.Sprintf("%s/update", srvConfig.Config.Services.ELogServiceURL)
+	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return data, fmt.Errorf("[Frontend.main.insertELogEntry] _httpWriteRequest.Post error: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return data, fmt.Errorf("[Frontend.main.insertELogEntry] ELogService response status %s", resp.Status)
+	}
+	return data, nil
+}
+
 // helper function to convert map value to unix nano
 func getUnixNano(v any) (int64, error) {
 	switch t := v.(type) {
@@ -1157,8 +1217,20 @@ func getUnixNano(v any) (int64, error) {
 	}
 }
 
-// helper function to get total number of records from upstream service
+// helper function to get total number of records from upstream service,
+// results are cached
 func countMetadataRecords() (int, error) {
+	val, err := getCache(countsCache).GetOrLoad("metadata", func() (any, error) {
+		return fetchMetadataCount()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return val.(int), nil
+}
+
+// helper function to fetch total number of records from MetaData service
+func fetchMetadataCount() (int, error) {
 	// get total number of metadata records in FOXDEN
 	_httpReadRequest.GetToken()
 	rec := services.ServiceRequest{
@@ -1211,35 +1283,41 @@ type NoteEntry struct {
 	Date uint64 `json:"date"`
 }
 
-func getNotes(dids []string) map[string]string {
+func getNotes(dids []string, loc *time.Location) map[string]string {
+	notes, err := fetchNotes(dids)
+	if err != nil {
+		log.Printf("ERROR: unable to get notes from ELogService, error: %v", err)
+	}
+	return notesToMap(notes, loc)
+}
+
+// helper function to fetch note entries of given dids from ELog service
+func fetchNotes(dids []string) ([]NoteEntry, error) {
+	var out []NoteEntry
 	rurl := fmt.Sprintf("%s/records", srvConfig.Config.Services.ELogServiceURL)
 	data, err := json.Marshal(dids)
 	if err != nil {
-		log.Printf("ERROR: unable to marshal dids, error: %v", err)
-		return nil
+		return nil, fmt.Errorf("[Frontend.main.fetchNotes] json.Marshal error: %w", err)
 	}
 	_httpReadRequest.GetToken()
 	resp, err := _httpReadRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
 	if err != nil {
-		log.Printf("ERROR: unable to request notes from ELogService, error: %v", err)
-		return nil
+		return nil, fmt.Errorf("[Frontend.main.fetchNotes] _httpReadRequest.Post error: %w", err)
 	}
 	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		log.Printf("ERROR: unable to read log entries ELogService, error: %v", err)
-		return nil
+		return nil, fmt.Errorf("[Frontend.main.fetchNotes] io.ReadAll error: %w", err)
 	}
-	var out []NoteEntry
-	err = json.Unmarshal(body, &out)
-	if err != nil {
-		log.Printf("ERROR: unable to unmarshal http response body, error: %v", err)
+	if err := json.Unmarshal(body, &out); err != nil {
+		return out, fmt.Errorf("[Frontend.main.fetchNotes] json.Unmarshal error: %w", err)
 	}
-	return notesToMap(out)
+	return out, nil
 }
 
-// noteToMap converts given note entries to a map used in UI
-func notesToMap(notes []NoteEntry) map[string]string {
+// noteToMap converts given note entries to a map used in UI, note dates are
+// shown in given time zone (local time if it is not set)
+func notesToMap(notes []NoteEntry, loc *time.Location) map[string]string {
 	// keep latest note per Did
 	latest := make(map[string]NoteEntry)
 
@@ -1254,6 +1332,9 @@ func notesToMap(notes []NoteEntry) map[string]string {
 
 	for did, n := range latest {
 		t := time.Unix(0, int64(n.Date))
+		if loc != nil {
+			t = t.In(loc)
+		}
 		result[did] = fmt.Sprintf(
 			"%s @ %s",
 			n.User,
@@ -0,0 +1,165 @@
+package main
+
+// impersonate module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The impersonate module allows members of AccessRules.AdminGroup to view
+// FOXDEN as another user, e.g. to debug why user can't see a dataset. The
+// impersonation is stored within admin session and it is read-only, i.e.
+// only routes marked with impersonate flag in route policy are accessible.
+// Within these routes getFoxdenUser returns attributes of impersonated
+// user, HTML pages carry impersonation banner and every request is audited.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrImpersonationReadOnly is returned for routes which are not available during impersonation
+var ErrImpersonationReadOnly = errors.New("route is not available during read-only impersonation")
+
+// helper function to get user impersonated within current session
+func sessionImpersonation(c *gin.Context) string {
+	// impersonation is only possible within browser session
+	if authz.BearerToken(c.Request) != "" {
+		return ""
+	}
+	value, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return _sessions.Impersonation(value)
+}
+
+// helper function to get user impersonated by current request
+func impersonatedUser(c *gin.Context) string {
+	return c.GetString("impersonate")
+}
+
+// helper function to apply session impersonation to current request, it is
+// called by policy middleware once admin is authenticated and authorized
+func impersonate(c *gin.Context, user string, rule PolicyRule) error {
+	target := sessionImpersonation(c)
+	if target == "" {
+		return nil
+	}
+	if !rule.Impersonate {
+		audit(c, "impersonate_request", c.Query("did"), nil, nil, ErrImpersonationReadOnly)
+		return ErrImpersonationReadOnly
+	}
+	c.Set("impersonate", target)
+	audit(c, "impersonate_request", c.Query("did"), nil, nil, nil)
+	if !apiClient(c) {
+		tmpl := server.MakeTmpl(StaticFs, "Impersonate")
+		tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+		tmpl["Admin"] = user
+		tmpl["User"] = target
+		banner := server.TmplPage(StaticFs, "impersonate_banner.tmpl", tmpl)
+		c.Writer = &bannerWriter{ResponseWriter: c.Writer, banner: []byte(banner)}
+	}
+	return nil
+}
+
+// bannerWriter wraps gin response writer to inject impersonation banner
+// right after body tag of HTML page
+type bannerWriter struct {
+	gin.ResponseWriter
+	banner []byte
+	done   bool
+}
+
+// Write implements io.Writer interface
+func (w *bannerWriter) Write(data []byte) (int, error) {
+	if w.done {
+		return w.ResponseWriter.Write(data)
+	}
+	idx := bytes.Index(data, []byte("<body"))
+	if idx < 0 {
+		return w.ResponseWriter.Write(data)
+	}
+	end := bytes.IndexByte(data[idx:], '>')
+	if end < 0 {
+		return w.ResponseWriter.Write(data)
+	}
+	w.done = true
+	pos := idx + end + 1
+	buf := make([]byte, 0, len(data)+len(w.banner))
+	buf = append(buf, data[:pos]...)
+	buf = append(buf, w.banner...)
+	buf = append(buf, data[pos:]...)
+	if _, err := w.ResponseWriter.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteString implements io.StringWriter interface
+func (w *bannerWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ImpersonateFormHandler provides access to GET /impersonate endpoint
+func ImpersonateFormHandler(c *gin.Context) {
+	tmpl := server.MakeTmpl(StaticFs, "Impersonate")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	content := server.TmplPage(StaticFs, "impersonate.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
+
+// ImpersonateHandler provides access to POST /impersonate endpoint
+func ImpersonateHandler(c *gin.Context) {
+	admin, _ := getUser(c)
+	target := c.Request.FormValue("user")
+	if target == "" || target == admin {
+		handleError(c, http.StatusBadRequest, "please provide user to impersonate", errors.New("invalid user"))
+		return
+	}
+	value, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "impersonation requires browser session", err)
+		return
+	}
+	if _, err := _foxdenUser.Get(target); err != nil {
+		msg := fmt.Sprintf("unable to find foxden user %s", target)
+		handleError(c, http.StatusBadRequest, msg, err)
+		return
+	}
+	err = _sessions.Impersonate(value, target)
+	audit(c, "impersonate_start", "", nil, map[string]any{"user": target}, err)
+	if err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to start impersonation", err)
+		return
+	}
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "user": target})
+		return
+	}
+	c.Redirect(http.StatusFound, DEFAULT_END_POINT)
+}
+
+// ImpersonateStopHandler provides access to POST /impersonate/stop endpoint
+func ImpersonateStopHandler(c *gin.Context) {
+	target := impersonatedUser(c)
+	value, err := c.Cookie(SessionCookieName)
+	if err == nil {
+		err = _sessions.Impersonate(value, "")
+	}
+	audit(c, "impersonate_stop", "", map[string]any{"user": target}, nil, err)
+	if err != nil {
+		handleError(c, http.StatusBadRequest, "unable to stop impersonation", err)
+		return
+	}
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+	c.Redirect(http.StatusFound, DEFAULT_END_POINT)
+}
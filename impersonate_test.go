@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestImpersonation tests read-only admin impersonation
+func TestImpersonation(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	value, _, err := _sessions.Create("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer _sessions.Revoke(value)
+	if err := _sessions.Impersonate(value, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		rule     PolicyRule
+		expected string // expected impersonated user, empty means denied request
+	}{
+		{name: "Read-only route", method: "GET", path: "/dstable", rule: PolicyRule{Impersonate: true}, expected: "bob"},
+		{name: "Write route", method: "POST", path: "/notes", rule: PolicyRule{}, expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(tt.method, tt.path, nil)
+			c.Request.Header.Set("Accept", "application/json")
+			c.Request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: value})
+			err := impersonate(c, "admin", tt.rule)
+			if tt.expected == "" && err == nil {
+				t.Errorf("expected denied request")
+			}
+			if user := impersonatedUser(c); user != tt.expected {
+				t.Errorf("expected impersonated user %q, got %q", tt.expected, user)
+			}
+		})
+	}
+
+	// banner should be injected right after body tag
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := &bannerWriter{ResponseWriter: c.Writer, banner: []byte("<div>banner</div>")}
+	w.WriteString("<html><body onload=\"f()\"><p>page</p></body></html>")
+	if body := rec.Body.String(); !strings.Contains(body, "<body onload=\"f()\"><div>banner</div><p>page</p>") {
+		t.Errorf("banner is not injected: %s", body)
+	}
+}
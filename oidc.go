@@ -0,0 +1,315 @@
+package main
+
+// oidc module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The oidc module provides generic OpenID Connect login provider. The
+// provider is configured via Frontend.OAuth record which has Issuer
+// attribute, e.g.
+//
+//	OAuth:
+//	  - Provider: cornell
+//	    Name: Cornell University
+//	    Issuer: https://idp.cornell.edu
+//	    ClientId: xxx
+//	    ClientSecret: yyy
+//	    RedirectUrl: https://foxden.classe.cornell.edu/cornell/callback
+//	    UserClaim: preferred_username
+//
+// The provider endpoints are obtained via issuer discovery, the login
+// flow uses PKCE and nonce, and the ID token claim defined by UserClaim
+// is mapped to FOXDEN user name.
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+// DefaultUserClaim defines ID token claim used for FOXDEN user name
+const DefaultUserClaim = "preferred_username"
+
+// oidcKeysReloadInterval defines minimal interval between reloads of provider
+// keys triggered by tokens with unknown key id
+const oidcKeysReloadInterval = time.Minute
+
+// OIDCProvider represents generic OpenID Connect provider
+type OIDCProvider struct {
+	Record        OIDCRecord
+	Configuration authz.OpenIDConfiguration
+	OAuth2        *oauth2.Config
+
+	mu       sync.RWMutex
+	keys     map[string]*rsa.PublicKey
+	reloaded time.Time // time of last reload of keys on unknown key id
+}
+
+// _oidcProviders holds all configured OIDC providers
+var _oidcProviders []*OIDCProvider
+
+// helper function to initialize OIDC providers from frontend configuration
+func initOIDCProviders() {
+	for _, rec := range _config.OAuth {
+		if rec.Issuer == "" {
+			continue
+		}
+		provider, err := NewOIDCProvider(rec)
+		if err != nil {
+			log.Printf("ERROR: unable to initialize OIDC provider %s, error %v", rec.Provider, err)
+			continue
+		}
+		_oidcProviders = append(_oidcProviders, provider)
+	}
+}
+
+// NewOIDCProvider creates new OIDC provider using issuer discovery
+func NewOIDCProvider(rec OIDCRecord) (*OIDCProvider, error) {
+	p := &OIDCProvider{Record: rec}
+	if p.Record.UserClaim == "" {
+		p.Record.UserClaim = DefaultUserClaim
+	}
+	if p.Record.Name == "" {
+		p.Record.Name = p.Record.Provider
+	}
+	issuer := strings.TrimSuffix(rec.Issuer, "/")
+	rurl := fmt.Sprintf("%s/.well-known/openid-configuration", issuer)
+	if err := fetchJSON(rurl, &p.Configuration); err != nil {
+		return nil, fmt.Errorf("[Frontend.main.NewOIDCProvider] discovery error: %w", err)
+	}
+	if p.Configuration.Issuer != issuer && p.Configuration.Issuer != rec.Issuer {
+		return nil, fmt.Errorf("[Frontend.main.NewOIDCProvider] issuer mismatch: %s", p.Configuration.Issuer)
+	}
+	if err := p.loadKeys(); err != nil {
+		return nil, fmt.Errorf("[Frontend.main.NewOIDCProvider] loadKeys error: %w", err)
+	}
+	scopes := rec.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	p.OAuth2 = &oauth2.Config{
+		ClientID:     rec.ClientID,
+		ClientSecret: rec.ClientSecret,
+		RedirectURL:  rec.RedirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.Configuration.AuthorizationEndpoint,
+			TokenURL: p.Configuration.TokenEndpoint,
+		},
+	}
+	if Verbose > 0 {
+		log.Printf("OIDC provider %s configuration %+v", rec.Provider, p.Configuration)
+	}
+	return p, nil
+}
+
+// helper function to fetch JSON document from given URL
+func fetchJSON(rurl string, obj any) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(rurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returns %s", rurl, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// helper function to load provider RSA public keys from its JWKS uri
+func (p *OIDCProvider) loadKeys() error {
+	var certs authz.Certs
+	if err := fetchJSON(p.Configuration.JWKSUri, &certs); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range certs.Keys {
+		// we only support RSA signing keys
+		if strings.ToLower(key.Kty) != "rsa" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		exp, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return err
+		}
+		mod, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return err
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: big.NewInt(0).SetBytes(mod),
+			E: int(big.NewInt(0).SetBytes(exp).Uint64()),
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("no RSA keys found in provider JWKS")
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// helper function to get provider public key with given key id
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// helper function to obtain public key for given JWT token
+func (p *OIDCProvider) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+	// provider may rotate its keys, we reload them but not more often than
+	// oidcKeysReloadInterval to not flood provider with tokens of unknown key id
+	p.mu.Lock()
+	reload := time.Since(p.reloaded) >= oidcKeysReloadInterval
+	if reload {
+		p.reloaded = time.Now()
+	}
+	p.mu.Unlock()
+	if reload {
+		if err := p.loadKeys(); err != nil {
+			return nil, err
+		}
+		if key, ok := p.key(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+// VerifyIDToken verifies ID token and returns its claims
+func (p *OIDCProvider) VerifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+	if _, err := parser.ParseWithClaims(idToken, claims, p.keyFunc); err != nil {
+		return claims, fmt.Errorf("[Frontend.main.OIDCProvider.VerifyIDToken] parse error: %w", err)
+	}
+	if !claims.VerifyIssuer(p.Configuration.Issuer, true) {
+		return claims, errors.New("[Frontend.main.OIDCProvider.VerifyIDToken] wrong issuer")
+	}
+	if !claims.VerifyAudience(p.Record.ClientID, true) {
+		return claims, errors.New("[Frontend.main.OIDCProvider.VerifyIDToken] wrong audience")
+	}
+	if val, _ := claims["nonce"].(string); val != nonce {
+		return claims, errors.New("[Frontend.main.OIDCProvider.VerifyIDToken] wrong nonce")
+	}
+	return claims, nil
+}
+
+// User maps ID token claims to FOXDEN user name
+func (p *OIDCProvider) User(claims jwt.MapClaims) (string, error) {
+	user, ok := claims[p.Record.UserClaim].(string)
+	if !ok || user == "" {
+		return "", fmt.Errorf("[Frontend.main.OIDCProvider.User] claim %s is not found in ID token", p.Record.UserClaim)
+	}
+	return user, nil
+}
+
+// helper function to generate random string
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Println("ERROR: rand.Read", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// helper function to construct session key for given provider attribute
+func (p *OIDCProvider) sessionKey(attr string) string {
+	return fmt.Sprintf("oidc_%s_%s", p.Record.Provider, attr)
+}
+
+// LoginHandler provides gin handler for OIDC login
+func (p *OIDCProvider) LoginHandler(c *gin.Context) {
+	state := randomString()
+	nonce := randomString()
+	verifier := oauth2.GenerateVerifier()
+	session := sessions.Default(c)
+	session.Set(p.sessionKey("state"), state)
+	session.Set(p.sessionKey("nonce"), nonce)
+	session.Set(p.sessionKey("verifier"), verifier)
+	if err := session.Save(); err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to save OIDC session", err)
+		return
+	}
+	rurl := p.OAuth2.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce))
+	c.Redirect(http.StatusSeeOther, rurl)
+}
+
+// CallBackHandler provides gin handler for OIDC callback
+func (p *OIDCProvider) CallBackHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	state, _ := session.Get(p.sessionKey("state")).(string)
+	nonce, _ := session.Get(p.sessionKey("nonce")).(string)
+	verifier, _ := session.Get(p.sessionKey("verifier")).(string)
+	session.Delete(p.sessionKey("state"))
+	session.Delete(p.sessionKey("nonce"))
+	session.Delete(p.sessionKey("verifier"))
+	session.Save()
+	if state == "" || state != c.Query("state") {
+		handleError(c, http.StatusUnauthorized, "OIDC state mismatch", errors.New("wrong state"))
+		return
+	}
+	if msg := c.Query("error"); msg != "" {
+		err := fmt.Errorf("%s: %s", msg, c.Query("error_description"))
+		handleError(c, http.StatusUnauthorized, "OIDC provider error", err)
+		return
+	}
+	token, err := p.OAuth2.Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		handleError(c, http.StatusUnauthorized, "unable to exchange OIDC code", err)
+		return
+	}
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		handleError(c, http.StatusUnauthorized, "OIDC provider did not return ID token", errors.New("no id_token"))
+		return
+	}
+	claims, err := p.VerifyIDToken(idToken, nonce)
+	if err != nil {
+		handleError(c, http.StatusUnauthorized, "invalid OIDC ID token", err)
+		return
+	}
+	user, err := p.User(claims)
+	if err != nil {
+		handleError(c, http.StatusUnauthorized, "unable to map OIDC user", err)
+		return
+	}
+	if err := startSession(c, user); err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to start user session", err)
+		return
+	}
+	if Verbose > 0 {
+		log.Printf("OIDC provider %s login user %s", p.Record.Provider, user)
+	}
+	c.Redirect(http.StatusSeeOther, DEFAULT_END_POINT)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockIdP represents local OpenID Connect identity provider used in tests
+type mockIdP struct {
+	Server    *httptest.Server
+	Key       *rsa.PrivateKey
+	Challenge string
+	Nonce     string
+	User      string
+	JWKSCalls int32 // number of requests to JWKS uri
+}
+
+// helper function to start mock IdP
+func newMockIdP(t *testing.T, clientID string) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{Key: key, User: "alice"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.Server.URL,
+			"authorization_endpoint": idp.Server.URL + "/authorize",
+			"token_endpoint":         idp.Server.URL + "/token",
+			"jwks_uri":               idp.Server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&idp.JWKSCalls, 1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		hash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(hash[:]) != idp.Challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":                idp.Server.URL,
+			"aud":                clientID,
+			"sub":                "123",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              idp.Nonce,
+			"preferred_username": idp.User,
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// TestOIDCProvider tests OIDC login flow against mock IdP
+func TestOIDCProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := newMockIdP(t, "foxden")
+	defer idp.Server.Close()
+
+	rec := OIDCRecord{
+		Provider:    "mock",
+		ClientID:    "foxden",
+		RedirectURL: "http://localhost/mock/callback",
+		Issuer:      idp.Server.URL,
+	}
+	provider, err := NewOIDCProvider(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(sessions.Sessions("server_session", cookie.NewStore([]byte("secret"))))
+	r.GET("/mock/login", provider.LoginHandler)
+	r.GET("/mock/callback", provider.CallBackHandler)
+
+	// login step should redirect to IdP with PKCE challenge and nonce
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mock/login", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login returns %d", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := loc.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		t.Fatalf("missing PKCE parameters in %s", loc)
+	}
+	idp.Challenge = params.Get("code_challenge")
+	idp.Nonce = params.Get("nonce")
+	cookies := w.Result().Cookies()
+
+	// callback with wrong state should be rejected
+	req := httptest.NewRequest("GET", "/mock/callback?code=abc&state=wrong", nil)
+	req.Header.Set("Accept", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong state returns %d", w.Code)
+	}
+
+	// restart login flow and complete callback
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mock/login", nil))
+	loc, _ = url.Parse(w.Header().Get("Location"))
+	params = loc.Query()
+	idp.Challenge = params.Get("code_challenge")
+	idp.Nonce = params.Get("nonce")
+	cookies = w.Result().Cookies()
+	req = httptest.NewRequest("GET", "/mock/callback?code=abc&state="+params.Get("state"), nil)
+	req.Header.Set("Accept", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("callback returns %d: %s", w.Code, w.Body.String())
+	}
+	var value string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookieName {
+			value = c.Value
+		}
+	}
+	sess, err := _sessions.Get(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.User != idp.User {
+		t.Errorf("expected user %s, got %s", idp.User, sess.User)
+	}
+}
+
+// TestOIDCProviderKeysReload tests that provider keys are reloaded on unknown
+// key id at most once per reload interval
+func TestOIDCProviderKeysReload(t *testing.T) {
+	idp := newMockIdP(t, "foxden")
+	defer idp.Server.Close()
+	provider, err := NewOIDCProvider(OIDCRecord{Provider: "mock", ClientID: "foxden", Issuer: idp.Server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := &jwt.Token{Header: map[string]any{"kid": "test"}}
+	unknown := &jwt.Token{Header: map[string]any{"kid": "unknown"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.keyFunc(known); err != nil {
+				t.Error(err)
+			}
+			if _, err := provider.keyFunc(unknown); err == nil {
+				t.Error("expected error for unknown key id")
+			}
+		}()
+	}
+	wg.Wait()
+	// one request at provider initialization and one reload on unknown key id
+	if calls := atomic.LoadInt32(&idp.JWKSCalls); calls != 2 {
+		t.Errorf("expected 2 JWKS requests, got %d", calls)
+	}
+	// reload is allowed again after reload interval
+	provider.mu.Lock()
+	provider.reloaded = time.Now().Add(-oidcKeysReloadInterval)
+	provider.mu.Unlock()
+	provider.keyFunc(unknown)
+	if calls := atomic.LoadInt32(&idp.JWKSCalls); calls != 3 {
+		t.Errorf("expected 3 JWKS requests, got %d", calls)
+	}
+}
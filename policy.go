@@ -0,0 +1,246 @@
+package main
+
+// policy module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The policy module provides central route authorization policy. The
+// policy is defined in JSON file which maps route paths and HTTP methods
+// to required scopes and FOXDEN groups, e.g.
+//
+//	{
+//	    "default": {"scopes": [], "groups": []},
+//	    "rules": [
+//	        {"path": "/", "methods": ["GET"], "public": true},
+//	        {"path": "/tmpl/delete", "methods": ["POST"], "scopes": ["delete"]},
+//	        {"path": "/audit", "groups": ["@admin"]},
+//	        {"path": "/dstable", "methods": ["GET"], "impersonate": true}
+//	    ]
+//	}
+//
+// The special @admin group refers to AccessRules.AdminGroup of FOXDEN
+// configuration. Rule scopes are checked against scope of bearer token,
+// while browser sessions only need authentication and required groups.
+// Routes with impersonate flag are read-only and remain
+// available while admin impersonates another user.
+// Route paths are the ones used in setupRouter. Routes without explicit
+// rule use default rule, i.e. they require authenticated user. The policy
+// is enforced by single middleware which is attached to every route.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// PolicyRule represents authorization rule of a route
+type PolicyRule struct {
+	Path    string   `json:"path"`    // route path as defined in router
+	Methods []string `json:"methods"` // HTTP methods, empty list means all methods
+	Public  bool     `json:"public"`  // route does not require authentication
+	Scopes  []string `json:"scopes"`  // required token scopes, all of them should be present
+	Groups  []string `json:"groups"`  // required FOXDEN groups, any of them is sufficient
+
+	// route is read-only and available during admin impersonation of other user
+	Impersonate bool `json:"impersonate"`
+}
+
+// match checks if rule matches given method and path
+func (r *PolicyRule) match(method, path string) bool {
+	if r.Path != path {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy represents route authorization policy
+type Policy struct {
+	Default PolicyRule   `json:"default"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// Rule returns policy rule for given method and path
+func (p *Policy) Rule(method, path string) PolicyRule {
+	for _, rule := range p.Rules {
+		if rule.match(method, path) {
+			return rule
+		}
+	}
+	rule := p.Default
+	rule.Path = path
+	return rule
+}
+
+// _policy holds route authorization policy
+var _policy = &Policy{}
+
+// helper function to load route authorization policy, by default we use policy
+// embedded into static area, it can be overwritten by Frontend.PolicyFile
+func initPolicy() {
+	var data []byte
+	var err error
+	if _config.PolicyFile != "" {
+		data, err = os.ReadFile(_config.PolicyFile)
+	} else {
+		data, err = StaticFs.ReadFile("static/config/route_policy.json")
+	}
+	if err != nil {
+		log.Fatalf("unable to read route policy, error %v", err)
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		log.Fatalf("unable to parse route policy, error %v", err)
+	}
+	_policy = policy
+}
+
+// helper function to check if request comes from API client rather than browser
+func apiClient(c *gin.Context) bool {
+	if c.GetHeader("Authorization") != "" || c.GetHeader("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	return strings.Contains(c.GetHeader("Accept"), "application/json")
+}
+
+// helper function to obtain scopes of bearer token
+func tokenScopes(c *gin.Context) ([]string, error) {
+	token := authz.BearerToken(c.Request)
+	claims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(claims.CustomClaims.Scope, "+"), nil
+}
+
+// helper function to obtain user groups
+func userGroups(c *gin.Context, user string) ([]string, error) {
+	var groups []string
+	fuser, err := getFoxdenUser(c, user)
+	if err != nil {
+		return groups, err
+	}
+	groups = append(groups, fuser.FoxdenGroups...)
+	groups = append(groups, fuser.Groups...)
+	return groups, nil
+}
+
+// helper function to authorize user for given rule, rule scopes apply to
+// requests with bearer token only since token scope defines what bearer of
+// the token is allowed to do, while session users are authorized by their
+// authentication and groups
+func authorize(c *gin.Context, user string, rule PolicyRule) error {
+	if srvConfig.Config.Frontend.TestMode {
+		return nil
+	}
+	if len(rule.Scopes) > 0 && authz.BearerToken(c.Request) != "" {
+		scopes, err := tokenScopes(c)
+		if err != nil {
+			return err
+		}
+		for _, scope := range rule.Scopes {
+			if !utils.InList(scope, scopes) {
+				return fmt.Errorf("user %s token does not have %s scope", user, scope)
+			}
+		}
+	}
+	if len(rule.Groups) == 0 {
+		return nil
+	}
+	groups, err := userGroups(c, user)
+	if err != nil {
+		return err
+	}
+	for _, grp := range rule.Groups {
+		if grp == "@admin" {
+			grp = srvConfig.Config.AccessRules.AdminGroup
+		}
+		if utils.InList(grp, groups) {
+			return nil
+		}
+	}
+	return fmt.Errorf("user %s does not belong to any of %v groups", user, rule.Groups)
+}
+
+// PolicyMiddleware provides authorization middleware for given route
+func PolicyMiddleware(method, path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := _policy.Rule(method, path)
+		if rule.Public {
+			return
+		}
+		user, err := getUser(c)
+		if err != nil || user == "" {
+			if err == nil {
+				err = errors.New("empty user")
+			}
+			if apiClient(c) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized,
+					gin.H{"error": err.Error(), "message": "authentication required", "code": http.StatusUnauthorized})
+				return
+			}
+			c.Redirect(http.StatusFound, base("/login"))
+			c.Abort()
+			return
+		}
+		if err := authorize(c, user, rule); err != nil {
+			if Verbose > 0 {
+				log.Printf("access denied to %s %s, error %v", method, path, err)
+			}
+			if apiClient(c) {
+				c.AbortWithStatusJSON(http.StatusForbidden,
+					gin.H{"error": err.Error(), "message": "access denied", "code": http.StatusForbidden})
+				return
+			}
+			handleError(c, http.StatusForbidden, "access denied", err)
+			c.Abort()
+			return
+		}
+		// remember authenticated user for handlers
+		c.Set("user", user)
+		if err := impersonate(c, user, rule); err != nil {
+			if apiClient(c) {
+				c.AbortWithStatusJSON(http.StatusForbidden,
+					gin.H{"error": err.Error(), "message": "access denied", "code": http.StatusForbidden})
+				return
+			}
+			handleError(c, http.StatusForbidden, "access denied", err)
+			c.Abort()
+		}
+	}
+}
+
+// helper function to attach policy middleware to given routes
+func policyRoutes(routes []server.Route) []server.Route {
+	for i, route := range routes {
+		handler := route.Handler
+		middleware := PolicyMiddleware(route.Method, route.Path)
+		routes[i].Handler = func(c *gin.Context) {
+			// assign request ID to be able to correlate logs and audit entries
+			requestID(c)
+			middleware(c)
+			if c.IsAborted() {
+				return
+			}
+			handler(c)
+		}
+	}
+	return routes
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// testPolicy defines route policy used in tests
+var testPolicy = &Policy{
+	Default: PolicyRule{},
+	Rules: []PolicyRule{
+		{Path: "/", Methods: []string{"GET"}, Public: true},
+		{Path: "/record", Methods: []string{"POST"}, Scopes: []string{"write"}},
+		{Path: "/tmpl/delete", Methods: []string{"post"}, Scopes: []string{"delete"}},
+		{Path: "/audit", Groups: []string{"@admin"}},
+	},
+}
+
+// TestPolicyRule tests matching of policy rules
+func TestPolicyRule(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		rule   PolicyRule
+	}{
+		{"GET", "/", testPolicy.Rules[0]},
+		{"POST", "/", PolicyRule{Path: "/"}},
+		{"POST", "/record", testPolicy.Rules[1]},
+		{"GET", "/record", PolicyRule{Path: "/record"}},
+		{"POST", "/tmpl/delete", testPolicy.Rules[2]},
+		{"GET", "/audit", testPolicy.Rules[3]},
+		{"DELETE", "/audit", testPolicy.Rules[3]},
+		{"GET", "/audit/other", PolicyRule{Path: "/audit/other"}},
+	}
+	for _, tt := range tests {
+		rule := testPolicy.Rule(tt.method, tt.path)
+		if !reflect.DeepEqual(rule, tt.rule) {
+			t.Errorf("%s %s: rule %+v, want %+v", tt.method, tt.path, rule, tt.rule)
+		}
+	}
+}
+
+// TestRoutePolicyScopes tests that mutating routes of route policy require scopes
+func TestRoutePolicyScopes(t *testing.T) {
+	data, err := StaticFs.ReadFile("static/config/route_policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{"POST", "/amendrecord", "write"},
+		{"POST", "/publish", "write"},
+		{"POST", "/bulk", "write"},
+		{"POST", "/doipublic", "write"},
+		{"POST", "/meta/form/upload", "write"},
+		{"POST", "/tmpl/delete", "delete"},
+		{"DELETE", "/sync/delete/:uuid", "delete"},
+	}
+	for _, tt := range tests {
+		rule := policy.Rule(tt.method, tt.path)
+		if !reflect.DeepEqual(rule.Scopes, []string{tt.scope}) {
+			t.Errorf("%s %s: scopes %v, want %s", tt.method, tt.path, rule.Scopes, tt.scope)
+		}
+	}
+	// read-only routes should not require scopes
+	if rule := policy.Rule("GET", "/publish"); len(rule.Scopes) != 0 {
+		t.Errorf("GET /publish requires scopes %v", rule.Scopes)
+	}
+}
+
+// TestPolicyMiddleware tests authentication and authorization of routes
+func TestPolicyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	frontend := srvConfig.Config.Frontend
+	accessRules := srvConfig.Config.AccessRules
+	clientID := srvConfig.Config.Authz.ClientID
+	policy, foxdenUser := _policy, _foxdenUser
+	defer func() {
+		srvConfig.Config.Frontend = frontend
+		srvConfig.Config.AccessRules = accessRules
+		srvConfig.Config.Authz.ClientID = clientID
+		_policy, _foxdenUser = policy, foxdenUser
+	}()
+	srvConfig.Config.Frontend.TestMode = false
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+	srvConfig.Config.Authz.ClientID = "test-client-id"
+	_policy = testPolicy
+	_foxdenUser = &foxdenUserStub{user: services.User{
+		Name:   "alice",
+		Scopes: []string{"read", "write"},
+		Groups: []string{"users"},
+	}}
+	readToken, err := newToken("alice", "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeToken, err := newToken("alice", "read+write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		user     string
+		token    string
+		accept   string
+		code     int
+		location string
+	}{
+		{"public route", "GET", "/", "", "", "", http.StatusOK, ""},
+		{"anonymous browser", "GET", "/datasets", "", "", "", http.StatusFound, "/login"},
+		{"anonymous api", "GET", "/datasets", "", "", "application/json", http.StatusUnauthorized, ""},
+		{"authenticated", "GET", "/datasets", "alice", "", "", http.StatusOK, ""},
+		{"user scope", "POST", "/record", "alice", "", "application/json", http.StatusOK, ""},
+		{"session scope", "POST", "/tmpl/delete", "alice", "", "", http.StatusOK, ""},
+		{"token scope", "POST", "/record", "", writeToken, "", http.StatusOK, ""},
+		{"missing token scope", "POST", "/record", "", readToken, "", http.StatusForbidden, ""},
+		{"missing token scope browser", "POST", "/tmpl/delete", "", writeToken, "text/html", http.StatusForbidden, ""},
+		{"missing group", "GET", "/audit", "alice", "", "application/json", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.accept != "" {
+				c.Request.Header.Set("Accept", tt.accept)
+			}
+			if tt.token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.user != "" {
+				c.Set("user", tt.user)
+			}
+			PolicyMiddleware(tt.method, tt.path)(c)
+			if tt.code == http.StatusOK {
+				if c.IsAborted() {
+					t.Errorf("request is aborted with code %d", w.Code)
+				}
+				return
+			}
+			if !c.IsAborted() {
+				t.Errorf("request is not aborted")
+			}
+			if w.Code != tt.code {
+				t.Errorf("code %d, want %d", w.Code, tt.code)
+			}
+			if tt.location != "" && w.Header().Get("Location") != tt.location {
+				t.Errorf("location %q, want %q", w.Header().Get("Location"), tt.location)
+			}
+		})
+	}
+
+	// plain session user without scopes can still write records
+	data, err := StaticFs.ReadFile("static/config/route_policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_policy = &Policy{}
+	if err := json.Unmarshal(data, _policy); err != nil {
+		t.Fatal(err)
+	}
+	_foxdenUser = &foxdenUserStub{user: services.User{Name: "carol"}}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/notes", nil)
+	c.Set("user", "carol")
+	PolicyMiddleware("POST", "/notes")(c)
+	if c.IsAborted() {
+		t.Errorf("session user access to POST /notes is denied")
+	}
+
+	// admin group grants access to admin routes
+	_policy = testPolicy
+	_foxdenUser = &foxdenUserStub{user: services.User{Name: "bob", FoxdenGroups: []string{"admins"}}}
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/audit", nil)
+	c.Set("user", "bob")
+	PolicyMiddleware("GET", "/audit")(c)
+	if c.IsAborted() {
+		t.Errorf("admin access to /audit is denied")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+// preferences module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The preferences module keeps UI preferences of users on the server side,
+// i.e. visible columns of datasets and spec scans tables, default sort,
+// page size, default view and time zone. Preferences follow the user across
+// browsers and machines and they are managed via /preferences page or its
+// JSON API, e.g.
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	     -d '{"page_size": 25, "time_zone": "America/New_York"}' /preferences
+//
+// JSON requests update only provided preferences.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	"github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// searchViews lists views which can be used as default page of the user
+var searchViews = []string{"dstable", "search", "specscans"}
+
+// pageSizes lists supported page sizes
+var pageSizes = []int{10, 25, 50, 100}
+
+// Preferences represents UI preferences of the user
+type Preferences struct {
+	User             string    `json:"user"`
+	DatasetColumns   []string  `json:"dstable_columns"`   // visible columns of datasets table
+	SpecScansColumns []string  `json:"specscans_columns"` // visible columns of spec scans table
+	SortKey          string    `json:"sort_key"`          // default sort key
+	SortOrder        string    `json:"sort_order"`        // default sort order, ascending or descending
+	PageSize         int       `json:"page_size"`         // number of records per page
+	SearchView       string    `json:"search_view"`       // default view, dstable, search or specscans
+	TimeZone         string    `json:"time_zone"`         // IANA time zone used to show timestamps
+	Updated          time.Time `json:"updated"`
+}
+
+// helper function to return list of columns without empty and duplicate values
+func cleanColumns(columns []string) []string {
+	var out []string
+	for _, col := range columns {
+		if col = strings.TrimSpace(col); col != "" && !utils.InList(col, out) {
+			out = append(out, col)
+		}
+	}
+	return out
+}
+
+// Validate validates and normalizes preferences
+func (p *Preferences) Validate() error {
+	p.DatasetColumns = cleanColumns(p.DatasetColumns)
+	p.SpecScansColumns = cleanColumns(p.SpecScansColumns)
+	p.SortKey = strings.TrimSpace(p.SortKey)
+	switch p.SortOrder {
+	case "", "ascending", "descending":
+	case "asc":
+		p.SortOrder = "ascending"
+	case "desc":
+		p.SortOrder = "descending"
+	default:
+		return fmt.Errorf("unsupported sort order %q, please use ascending or descending", p.SortOrder)
+	}
+	if p.PageSize != 0 && !utils.InList(p.PageSize, pageSizes) {
+		return fmt.Errorf("unsupported page size %d, supported sizes are %v", p.PageSize, pageSizes)
+	}
+	if p.SearchView != "" && !utils.InList(p.SearchView, searchViews) {
+		return fmt.Errorf("unsupported view %q, supported views are %s", p.SearchView, strings.Join(searchViews, ", "))
+	}
+	if p.TimeZone != "" {
+		if _, err := time.LoadLocation(p.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone %q", p.TimeZone)
+		}
+	}
+	return nil
+}
+
+// Location returns time zone of the user, nil is returned if time zone is not set
+func (p Preferences) Location() *time.Location {
+	if p.TimeZone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// Limit returns page size of the user or default page size
+func (p Preferences) Limit() int {
+	if p.PageSize > 0 {
+		return p.PageSize
+	}
+	return 10
+}
+
+// Sort returns default sort key and MongoDB sort order of the user
+func (p Preferences) Sort() (string, int) {
+	key := p.SortKey
+	if key == "" {
+		key = "date"
+	}
+	if p.SortOrder == "ascending" {
+		return key, 1
+	}
+	return key, -1
+}
+
+// PreferenceStore represents persistent store of user preferences
+type PreferenceStore struct {
+	store       *JSONStore
+	preferences map[string]*Preferences
+	mu          sync.Mutex
+}
+
+// _preferences holds preferences of all users
+var _preferences = &PreferenceStore{preferences: make(map[string]*Preferences)}
+
+// helper function to initialize user preferences from persistent storage
+func initPreferences() {
+	_preferences.store = NewJSONStore("preferences.json")
+	if err := _preferences.Load(); err != nil {
+		log.Println("ERROR: unable to load user preferences", err)
+	}
+}
+
+// Load loads preferences from persistent store
+func (s *PreferenceStore) Load() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*Preferences
+	if err := s.store.Load(&records); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range records {
+		s.preferences[rec.User] = rec
+	}
+	return nil
+}
+
+// helper function to persist preferences, it should be called with acquired lock
+func (s *PreferenceStore) save() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*Preferences
+	for _, rec := range s.preferences {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].User < records[j].User
+	})
+	return s.store.Save(records)
+}
+
+// Get returns preferences of given user, empty preferences are returned if
+// user did not set them yet
+func (s *PreferenceStore) Get(user string) Preferences {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.preferences[user]; ok {
+		return *rec
+	}
+	return Preferences{User: user}
+}
+
+// Set validates and stores preferences of given user
+func (s *PreferenceStore) Set(user string, prefs Preferences) (Preferences, error) {
+	if err := prefs.Validate(); err != nil {
+		return prefs, err
+	}
+	prefs.User = user
+	prefs.Updated = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preferences[user] = &prefs
+	return prefs, s.save()
+}
+
+// helper function to get preferences of login user, admin impersonation does
+// not change UI preferences
+func userPreferences(user string) Preferences {
+	return _preferences.Get(user)
+}
+
+// helper function to parse preferences from web form
+func formPreferences(c *gin.Context) Preferences {
+	r := c.Request
+	prefs := Preferences{
+		DatasetColumns:   strings.Split(r.FormValue("dstable_columns"), ","),
+		SpecScansColumns: strings.Split(r.FormValue("specscans_columns"), ","),
+		SortKey:          r.FormValue("sort_key"),
+		SortOrder:        r.FormValue("sort_order"),
+		SearchView:       r.FormValue("search_view"),
+		TimeZone:         strings.TrimSpace(r.FormValue("time_zone")),
+	}
+	prefs.PageSize, _ = strconv.Atoi(r.FormValue("page_size"))
+	return prefs
+}
+
+// PreferencesHandler provides access to GET /preferences endpoint
+func PreferencesHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	prefs := userPreferences(user)
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, prefs)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Preferences")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["User"] = user
+	tmpl["Preferences"] = prefs
+	tmpl["DatasetColumns"] = strings.Join(prefs.DatasetColumns, ",")
+	tmpl["SpecScansColumns"] = strings.Join(prefs.SpecScansColumns, ",")
+	tmpl["SearchViews"] = searchViews
+	tmpl["PageSizes"] = pageSizes
+	content := server.TmplPage(StaticFs, "preferences.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
+
+// PreferencesSaveHandler provides access to POST /preferences endpoint
+func PreferencesSaveHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	jsonRequest := strings.Contains(c.ContentType(), "json")
+	prefs := userPreferences(user)
+	if jsonRequest {
+		// JSON request updates only provided preferences
+		if err := json.NewDecoder(c.Request.Body).Decode(&prefs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		prefs = formPreferences(c)
+	}
+	prefs, err := _preferences.Set(user, prefs)
+	if err != nil {
+		if jsonRequest || c.Request.Header.Get("Accept") == "application/json" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		handleError(c, http.StatusBadRequest, "unable to save preferences", err)
+		return
+	}
+	if jsonRequest || c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, prefs)
+		return
+	}
+	c.Redirect(http.StatusFound, base("/preferences"))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestPreferencesValidate tests validation of user preferences
+func TestPreferencesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		prefs Preferences
+		fail  bool
+	}{
+		{"empty", Preferences{}, false},
+		{"valid", Preferences{SortKey: "cycle", SortOrder: "asc", PageSize: 25, SearchView: "search", TimeZone: "America/New_York"}, false},
+		{"sort order", Preferences{SortOrder: "random"}, true},
+		{"page size", Preferences{PageSize: 7}, true},
+		{"view", Preferences{SearchView: "graph"}, true},
+		{"time zone", Preferences{TimeZone: "Mars/Olympus"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.prefs.Validate()
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+	prefs := Preferences{DatasetColumns: []string{" date", "", "btr", "date"}, SortOrder: "desc"}
+	prefs.Validate()
+	if !reflect.DeepEqual(prefs.DatasetColumns, []string{"date", "btr"}) || prefs.SortOrder != "descending" {
+		t.Errorf("unexpected normalized preferences %+v", prefs)
+	}
+}
+
+// TestPreferenceStore tests store of user preferences and their defaults
+func TestPreferenceStore(t *testing.T) {
+	store := &PreferenceStore{preferences: make(map[string]*Preferences)}
+	prefs := store.Get("alice")
+	if key, order := prefs.Sort(); key != "date" || order != -1 || prefs.Limit() != 10 || prefs.Location() != nil {
+		t.Errorf("unexpected default preferences %+v", prefs)
+	}
+	if _, err := store.Set("alice", Preferences{PageSize: 3}); err == nil {
+		t.Error("expected error of invalid preferences")
+	}
+	if _, err := store.Set("alice", Preferences{User: "bob", SortKey: "cycle", SortOrder: "ascending", PageSize: 50, TimeZone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+	prefs = store.Get("alice")
+	if key, order := prefs.Sort(); key != "cycle" || order != 1 || prefs.Limit() != 50 || prefs.User != "alice" {
+		t.Errorf("unexpected preferences %+v", prefs)
+	}
+	if prefs.Location() != time.UTC {
+		t.Errorf("unexpected time zone %v", prefs.Location())
+	}
+	if store.Get("bob").PageSize != 0 {
+		t.Error("preferences of other user should not be changed")
+	}
+}
+
+// TestLastModified tests timestamp of last modification in user time zone
+func TestLastModified(t *testing.T) {
+	rec := map[string]any{"date": float64(0)}
+	if val, _ := lastModified(rec, nil); val != "Thu, 01 Jan 1970 00:00:00 UTC" {
+		t.Errorf("unexpected UTC timestamp %s", val)
+	}
+	loc, _ := time.LoadLocation("America/New_York")
+	if val, _ := lastModified(rec, loc); val != "Wed, 31 Dec 1969 19:00:00 EST" {
+		t.Errorf("unexpected local timestamp %s", val)
+	}
+}
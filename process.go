@@ -41,6 +41,9 @@ func validJSON(query string) error {
 func getRecordHTML(c *gin.Context, rec services.ServiceRequest, user string) string {
 	tmpl := server.MakeTmpl(StaticFs, "Search")
 	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	if err := restrictRequest(c, &rec); err != nil {
+		return fmt.Sprintf("unable to apply token restrictions, error %v", err)
+	}
 	query := cleanQuery(rec.ServiceQuery.Query)
 	tmpl["Query"] = query
 	err := validJSON(query)
@@ -72,26 +75,53 @@ func getRecordHTML(c *gin.Context, rec services.ServiceRequest, user string) str
 	}
 
 	records := response.Results.Records
-	// extract userAttrs cookies which list which attributes to show in a record
-	var attrs2show []string
-	if cookie, err := c.Request.Cookie("userAttrs"); err == nil {
-		for _, v := range strings.Split(cookie.Value, ",") {
-			attrs2show = append(attrs2show, strings.Trim(v, " "))
-		}
-	}
-
-	content := records2html(user, records, attrs2show)
+	// user preferences list which attributes to show in a record
+	prefs := userPreferences(user)
+	content := records2html(user, records, prefs.DatasetColumns, prefs.Location())
 	return content
 }
 
 // helper function to process service request
-func processResults(c *gin.Context, rec services.ServiceRequest, user string, idx, limit int, btrs []string) {
+// with non-nil cursor the page of records following (or preceding) cursor
+// position is requested instead of skipping idx records
+func processResults(c *gin.Context, rec services.ServiceRequest, user string, idx, limit int, btrs []string, cur *Cursor) {
 	tmpl := server.MakeTmpl(StaticFs, "Search")
 	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	if err := restrictRequest(c, &rec); err != nil {
+		handleError(c, http.StatusBadRequest, "unable to apply token restrictions", err)
+		return
+	}
 	log.Printf("service request record\n%s", rec.String())
 	query := cleanQuery(rec.ServiceQuery.Query)
 	tmpl["Query"] = query
 	err1 := validJSON(query)
+	// did is used as tie-breaker to have stable order of records for cursors
+	sortKeys := cursorKeys(rec.ServiceQuery.SortKeys, []string{"did"})
+	sortOrder := rec.ServiceQuery.SortOrder
+	if sortOrder != 1 {
+		sortOrder = -1
+	}
+	rec.ServiceQuery.SortKeys = sortKeys
+	// resolve keys of other FOXDEN services and join their results on did,
+	// pagination links keep original query
+	if err1 == nil {
+		partial, err := federateRequest(&rec)
+		if err != nil {
+			handleError(c, http.StatusBadRequest, "unable to federate query", err)
+			return
+		}
+		if partial {
+			c.Header("X-Federated-Partial", "true")
+			tmpl["Partial"] = federatedPartialNote
+		}
+	}
+	if cur != nil && err1 == nil {
+		sortKeys, sortOrder = cur.Keys, cur.Order
+		if err := applyCursor(&rec, *cur); err != nil {
+			handleError(c, http.StatusBadRequest, "unable to apply cursor", err)
+			return
+		}
+	}
 	data, err2 := json.Marshal(rec)
 	if err1 != nil || err2 != nil {
 		tmpl["FixQuery"] = query
@@ -137,6 +167,17 @@ func processResults(c *gin.Context, rec services.ServiceRequest, user string, id
 	if Verbose > 1 {
 		log.Printf("meta-data response\n%+v", response)
 	}
+	records := cursorRecords(cur, response.Results.Records)
+	response.Results.Records = records
+	nrecords := response.Results.NRecords
+	if cur != nil {
+		// with cursor discovery service counts records beyond cursor position
+		// therefore we use total number of records recorded in a cursor
+		idx, nrecords = cur.Idx, cur.Total
+	}
+	cursors := pageCursors(sortKeys, sortOrder, records, idx, limit, nrecords)
+	c.Header("X-Cursor-Next", cursors.Next)
+	c.Header("X-Cursor-Prev", cursors.Prev)
 	// return respose JSON if requested
 	if c.Request.Header.Get("Accept") == "application/json" {
 		c.JSON(http.StatusOK, response)
@@ -144,34 +185,26 @@ func processResults(c *gin.Context, rec services.ServiceRequest, user string, id
 	}
 
 	// otherwise create proper HTML
-	if response.Results.NRecords == 0 {
+	if nrecords == 0 {
 		tmpl["Content"] = fmt.Sprintf("No records found for your query:\n<pre>%s</pre>", query)
 		page := server.TmplPage(StaticFs, "noresults.tmpl", tmpl)
 		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+page+footerEmpty()))
 		return
 	}
-	records := response.Results.Records
-	nrecords := response.Results.NRecords
-	// extract userAttrs cookies which list which attributes to show in a record
-	var attrs2show []string
-	if cookie, err := c.Request.Cookie("userAttrs"); err == nil {
-		for _, v := range strings.Split(cookie.Value, ",") {
-			attrs2show = append(attrs2show, strings.Trim(v, " "))
-		}
-	}
-
-	content := records2html(user, records, attrs2show)
+	// user preferences list which attributes to show in a record
+	prefs := userPreferences(user)
+	content := records2html(user, records, prefs.DatasetColumns, prefs.Location())
 	tmpl["Records"] = template.HTML(content)
 
 	sortKey := "date"
-	if len(rec.ServiceQuery.SortKeys) > 0 {
-		sortKey = rec.ServiceQuery.SortKeys[0]
+	if len(sortKeys) > 0 {
+		sortKey = sortKeys[0]
 	}
-	sortOrder := "descending"
-	if rec.ServiceQuery.SortOrder == 1 {
-		sortOrder = "ascending"
+	order := "descending"
+	if sortOrder == 1 {
+		order = "ascending"
 	}
-	pages := pagination(c, query, nrecords, idx, limit, sortKey, sortOrder, btrs)
+	pages := pagination(c, query, nrecords, idx, limit, sortKey, order, btrs, &cursors)
 	tmpl["Pagination"] = template.HTML(pages)
 
 	page := server.TmplPage(StaticFs, "records.tmpl", tmpl)
@@ -0,0 +1,333 @@
+package main
+
+// querylang module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The querylang module provides compact FOXDEN query syntax which is compiled
+// to MongoDB spec, e.g.
+//
+//	beamline:3a cycle:2024-1 beam_energy>40 sample_name~"Ti*"
+//
+// A query is a list of space separated conditions key<op>value, where op is
+// one of : (or =), !=, >, >=, <, <= and ~ (glob pattern match). Values with
+// spaces should be quoted. Keys, data types and units are validated against
+// QL records of FOXDEN service map, and values are converted to key data
+// type, e.g. beam_energy>40keV yields {"beam_energy":{"$gt":40}} if key units
+// are keV. Queries in JSON data-format are passed as is.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	ql "github.com/CHESSComputing/golib/ql"
+)
+
+// QueryError represents error of compact query with its position
+type QueryError struct {
+	Query    string // user query
+	Position int    // position (1-based) of the error within the query
+	Message  string // error message
+}
+
+// Error implements error interface
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Position, e.Message)
+}
+
+// Details returns query with marker pointing to error position
+func (e *QueryError) Details() string {
+	return fmt.Sprintf("%s\n%s^\n%s", e.Query, strings.Repeat(" ", e.Position-1), e.Error())
+}
+
+// query operators and corresponding MongoDB operators
+var queryOperators = []struct {
+	op    string
+	mongo string
+}{
+	// two character operators should be checked first
+	{">=", "$gte"}, {"<=", "$lte"}, {"!=", "$ne"},
+	{":", ""}, {"=", ""}, {">", "$gt"}, {"<", "$lt"}, {"~", "$regex"},
+}
+
+// queryTerm represents single condition of compact query
+type queryTerm struct {
+	key    string
+	op     string // MongoDB operator, empty for equality
+	value  string
+	quoted bool
+	keyPos int // position of the term key
+	pos    int // position of the term value
+}
+
+// helper function to check if query is provided in JSON data-format
+func jsonQuery(query string) bool {
+	return strings.HasPrefix(strings.TrimSpace(query), "{")
+}
+
+// helper function to get QL records keyed by QL key, nil is returned if
+// service map is not available and keys can't be validated
+func queryKeys() map[string]ql.QLRecord {
+	records, err := ql.QLRecords("")
+	if err != nil {
+		if Verbose > 0 {
+			log.Println("WARNING: unable to load QL records, query keys will not be validated", err)
+		}
+		return nil
+	}
+	keys := make(map[string]ql.QLRecord)
+	for _, rec := range records {
+		keys[rec.Key] = rec
+	}
+	return keys
+}
+
+// helper function to convert user query into MongoDB spec, queries in JSON
+// data-format are unmarshalled as is while compact queries are compiled
+func querySpec(query string) (map[string]any, error) {
+	spec := make(map[string]any)
+	if strings.TrimSpace(query) == "" {
+		return spec, nil
+	}
+	if jsonQuery(query) {
+		if err := json.Unmarshal([]byte(query), &spec); err != nil {
+			return nil, fmt.Errorf("[Frontend.main.querySpec] json.Unmarshal error: %w", err)
+		}
+		return spec, nil
+	}
+	return compileQuery(query, queryKeys())
+}
+
+// helper function to compile compact query into MongoDB spec
+func compileQuery(query string, keys map[string]ql.QLRecord) (map[string]any, error) {
+	terms, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	spec := make(map[string]any)
+	for _, term := range terms {
+		qerr := func(pos int, format string, args ...any) error {
+			return &QueryError{Query: query, Position: pos, Message: fmt.Sprintf(format, args...)}
+		}
+		var rec *ql.QLRecord
+		if keys != nil {
+			r, ok := keys[term.key]
+			if !ok {
+				return nil, qerr(term.keyPos, "unknown key %q", term.key)
+			}
+			rec = &r
+		}
+		val, err := termValue(term, rec)
+		if err != nil {
+			return nil, qerr(term.pos, "%v", err)
+		}
+		var cond any = val
+		if term.op == "$regex" {
+			cond = map[string]any{"$regex": val, "$options": "i"}
+		} else if term.op != "" {
+			cond = map[string]any{term.op: val}
+		}
+		prev, ok := spec[term.key]
+		if !ok {
+			spec[term.key] = cond
+			continue
+		}
+		// multiple conditions on the same key, e.g. beam_energy>40 beam_energy<50
+		pmap, pok := prev.(map[string]any)
+		cmap, cok := cond.(map[string]any)
+		if !pok || !cok {
+			return nil, qerr(term.pos, "duplicate condition for key %q", term.key)
+		}
+		for k, v := range cmap {
+			if _, ok := pmap[k]; ok && k != "$options" {
+				return nil, qerr(term.pos, "duplicate condition for key %q", term.key)
+			}
+			pmap[k] = v
+		}
+	}
+	return spec, nil
+}
+
+// helper function to split compact query into list of terms
+func parseQuery(query string) ([]queryTerm, error) {
+	var terms []queryTerm
+	qerr := func(idx int, format string, args ...any) error {
+		pos := utf8.RuneCountInString(query[:idx]) + 1
+		return &QueryError{Query: query, Position: pos, Message: fmt.Sprintf(format, args...)}
+	}
+	idx := 0
+	for {
+		for idx < len(query) {
+			r, size := utf8.DecodeRuneInString(query[idx:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			idx += size
+		}
+		if idx >= len(query) {
+			break
+		}
+		// parse key
+		start := idx
+		for idx < len(query) && isKeyChar(query[idx]) {
+			idx++
+		}
+		if idx == start {
+			r, _ := utf8.DecodeRuneInString(query[idx:])
+			return nil, qerr(idx, "expected key, got %q", string(r))
+		}
+		term := queryTerm{key: query[start:idx], keyPos: utf8.RuneCountInString(query[:start]) + 1}
+		// keys (and their parts) starting with $ are MongoDB operators
+		for _, part := range strings.Split(term.key, ".") {
+			if strings.HasPrefix(part, "$") {
+				return nil, qerr(start, "key %q can not start with $", term.key)
+			}
+		}
+
+		// parse operator
+		found := false
+		for _, qop := range queryOperators {
+			if strings.HasPrefix(query[idx:], qop.op) {
+				term.op = qop.mongo
+				idx += len(qop.op)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, qerr(idx, "expected operator after key %q, e.g. %s:value", term.key, term.key)
+		}
+
+		// parse value
+		term.pos = utf8.RuneCountInString(query[:idx]) + 1
+		if idx < len(query) && query[idx] == '"' {
+			quote := idx
+			var buf strings.Builder
+			idx++
+			for idx < len(query) && query[idx] != '"' {
+				if query[idx] == '\\' && idx+1 < len(query) {
+					idx++
+				}
+				buf.WriteByte(query[idx])
+				idx++
+			}
+			if idx >= len(query) {
+				return nil, qerr(quote, "unterminated quoted value for key %q", term.key)
+			}
+			idx++
+			term.value = buf.String()
+			term.quoted = true
+		} else {
+			start = idx
+			for idx < len(query) {
+				r, size := utf8.DecodeRuneInString(query[idx:])
+				if unicode.IsSpace(r) {
+					break
+				}
+				idx += size
+			}
+			term.value = query[start:idx]
+		}
+		if term.value == "" && !term.quoted {
+			return nil, qerr(idx, "missing value for key %q", term.key)
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return nil, &QueryError{Query: query, Position: 1, Message: "empty query"}
+	}
+	return terms, nil
+}
+
+// helper function to check if given character can be used in query key
+func isKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// helper function to normalize QL data type
+func queryDataType(dataType string) string {
+	dtype := strings.ToLower(dataType)
+	switch {
+	case dtype == "struct":
+		return "struct"
+	case strings.Contains(dtype, "list"), strings.HasPrefix(dtype, "[]"):
+		return "list"
+	case strings.Contains(dtype, "int"):
+		return "int"
+	case strings.Contains(dtype, "float"), strings.Contains(dtype, "double"):
+		return "float"
+	case strings.Contains(dtype, "bool"):
+		return "bool"
+	}
+	return "string"
+}
+
+// helper function to convert term value to data type of its QL key
+func termValue(term queryTerm, rec *ql.QLRecord) (any, error) {
+	var dtype, units string
+	if rec != nil {
+		dtype = queryDataType(rec.DataType)
+		units = rec.Units
+	} else if !term.quoted {
+		// without QL record we infer data type from the value itself
+		if _, err := strconv.ParseFloat(term.value, 64); err == nil {
+			dtype = "float"
+		} else if _, err := strconv.ParseBool(term.value); err == nil {
+			dtype = "bool"
+		}
+	}
+	if term.op == "$regex" {
+		if dtype != "" && dtype != "string" && dtype != "list" {
+			return nil, fmt.Errorf("pattern match is not supported for %s key %q", dtype, term.key)
+		}
+		return globPattern(term.value), nil
+	}
+	switch dtype {
+	case "int", "float":
+		num := term.value
+		// strip off units provided along with the value, e.g. 40keV
+		if end := strings.LastIndexAny(num, "0123456789."); end >= 0 && end < len(num)-1 {
+			if suffix := num[end+1:]; units == "" || !strings.EqualFold(suffix, units) {
+				return nil, fmt.Errorf("value %q of key %q has units %q, expected %q", term.value, term.key, suffix, units)
+			}
+			num = num[:end+1]
+		}
+		if dtype == "int" {
+			val, err := strconv.ParseInt(num, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("key %q expects integer value, got %q", term.key, term.value)
+			}
+			return val, nil
+		}
+		val, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, fmt.Errorf("key %q expects numeric value, got %q", term.key, term.value)
+		}
+		return val, nil
+	case "bool":
+		val, err := strconv.ParseBool(term.value)
+		if err != nil {
+			return nil, fmt.Errorf("key %q expects boolean value, got %q", term.key, term.value)
+		}
+		if term.op != "" && term.op != "$ne" {
+			return nil, fmt.Errorf("comparison is not supported for boolean key %q", term.key)
+		}
+		return val, nil
+	}
+	return term.value, nil
+}
+
+// helper function to convert glob pattern, e.g. Ti*, into anchored regular expression
+func globPattern(pattern string) string {
+	pat := regexp.QuoteMeta(pattern)
+	pat = strings.ReplaceAll(pat, `\*`, ".*")
+	pat = strings.ReplaceAll(pat, `\?`, ".")
+	return "^" + pat + "$"
+}
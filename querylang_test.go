@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	ql "github.com/CHESSComputing/golib/ql"
+)
+
+// TestCompileQuery tests compilation of compact query into MongoDB spec
+func TestCompileQuery(t *testing.T) {
+	keys := map[string]ql.QLRecord{
+		"beamline":    {Key: "beamline", DataType: "list_str"},
+		"cycle":       {Key: "cycle", DataType: "string"},
+		"beam_energy": {Key: "beam_energy", DataType: "float64", Units: "keV"},
+		"scan_number": {Key: "scan_number", DataType: "int64"},
+		"in_situ":     {Key: "in_situ", DataType: "bool"},
+		"sample_name": {Key: "sample_name", DataType: "string"},
+	}
+	tests := []struct {
+		name     string
+		query    string
+		keys     map[string]ql.QLRecord
+		expected map[string]any
+		position int // expected error position, zero if no error
+	}{
+		{
+			name:  "Request example",
+			query: `beamline:3a cycle:2024-1 beam_energy>40 sample_name~"Ti*"`,
+			keys:  keys,
+			expected: map[string]any{
+				"beamline":    "3a",
+				"cycle":       "2024-1",
+				"beam_energy": map[string]any{"$gt": 40.0},
+				"sample_name": map[string]any{"$regex": "^Ti.*$", "$options": "i"},
+			},
+		},
+		{
+			name:  "Range, units and types",
+			query: `beam_energy>=40keV beam_energy<50 scan_number!=3 in_situ=true`,
+			keys:  keys,
+			expected: map[string]any{
+				"beam_energy": map[string]any{"$gte": 40.0, "$lt": 50.0},
+				"scan_number": map[string]any{"$ne": int64(3)},
+				"in_situ":     true,
+			},
+		},
+		{
+			name:     "Quoted value",
+			query:    `sample_name:"Ti \"64\" alloy"`,
+			keys:     keys,
+			expected: map[string]any{"sample_name": `Ti "64" alloy`},
+		},
+		{
+			name:     "Without QL records",
+			query:    `foo:1 bar:abc`,
+			keys:     nil,
+			expected: map[string]any{"foo": 1.0, "bar": "abc"},
+		},
+		{
+			name:     "Unicode spaces and values",
+			query:    "sample_name:Tï\u00a0cycle:2024-1\u2003beamline:3a",
+			keys:     keys,
+			expected: map[string]any{"sample_name": "Tï", "cycle": "2024-1", "beamline": "3a"},
+		},
+		{name: "Unknown key", query: `cycle:2024-1 energy>40`, keys: keys, position: 14},
+		{name: "Operator key", query: `$where:1`, keys: nil, position: 1},
+		{name: "Operator key part", query: `cycle:a beam.$gt:1`, keys: nil, position: 9},
+		{name: "Unicode key", query: "cycle:é é:1", keys: keys, position: 9},
+		{name: "Missing operator", query: `beamline 3a`, keys: keys, position: 9},
+		{name: "Wrong units", query: `beam_energy>40eV`, keys: keys, position: 13},
+		{name: "Not integer", query: `scan_number:1.5`, keys: keys, position: 13},
+		{name: "Unterminated quote", query: `sample_name:"Ti`, keys: keys, position: 13},
+		{name: "Duplicate key", query: `cycle:a cycle:b`, keys: keys, position: 15},
+		{name: "Pattern on number", query: `scan_number~1*`, keys: keys, position: 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := compileQuery(tt.query, tt.keys)
+			if tt.position > 0 {
+				var qerr *QueryError
+				if !errors.As(err, &qerr) {
+					t.Fatalf("expected query error, got %v", err)
+				}
+				if qerr.Position != tt.position {
+					t.Errorf("expected error position %d, got %d (%v)", tt.position, qerr.Position, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, spec)
+			}
+		})
+	}
+}
+
+// TestQuerySpec tests that JSON queries are passed as is
+func TestQuerySpec(t *testing.T) {
+	spec, err := querySpec(`{"beamline": "3a", "beam_energy": {"$gt": 40}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"beamline": "3a", "beam_energy": map[string]any{"$gt": 40.0}}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("expected %v, got %v", expected, spec)
+	}
+}
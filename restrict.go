@@ -0,0 +1,358 @@
+package main
+
+// restrict module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The restrict module provides fine-grained tokens. Such tokens carry
+// restriction claims which limit token usage to given BTRs, DID prefix
+// and/or set of API paths. The restrictions are enforced by getUser for
+// every bearer token and applied to query specs sent to FOXDEN services.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrTokenRestricted is returned when token is used outside of its restrictions
+var ErrTokenRestricted = errors.New("token restrictions do not allow this request")
+
+// TokenRestrictions represents restriction claims of fine-grained token
+type TokenRestrictions struct {
+	Btrs      []string `json:"btrs,omitempty"`       // allowed BTRs
+	DidPrefix string   `json:"did_prefix,omitempty"` // allowed DID prefix
+	Paths     []string `json:"paths,omitempty"`      // allowed API paths
+}
+
+// Empty checks if restrictions are not set
+func (r *TokenRestrictions) Empty() bool {
+	return r == nil || (len(r.Btrs) == 0 && r.DidPrefix == "" && len(r.Paths) == 0)
+}
+
+// AllowPath checks if given API path is allowed by restrictions, the path
+// is allowed if it is equal to one of restriction paths or it is within
+// restriction path, e.g. /notes allows /notes and /notes/abc
+func (r *TokenRestrictions) AllowPath(path string) bool {
+	if r.Empty() || len(r.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.Paths {
+		p = strings.TrimSuffix(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowDid checks if given DID is allowed by restrictions
+func (r *TokenRestrictions) AllowDid(did string) bool {
+	if r.Empty() || r.DidPrefix == "" || did == "" {
+		return true
+	}
+	return strings.HasPrefix(did, r.DidPrefix)
+}
+
+// AllowBtr checks if given BTR is allowed by restrictions
+func (r *TokenRestrictions) AllowBtr(btr string) bool {
+	if r.Empty() || len(r.Btrs) == 0 || btr == "" {
+		return true
+	}
+	return utils.InList(btr, r.Btrs)
+}
+
+// RestrictedClaims represents FOXDEN token claims along with restrictions
+type RestrictedClaims struct {
+	authz.Claims
+	Restrictions *TokenRestrictions `json:"restrictions,omitempty"`
+}
+
+// helper function to generate JWT token with optional restrictions, it follows
+// authz.JWTAccessToken and produces tokens which are compatible with it
+func restrictedToken(customClaims authz.CustomClaims, expires int64, restrictions *TokenRestrictions) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("[Frontend.main.restrictedToken] rand.Read error: %w", err)
+	}
+	aud := make([]byte, 16)
+	if _, err := rand.Read(aud); err != nil {
+		return "", fmt.Errorf("[Frontend.main.restrictedToken] rand.Read error: %w", err)
+	}
+	if restrictions.Empty() {
+		restrictions = nil
+	} else if len(restrictions.Btrs) > 0 {
+		// token btrs are limited to restricted ones
+		customClaims.Btrs = restrictions.Btrs
+	}
+	now := time.Now()
+	claims := RestrictedClaims{
+		Claims: authz.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "CHESS Authz server",
+				Subject:   hex.EncodeToString(buf),
+				Audience:  jwt.ClaimStrings{hex.EncodeToString(aud)},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(expires) * time.Second)),
+				IssuedAt:  jwt.NewNumericDate(now),
+			},
+			CustomClaims: customClaims,
+		},
+		Restrictions: restrictions,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(srvConfig.Config.Authz.ClientID))
+	if err != nil {
+		return token, fmt.Errorf("[Frontend.main.restrictedToken] SignedString error: %w", err)
+	}
+	return token, nil
+}
+
+// helper function to extract restrictions from given token
+func tokenRestrictions(token string) (*TokenRestrictions, error) {
+	claims := &RestrictedClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return []byte(srvConfig.Config.Authz.ClientID), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.tokenRestrictions] jwt.ParseWithClaims error: %w", err)
+	}
+	return claims.Restrictions, nil
+}
+
+// helper function to parse restrictions from HTTP request parameters
+func parseRestrictions(c *gin.Context, userBtrs []string) (*TokenRestrictions, error) {
+	r := &TokenRestrictions{DidPrefix: strings.TrimSpace(c.Request.FormValue("did_prefix"))}
+	for _, val := range c.Request.Form["btr"] {
+		for _, btr := range strings.Split(val, ",") {
+			if btr = strings.TrimSpace(btr); btr == "" {
+				continue
+			}
+			if !utils.InList(btr, userBtrs) {
+				return nil, fmt.Errorf("btr %s is not associated with user", btr)
+			}
+			r.Btrs = append(r.Btrs, btr)
+		}
+	}
+	for _, val := range c.Request.Form["path"] {
+		for _, path := range strings.Split(val, ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+			r.Paths = append(r.Paths, path)
+		}
+	}
+	if r.Empty() {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// helper function to check request against token restrictions, on success the
+// restrictions are stored in gin context to be used by restrictSpec
+func checkRestrictions(c *gin.Context, token string) error {
+	r, err := tokenRestrictions(token)
+	if err != nil {
+		return err
+	}
+	if r.Empty() {
+		return nil
+	}
+	path := strings.TrimPrefix(c.Request.URL.Path, srvConfig.Config.Frontend.WebServer.Base)
+	if !r.AllowPath(path) {
+		return fmt.Errorf("%w: path %s", ErrTokenRestricted, path)
+	}
+	did := c.Param("did")
+	if did == "" {
+		did = c.Request.FormValue("did")
+	}
+	if !r.AllowDid(did) {
+		return fmt.Errorf("%w: did %s", ErrTokenRestricted, did)
+	}
+	if btr := c.Request.FormValue("btr"); !r.AllowBtr(btr) {
+		return fmt.Errorf("%w: btr %s", ErrTokenRestricted, btr)
+	}
+	// writes refer to records by DID and we should check their BTRs
+	write := c.Request.Method != http.MethodGet
+	if write && did != "" && len(r.Btrs) > 0 && utils.InList(path, didWritePaths) {
+		if err := checkRecordRestrictions(r, did); err != nil {
+			return err
+		}
+	}
+	c.Set("restrictions", r)
+	return nil
+}
+
+// didWritePaths defines API paths which modify record of given DID
+var didWritePaths = []string{"/notes", "/amendrecord", "/addauxdata", "/publish", "/doipublic"}
+
+// helper function to check that record of given DID is allowed by restrictions
+func checkRecordRestrictions(r *TokenRestrictions, did string) error {
+	spec := r.Spec(map[string]any{"did": did})
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.checkRecordRestrictions] json.Marshal error: %w", err)
+	}
+	rec := services.ServiceRequest{
+		Client:       "frontend",
+		ServiceQuery: services.ServiceQuery{Query: string(query), Spec: spec, Limit: 1},
+	}
+	resp, err := chunkOfRecords(rec)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.checkRecordRestrictions] chunkOfRecords error: %w", err)
+	}
+	if resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+		return fmt.Errorf("[Frontend.main.checkRecordRestrictions] discovery service error: %s", resp.Error)
+	}
+	if len(resp.Results.Records) == 0 {
+		return fmt.Errorf("%w: did %s", ErrTokenRestricted, did)
+	}
+	return nil
+}
+
+// helper function to get token restrictions of current request
+func requestRestrictions(c *gin.Context) *TokenRestrictions {
+	if val, ok := c.Get("restrictions"); ok {
+		if r, ok := val.(*TokenRestrictions); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// helper function to get foxden user whose attributes are limited by token
+// restrictions, during admin impersonation we use impersonated user instead
+func getFoxdenUser(c *gin.Context, user string) (services.User, error) {
+	if target := impersonatedUser(c); target != "" {
+		user = target
+	}
+	fuser, err := _foxdenUser.Get(user)
+	if err != nil {
+		return fuser, err
+	}
+	return requestRestrictions(c).User(fuser), nil
+}
+
+// User limits access attributes of given foxden user by restrictions
+func (r *TokenRestrictions) User(fuser services.User) services.User {
+	if r.Empty() || len(r.Btrs) == 0 {
+		return fuser
+	}
+	var btrs []string
+	for _, btr := range fuser.Btrs {
+		if utils.InList(btr, r.Btrs) {
+			btrs = append(btrs, btr)
+		}
+	}
+	fuser.Btrs = btrs
+	// at MagLab records are accessed via user groups
+	var groups []string
+	for _, grp := range fuser.Groups {
+		if utils.InList(grp, r.Btrs) {
+			groups = append(groups, grp)
+		}
+	}
+	fuser.Groups = groups
+	// restricted token should not grant access to all records
+	fuser.FoxdenGroups = nil
+	return fuser
+}
+
+// Spec narrows given spec with restrictions, the spec is returned as is if
+// restrictions do not limit BTRs or DIDs
+func (r *TokenRestrictions) Spec(spec map[string]any) map[string]any {
+	if r.Empty() {
+		return spec
+	}
+	conds := []map[string]any{}
+	if len(r.Btrs) > 0 {
+		conds = append(conds, map[string]any{"btr": map[string]any{"$in": r.Btrs}})
+	}
+	if r.DidPrefix != "" {
+		pat := "^" + regexp.QuoteMeta(r.DidPrefix)
+		conds = append(conds, map[string]any{"did": map[string]any{"$regex": pat}})
+	}
+	if len(conds) == 0 {
+		return spec
+	}
+	if len(spec) > 0 {
+		conds = append([]map[string]any{spec}, conds...)
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return map[string]any{"$and": conds}
+}
+
+// helper function to narrow given spec with token restrictions of current request
+func restrictSpec(c *gin.Context, spec map[string]any) map[string]any {
+	return requestRestrictions(c).Spec(spec)
+}
+
+// helper function to narrow service request with token restrictions of current request
+func restrictRequest(c *gin.Context, rec *services.ServiceRequest) error {
+	if requestRestrictions(c).Empty() {
+		return nil
+	}
+	spec := rec.ServiceQuery.Spec
+	if spec == nil {
+		spec = make(map[string]any)
+		if query := cleanQuery(rec.ServiceQuery.Query); query != "" {
+			if err := json.Unmarshal([]byte(query), &spec); err != nil {
+				return fmt.Errorf("[Frontend.main.restrictRequest] json.Unmarshal error: %w", err)
+			}
+		}
+	}
+	spec = restrictSpec(c, spec)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.restrictRequest] json.Marshal error: %w", err)
+	}
+	rec.ServiceQuery.Spec = spec
+	rec.ServiceQuery.Query = string(data)
+	return nil
+}
+
+// helper function to generate token map for given user and restrictions
+func tokenMap(rec authz.AuthUser, restrictions *TokenRestrictions) (authz.TokenMap, error) {
+	if restrictions.Empty() {
+		return rec.TokenMap()
+	}
+	if rec.Expires == 0 {
+		rec.Expires = 3600
+	}
+	customClaims := authz.CustomClaims{
+		User:        rec.Name,
+		Scope:       rec.Scope,
+		Kind:        rec.Kind,
+		Application: rec.App,
+		Btrs:        rec.Btrs,
+		Groups:      rec.Groups,
+		Scopes:      rec.Scopes,
+	}
+	token, err := restrictedToken(customClaims, rec.Expires, restrictions)
+	if err != nil {
+		return authz.TokenMap{}, err
+	}
+	tmap := authz.TokenMap{
+		AccessToken: token,
+		Scope:       rec.Scope,
+		Type:        "bearer",
+		Expires:     rec.Expires,
+	}
+	return tmap, nil
+}
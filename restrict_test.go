@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestRestrictedToken tests fine-grained token restrictions
+func TestRestrictedToken(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	srvConfig.Config.Authz.ClientID = "test-client-id"
+	restrictions := &TokenRestrictions{
+		Btrs:      []string{"btr1"},
+		DidPrefix: "/beamline=3a/btr=btr1",
+		Paths:     []string{"/notes"},
+	}
+	claims := authz.CustomClaims{User: "alice", Scope: "write", Btrs: []string{"btr1", "btr2"}}
+	token, err := restrictedToken(claims, 60, restrictions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// restricted token should be valid FOXDEN token
+	tclaims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tclaims.CustomClaims.Btrs, []string{"btr1"}) {
+		t.Errorf("token btrs are not restricted: %v", tclaims.CustomClaims.Btrs)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{name: "Allowed path", method: "GET", path: "/notes", allowed: true},
+		{name: "Allowed sub-path", method: "GET", path: "/notes/abc", allowed: true},
+		{name: "Forbidden path", method: "GET", path: "/search", allowed: false},
+		{name: "Allowed did", method: "GET", path: "/notes?did=/beamline=3a/btr=btr1/cycle=1", allowed: true},
+		{name: "Forbidden did", method: "GET", path: "/notes?did=/beamline=3a/btr=btr2", allowed: false},
+		{name: "Forbidden btr", method: "GET", path: "/notes?btr=btr2", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(tt.method, tt.path, nil)
+			err := checkRestrictions(c, token)
+			if tt.allowed && err != nil {
+				t.Errorf("expected allowed request, got %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("expected forbidden request")
+			}
+		})
+	}
+
+	// spec should be narrowed by restrictions
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/notes", nil)
+	if err := checkRestrictions(c, token); err != nil {
+		t.Fatal(err)
+	}
+	spec := restrictSpec(c, map[string]any{"beamline": "3a"})
+	expected := map[string]any{"$and": []map[string]any{
+		{"beamline": "3a"},
+		{"btr": map[string]any{"$in": []string{"btr1"}}},
+		{"did": map[string]any{"$regex": "^/beamline=3a/btr=btr1"}},
+	}}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+}
+
+// TestRestrictionsSpec tests narrowing of specs by token restrictions
+func TestRestrictionsSpec(t *testing.T) {
+	btrCond := map[string]any{"btr": map[string]any{"$in": []string{"btr1"}}}
+	tests := []struct {
+		name         string
+		restrictions *TokenRestrictions
+		spec         map[string]any
+		expected     map[string]any
+	}{
+		{"no restrictions", nil, map[string]any{"beamline": "3a"}, map[string]any{"beamline": "3a"}},
+		{"paths with empty spec", &TokenRestrictions{Paths: []string{"/search"}}, map[string]any{}, map[string]any{}},
+		{"paths with spec", &TokenRestrictions{Paths: []string{"/search"}}, map[string]any{"beamline": "3a"}, map[string]any{"beamline": "3a"}},
+		{"btrs with empty spec", &TokenRestrictions{Btrs: []string{"btr1"}}, map[string]any{}, btrCond},
+		{"btrs with spec", &TokenRestrictions{Btrs: []string{"btr1"}}, map[string]any{"beamline": "3a"},
+			map[string]any{"$and": []map[string]any{{"beamline": "3a"}, btrCond}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.restrictions.Spec(tt.spec)
+			if !reflect.DeepEqual(spec, tt.expected) {
+				t.Errorf("spec %+v, want %+v", spec, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCheckRecordRestrictions tests that writes to records of other BTRs are rejected
+func TestCheckRecordRestrictions(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	// discovery service stub which knows records of two BTRs
+	btrs := map[string]string{"/beamline=3a/btr=btr1": "btr1", "/beamline=3a/btr=btr2": "btr2"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec services.ServiceRequest
+		json.NewDecoder(r.Body).Decode(&rec)
+		var spec struct {
+			And []struct {
+				Did string `json:"did"`
+				Btr struct {
+					In []string `json:"$in"`
+				} `json:"btr"`
+			} `json:"$and"`
+		}
+		json.Unmarshal([]byte(rec.ServiceQuery.Query), &spec)
+		var records []map[string]any
+		if len(spec.And) == 2 {
+			did := spec.And[0].Did
+			if btr, ok := btrs[did]; ok && reflect.DeepEqual(spec.And[1].Btr.In, []string{btr}) {
+				records = append(records, map[string]any{"did": did, "btr": btr})
+			}
+		}
+		json.NewEncoder(w).Encode(services.ServiceResponse{Results: services.ServiceResults{Records: records}})
+	}))
+	defer srv.Close()
+
+	srvServices := srvConfig.Config.Services
+	httpRequest := _httpReadRequest
+	defer func() {
+		srvConfig.Config.Services = srvServices
+		_httpReadRequest = httpRequest
+	}()
+	srvConfig.Config.Services.DiscoveryURL = srv.URL
+	srvConfig.Config.Authz.ClientID = "test-client-id"
+	_httpReadRequest = &services.HttpRequest{Token: "token", Expires: time.Now().Add(time.Hour)}
+
+	claims := authz.CustomClaims{User: "alice", Scope: "write", Btrs: []string{"btr1", "btr2"}}
+	token, err := restrictedToken(claims, 60, &TokenRestrictions{Btrs: []string{"btr1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		allowed bool
+	}{
+		{"allowed record", "/notes?did=/beamline=3a/btr=btr1", true},
+		{"record of other btr", "/notes?did=/beamline=3a/btr=btr2", false},
+		{"unknown record", "/amendrecord?did=/beamline=3a/btr=btr3", false},
+		{"path without record check", "/record?did=/beamline=3a/btr=btr2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", tt.path, nil)
+			err := checkRestrictions(c, token)
+			if tt.allowed && err != nil {
+				t.Errorf("expected allowed request, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrTokenRestricted) {
+				t.Errorf("expected restricted request, got %v", err)
+			}
+		})
+	}
+}
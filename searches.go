@@ -0,0 +1,467 @@
+package main
+
+// searches module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The searches module keeps named search queries of users. Saved search
+// can be shared with members of BTR group and re-run from /searches page.
+// Saved search with watch flag is periodically re-run by the frontend and
+// new DIDs matching its query are reported on /searches page, in frontend
+// log and optionally via Frontend.SearchWebhook URL.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrSearchNotFound is returned when saved search is not found
+var ErrSearchNotFound = errors.New("saved search not found")
+
+// searchKnownDidsFactor defines how many watcher runs worth of DIDs are kept
+// as known DIDs of saved search
+const searchKnownDidsFactor = 10
+
+// SavedSearch represents named search query of the user
+type SavedSearch struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	User      string    `json:"user"`
+	Query     string    `json:"query"`
+	Btr       string    `json:"btr,omitempty"` // BTR group search is shared with
+	Watch     bool      `json:"watch"`
+	Created   time.Time `json:"created"`
+	LastRun   time.Time `json:"last_run"`
+	KnownDids []string  `json:"known_dids,omitempty"` // DIDs seen by the watcher
+	NewDids   []string  `json:"new_dids,omitempty"`   // DIDs not yet seen by the user
+
+	// restrictions of token used to save the search, they apply to watcher runs
+	Restrictions *TokenRestrictions `json:"restrictions,omitempty"`
+}
+
+// SearchStore represents persistent store of saved searches
+type SearchStore struct {
+	store    *JSONStore
+	searches map[string]*SavedSearch
+	mu       sync.Mutex
+}
+
+// _searches holds saved searches of all users
+var _searches = &SearchStore{searches: make(map[string]*SavedSearch)}
+
+// helper function to initialize saved searches from persistent storage
+func initSearches() {
+	_searches.store = NewJSONStore("searches.json")
+	if err := _searches.Load(); err != nil {
+		log.Println("ERROR: unable to load saved searches", err)
+	}
+	if _config.SearchWatchInterval > 0 {
+		go _searches.Watch(time.Duration(_config.SearchWatchInterval) * time.Second)
+	}
+}
+
+// Load loads saved searches from persistent store
+func (s *SearchStore) Load() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*SavedSearch
+	if err := s.store.Load(&records); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range records {
+		s.searches[rec.ID] = rec
+	}
+	return nil
+}
+
+// helper function to persist saved searches, it should be called with acquired lock
+func (s *SearchStore) save() error {
+	if s.store == nil {
+		return nil
+	}
+	var records []*SavedSearch
+	for _, rec := range s.searches {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return s.store.Save(records)
+}
+
+// Add adds new saved search
+func (s *SearchStore) Add(rec SavedSearch) (SavedSearch, error) {
+	rec.ID = randomString()
+	rec.Created = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches[rec.ID] = &rec
+	return rec, s.save()
+}
+
+// Get returns saved search with given id visible to given user
+func (s *SearchStore) Get(user string, btrs []string, id string) (SavedSearch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.searches[id]
+	if !ok || !rec.visible(user, btrs) {
+		return SavedSearch{}, ErrSearchNotFound
+	}
+	return rec.view(user), nil
+}
+
+// helper function to check if saved search is visible to given user
+func (r *SavedSearch) visible(user string, btrs []string) bool {
+	return r.User == user || (r.Btr != "" && utils.InList(r.Btr, btrs))
+}
+
+// helper function to get copy of saved search for given user, watcher DIDs
+// are only shown to the owner of the search
+func (r *SavedSearch) view(user string) SavedSearch {
+	rec := *r
+	if rec.User != user {
+		rec.KnownDids = nil
+		rec.NewDids = nil
+		rec.Restrictions = nil
+	}
+	return rec
+}
+
+// Searches returns saved searches of the user and ones shared with user BTRs
+func (s *SearchStore) Searches(user string, btrs []string) []SavedSearch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []SavedSearch
+	for _, rec := range s.searches {
+		if rec.visible(user, btrs) {
+			records = append(records, rec.view(user))
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// Delete deletes saved search of the user
+func (s *SearchStore) Delete(user, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.searches[id]
+	if !ok || rec.User != user {
+		return ErrSearchNotFound
+	}
+	delete(s.searches, id)
+	return s.save()
+}
+
+// Seen marks new DIDs of saved search as seen by its owner
+func (s *SearchStore) Seen(user, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.searches[id]
+	if !ok {
+		return ErrSearchNotFound
+	}
+	if rec.User != user || len(rec.NewDids) == 0 {
+		return nil
+	}
+	rec.NewDids = nil
+	return s.save()
+}
+
+// Update updates saved search with DIDs found by the watcher and returns new ones,
+// on first run all found DIDs are considered as known ones
+func (s *SearchStore) Update(id string, dids []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.searches[id]
+	if !ok {
+		return nil, ErrSearchNotFound
+	}
+	known := make(map[string]bool, len(rec.KnownDids))
+	for _, did := range rec.KnownDids {
+		known[did] = true
+	}
+	var newDids, found []string
+	current := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		if current[did] {
+			continue
+		}
+		current[did] = true
+		found = append(found, did)
+		if !known[did] {
+			newDids = append(newDids, did)
+		}
+	}
+	if rec.LastRun.IsZero() {
+		// on first run all found DIDs are known ones
+		newDids = nil
+	}
+	rec.NewDids = append(rec.NewDids, newDids...)
+	// DIDs found by this run are placed at the end of known DIDs and only most
+	// recent known DIDs are kept, the watcher gets at most searchWatchLimit
+	// DIDs per run and therefore DIDs it still finds are never dropped
+	var knownDids []string
+	for _, did := range rec.KnownDids {
+		if !current[did] {
+			knownDids = append(knownDids, did)
+		}
+	}
+	rec.KnownDids = append(knownDids, found...)
+	maxDids := searchKnownDidsFactor * searchWatchLimit()
+	if len(rec.KnownDids) > maxDids {
+		rec.KnownDids = append([]string{}, rec.KnownDids[len(rec.KnownDids)-maxDids:]...)
+	}
+	if len(rec.NewDids) > maxDids {
+		rec.NewDids = append([]string{}, rec.NewDids[len(rec.NewDids)-maxDids:]...)
+	}
+	rec.LastRun = time.Now()
+	return newDids, s.save()
+}
+
+// helper function to get maximum number of DIDs watcher gets per saved search
+func searchWatchLimit() int {
+	if _config.SearchWatchLimit > 0 {
+		return _config.SearchWatchLimit
+	}
+	return 1000
+}
+
+// helper function to return list of watched searches
+func (s *SearchStore) watched() []SavedSearch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []SavedSearch
+	for _, rec := range s.searches {
+		if rec.Watch {
+			records = append(records, *rec)
+		}
+	}
+	return records
+}
+
+// Watch periodically re-runs watched searches and reports new DIDs
+func (s *SearchStore) Watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		for _, rec := range s.watched() {
+			dids, err := searchDids(rec)
+			if err != nil {
+				log.Printf("ERROR: unable to run saved search %s of user %s, error %v", rec.Name, rec.User, err)
+				continue
+			}
+			newDids, err := s.Update(rec.ID, dids)
+			if err != nil {
+				log.Printf("ERROR: unable to update saved search %s, error %v", rec.ID, err)
+				continue
+			}
+			if len(newDids) > 0 {
+				notifySearch(rec, newDids)
+			}
+		}
+	}
+}
+
+// helper function to run saved search on behalf of its owner and return matched DIDs
+func searchDids(rec SavedSearch) ([]string, error) {
+	limit := searchWatchLimit()
+	spec, err := querySpec(rec.Query)
+	if err != nil {
+		return nil, err
+	}
+	// apply the same user and token restrictions as SearchHandler does
+	spec = rec.Restrictions.Spec(spec)
+	if srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
+		fuser, err := _foxdenUser.Get(rec.User)
+		if err != nil {
+			return nil, fmt.Errorf("[Frontend.main.searchDids] _foxdenUser.Get error: %w", err)
+		}
+		fuser = rec.Restrictions.User(fuser)
+		if !hasAccess(fuser) {
+			return nil, fmt.Errorf("[Frontend.main.searchDids] user %s is not associated with any BTRs", rec.User)
+		}
+		spec = updateSpec(spec, fuser, "search")
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.searchDids] json.Marshal error: %w", err)
+	}
+	req := services.ServiceRequest{
+		Client: "frontend",
+		ServiceQuery: services.ServiceQuery{
+			Query:     string(query),
+			Spec:      spec,
+			SortKeys:  []string{"date"},
+			SortOrder: -1,
+			Limit:     limit,
+		},
+	}
+	resp, err := chunkOfRecords(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+		return nil, fmt.Errorf("[Frontend.main.searchDids] discovery service error: %s", resp.Error)
+	}
+	var dids []string
+	for _, r := range resp.Results.Records {
+		if did := recValue(r, "did"); did != "" {
+			dids = append(dids, did)
+		}
+	}
+	return dids, nil
+}
+
+// SearchNotification represents notification about new DIDs of saved search
+type SearchNotification struct {
+	Search string   `json:"search"`
+	User   string   `json:"user"`
+	Btr    string   `json:"btr,omitempty"`
+	Query  string   `json:"query"`
+	Dids   []string `json:"dids"`
+}
+
+// helper function to notify about new DIDs matching saved search
+func notifySearch(rec SavedSearch, dids []string) {
+	log.Printf("saved search %s of user %s matched %d new DIDs: %v", rec.Name, rec.User, len(dids), dids)
+	if _config.SearchWebhook == "" {
+		return
+	}
+	msg := SearchNotification{Search: rec.Name, User: rec.User, Btr: rec.Btr, Query: rec.Query, Dids: dids}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("ERROR: unable to marshal search notification", err)
+		return
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(_config.SearchWebhook, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		log.Println("ERROR: unable to send search notification", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("ERROR: search notification webhook response %s", resp.Status)
+	}
+}
+
+// helper function to obtain user btrs used for sharing saved searches
+func userBtrs(c *gin.Context, user string) []string {
+	fuser, err := getFoxdenUser(c, user)
+	if err != nil {
+		return nil
+	}
+	return fuser.Btrs
+}
+
+// SearchesHandler provides access to GET /searches endpoint
+func SearchesHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	records := _searches.Searches(user, userBtrs(c, user))
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, records)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Searches")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["User"] = user
+	tmpl["Searches"] = records
+	tmpl["Btrs"] = userBtrs(c, user)
+	tmpl["Query"] = c.Query("query")
+	tmpl["Watch"] = _config.SearchWatchInterval > 0
+	content := server.TmplPage(StaticFs, "searches.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
+
+// SearchSaveHandler provides access to POST /searches endpoint
+func SearchSaveHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	rec := SavedSearch{
+		User:  user,
+		Name:  c.Request.FormValue("name"),
+		Query: c.Request.FormValue("query"),
+		Btr:   c.Request.FormValue("btr"),
+
+		Restrictions: requestRestrictions(c),
+	}
+	_, rec.Watch = c.GetPostForm("watch")
+	if rec.Name == "" || rec.Query == "" {
+		handleError(c, http.StatusBadRequest, "saved search requires name and query", errors.New("empty parameters"))
+		return
+	}
+	if _, err := querySpec(rec.Query); err != nil {
+		handleError(c, http.StatusBadRequest, "malformed search query", err)
+		return
+	}
+	if rec.Btr != "" && !utils.InList(rec.Btr, userBtrs(c, user)) {
+		msg := fmt.Sprintf("user %s does not belong to BTR %s", user, rec.Btr)
+		handleError(c, http.StatusBadRequest, msg, errors.New("unauthorized action"))
+		return
+	}
+	rec, err := _searches.Add(rec)
+	if err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to save search", err)
+		return
+	}
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, rec)
+		return
+	}
+	c.Redirect(http.StatusFound, base("/searches"))
+}
+
+// SearchRunHandler provides access to GET /searches/run endpoint
+func SearchRunHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	id := c.Query("id")
+	rec, err := _searches.Get(user, userBtrs(c, user), id)
+	if err != nil {
+		handleError(c, http.StatusNotFound, "unable to find saved search", err)
+		return
+	}
+	if err := _searches.Seen(user, id); err != nil {
+		log.Println("ERROR: unable to update saved search", err)
+	}
+	c.Redirect(http.StatusFound, base("/search")+"?query="+url.QueryEscape(rec.Query))
+}
+
+// SearchDeleteHandler provides access to POST /searches/delete and DELETE /searches/:id endpoints
+func SearchDeleteHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	id := c.Param("id")
+	if id == "" {
+		id = c.Request.FormValue("id")
+	}
+	if err := _searches.Delete(user, id); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSearchNotFound) {
+			code = http.StatusNotFound
+		}
+		handleError(c, code, "unable to delete saved search", err)
+		return
+	}
+	if c.Request.Method == "DELETE" || c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id})
+		return
+	}
+	c.Redirect(http.StatusFound, base("/searches"))
+}
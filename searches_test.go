@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// TestSearchStore tests saved searches APIs
+func TestSearchStore(t *testing.T) {
+	store := &SearchStore{searches: make(map[string]*SavedSearch)}
+	private, _ := store.Add(SavedSearch{Name: "private", User: "alice", Query: "{}"})
+	shared, _ := store.Add(SavedSearch{Name: "shared", User: "alice", Query: "{}", Btr: "btr1", Watch: true})
+
+	tests := []struct {
+		name     string
+		user     string
+		btrs     []string
+		expected []string
+	}{
+		{name: "Owner", user: "alice", btrs: nil, expected: []string{"private", "shared"}},
+		{name: "BTR member", user: "bob", btrs: []string{"btr1"}, expected: []string{"shared"}},
+		{name: "Other user", user: "carol", btrs: []string{"btr2"}, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, rec := range store.Searches(tt.user, tt.btrs) {
+				names = append(names, rec.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+
+	// only owner can delete saved search
+	if err := store.Delete("bob", private.ID); !errors.Is(err, ErrSearchNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	// first watcher run only records known DIDs
+	if dids, _ := store.Update(shared.ID, []string{"/did1", "/did2"}); len(dids) != 0 {
+		t.Errorf("unexpected new dids on first run %v", dids)
+	}
+	dids, _ := store.Update(shared.ID, []string{"/did1", "/did2", "/did3"})
+	if !reflect.DeepEqual(dids, []string{"/did3"}) {
+		t.Errorf("unexpected new dids %v", dids)
+	}
+	// watcher DIDs are not shown to other members of BTR
+	if rec, _ := store.Get("bob", []string{"btr1"}, shared.ID); len(rec.KnownDids) != 0 || len(rec.NewDids) != 0 {
+		t.Errorf("watcher dids are visible to other user %+v", rec)
+	}
+	for _, rec := range store.Searches("bob", []string{"btr1"}) {
+		if len(rec.KnownDids) != 0 || len(rec.NewDids) != 0 {
+			t.Errorf("watcher dids are visible to other user %+v", rec)
+		}
+	}
+	if rec, _ := store.Get("alice", nil, shared.ID); !reflect.DeepEqual(rec.NewDids, []string{"/did3"}) {
+		t.Errorf("owner should see new dids, got %v", rec.NewDids)
+	}
+	store.Seen("alice", shared.ID)
+	if rec, _ := store.Get("alice", nil, shared.ID); len(rec.NewDids) != 0 {
+		t.Errorf("new dids should be seen %v", rec.NewDids)
+	}
+}
+
+// TestSearchStoreKnownDids tests that known DIDs of saved search are capped
+func TestSearchStoreKnownDids(t *testing.T) {
+	limit := _config.SearchWatchLimit
+	defer func() { _config.SearchWatchLimit = limit }()
+	_config.SearchWatchLimit = 2
+	maxDids := searchKnownDidsFactor * 2
+
+	store := &SearchStore{searches: make(map[string]*SavedSearch)}
+	rec, _ := store.Add(SavedSearch{Name: "watch", User: "alice", Query: "{}", Watch: true})
+	store.Update(rec.ID, []string{"/did0"})
+	for i := 1; i <= maxDids+5; i++ {
+		did := fmt.Sprintf("/did%d", i)
+		dids, _ := store.Update(rec.ID, []string{did, did, "/did0"})
+		if !reflect.DeepEqual(dids, []string{did}) {
+			t.Fatalf("unexpected new dids %v", dids)
+		}
+	}
+	rec, _ = store.Get("alice", nil, rec.ID)
+	if len(rec.KnownDids) != maxDids || len(rec.NewDids) != maxDids {
+		t.Errorf("expected %d known and new dids, got %d and %d", maxDids, len(rec.KnownDids), len(rec.NewDids))
+	}
+	// dids found by the last run are kept
+	last := rec.KnownDids[len(rec.KnownDids)-2:]
+	if expected := []string{fmt.Sprintf("/did%d", maxDids+5), "/did0"}; !reflect.DeepEqual(last, expected) {
+		t.Errorf("expected %v at the end of known dids, got %v", expected, last)
+	}
+}
+
+// TestSearchDids tests that watcher runs saved searches with owner access restrictions
+func TestSearchDids(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec services.ServiceRequest
+		json.NewDecoder(r.Body).Decode(&rec)
+		query = rec.ServiceQuery.Query
+		records := []map[string]any{{"did": "/beamline=3a/btr=btr1"}}
+		json.NewEncoder(w).Encode(services.ServiceResponse{Results: services.ServiceResults{Records: records}})
+	}))
+	defer srv.Close()
+
+	frontend := srvConfig.Config.Frontend
+	srvServices := srvConfig.Config.Services
+	embed := srvConfig.Config.Embed
+	httpRequest, foxdenUser := _httpReadRequest, _foxdenUser
+	defer func() {
+		srvConfig.Config.Frontend = frontend
+		srvConfig.Config.Services = srvServices
+		srvConfig.Config.Embed = embed
+		_httpReadRequest, _foxdenUser = httpRequest, foxdenUser
+	}()
+	srvConfig.Config.Frontend.CheckBtrs = true
+	srvConfig.Config.Embed.DocDb = ""
+	srvConfig.Config.Services.DiscoveryURL = srv.URL
+	_httpReadRequest = &services.HttpRequest{Token: "token", Expires: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name         string
+		btrs         []string
+		restrictions *TokenRestrictions
+		query        string
+		fail         bool
+	}{
+		{name: "User without BTRs", fail: true},
+		{name: "User BTRs", btrs: []string{"btr1", "btr2"},
+			query: `{"beamline":"3a","btr":{"$in":["btr1","btr2"]}}`},
+		{name: "Restricted search", btrs: []string{"btr1", "btr2"},
+			restrictions: &TokenRestrictions{Btrs: []string{"btr1"}},
+			query:        `{"$and":[{"beamline":"3a"},{"btr":{"$in":["btr1"]}}],"btr":{"$in":["btr1"]}}`},
+		{name: "Restrictions outside of user BTRs", btrs: []string{"btr2"},
+			restrictions: &TokenRestrictions{Btrs: []string{"btr1"}}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query = ""
+			_foxdenUser = &foxdenUserStub{user: services.User{Name: "alice", Btrs: tt.btrs}}
+			rec := SavedSearch{User: "alice", Query: `{"beamline":"3a"}`, Restrictions: tt.restrictions}
+			dids, err := searchDids(rec)
+			if tt.fail {
+				if err == nil || query != "" {
+					t.Errorf("search is run with query %s, error %v", query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.query {
+				t.Errorf("query %s, want %s", query, tt.query)
+			}
+			if !reflect.DeepEqual(dids, []string{"/beamline=3a/btr=btr1"}) {
+				t.Errorf("unexpected dids %v", dids)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"log"
 
 	beamlines "github.com/CHESSComputing/golib/beamlines"
@@ -65,13 +66,29 @@ func setupRouter() *gin.Engine {
 		{Method: "GET", Path: "/docs/:page", Handler: DocsHandler, Authorized: false},
 		{Method: "GET", Path: "/login", Handler: LoginHandler, Authorized: false},
 		{Method: "GET", Path: "/logout", Handler: LogoutHandler, Authorized: false},
+		{Method: "GET", Path: "/kerberos/login", Handler: SPNEGOLoginHandler, Authorized: false},
+		{Method: "GET", Path: "/login/locks", Handler: LoginLocksHandler, Authorized: false},
+		{Method: "GET", Path: "/audit", Handler: AuditHandler, Authorized: false},
+		{Method: "GET", Path: "/cache/stats", Handler: CacheStatsHandler, Authorized: false},
+		{Method: "GET", Path: "/impersonate", Handler: ImpersonateFormHandler, Authorized: false},
+		{Method: "GET", Path: "/searches", Handler: SearchesHandler, Authorized: false},
+		{Method: "GET", Path: "/searches/run", Handler: SearchRunHandler, Authorized: false},
+		{Method: "GET", Path: "/collections", Handler: CollectionsHandler, Authorized: false},
+		{Method: "GET", Path: "/preferences", Handler: PreferencesHandler, Authorized: false},
+		{Method: "GET", Path: "/export", Handler: ExportHandler, Authorized: false},
+		{Method: "GET", Path: "/facets", Handler: FacetsHandler, Authorized: false},
+		{Method: "GET", Path: "/stats", Handler: StatsHandler, Authorized: false},
+		{Method: "GET", Path: "/stats/:name", Handler: StatsChartHandler, Authorized: false},
 		{Method: "GET", Path: "/services", Handler: ServicesHandler, Authorized: false},
 		{Method: "GET", Path: "/search", Handler: SearchHandler, Authorized: false},
+		{Method: "GET", Path: "/search/suggest", Handler: SuggestHandler, Authorized: false},
 		{Method: "GET", Path: "/advancedsearch", Handler: AdvancedSearchHandler, Authorized: false},
 		{Method: "GET", Path: "/schemas", Handler: SchemasHandler, Authorized: false},
 		{Method: "GET", Path: "/record", Handler: RecordHandler, Authorized: false},
+		{Method: "GET", Path: "/compare", Handler: CompareHandler, Authorized: false},
 		{Method: "GET", Path: "/tools", Handler: ToolsHandler, Authorized: false},
 		{Method: "GET", Path: "/token", Handler: TokenHandler, Authorized: false},
+		{Method: "GET", Path: "/tokens", Handler: TokensHandler, Authorized: false},
 		{Method: "GET", Path: "/users", Handler: UsersHandler, Authorized: false},
 		{Method: "GET", Path: "/meta", Handler: MetaDataHandler, Authorized: false},
 		{Method: "GET", Path: "/dids", Handler: DidsHandler, Authorized: false},
@@ -99,7 +116,17 @@ func setupRouter() *gin.Engine {
 		{Method: "GET", Path: "/tmpl/records", Handler: TmplRecordsFormHandler, Authorized: false},
 		{Method: "GET", Path: "/graph", Handler: RecordsGraphHandler, Authorized: false},
 		{Method: "DELETE", Path: "/sync/delete/:uuid", Handler: SyncDeleteHandler, Authorized: false},
+		{Method: "DELETE", Path: "/tokens/:id", Handler: TokenRevokeHandler, Authorized: false},
+		{Method: "POST", Path: "/tokens/revoke", Handler: TokenRevokeHandler, Authorized: false},
+		{Method: "POST", Path: "/login/unlock", Handler: LoginUnlockHandler, Authorized: false},
+		{Method: "POST", Path: "/impersonate", Handler: ImpersonateHandler, Authorized: false},
+		{Method: "POST", Path: "/impersonate/stop", Handler: ImpersonateStopHandler, Authorized: false},
+		{Method: "POST", Path: "/searches", Handler: SearchSaveHandler, Authorized: false},
+		{Method: "POST", Path: "/searches/delete", Handler: SearchDeleteHandler, Authorized: false},
+		{Method: "DELETE", Path: "/searches/:id", Handler: SearchDeleteHandler, Authorized: false},
 		{Method: "POST", Path: "/notes", Handler: NotesHandler, Authorized: false},
+		{Method: "POST", Path: "/bulk", Handler: BulkHandler, Authorized: false},
+		{Method: "POST", Path: "/preferences", Handler: PreferencesSaveHandler, Authorized: false},
 		{Method: "POST", Path: "/sync", Handler: SyncFormHandler, Authorized: false},
 		{Method: "POST", Path: "/amendrecord", Handler: AmendRecordHandler, Authorized: false},
 		{Method: "POST", Path: "/addauxdata", Handler: AddAuxDataHandler, Authorized: false},
@@ -123,7 +150,14 @@ func setupRouter() *gin.Engine {
 		{Method: "POST", Path: "/tmpl/update", Handler: TmplRecordUpdateHandler, Authorized: false},
 		{Method: "POST", Path: "/tmpl/delete", Handler: TmplRecordDeleteHandler, Authorized: false},
 	}
+	// attach route authorization policy to all routes
+	routes = policyRoutes(routes)
 	r := server.Router(routes, StaticFs, "static", srvConfig.Config.Frontend.WebServer)
+	// client IP is used by login throttle and audit log, therefore we only
+	// accept forwarded headers from configured proxies
+	if err := r.SetTrustedProxies(_config.TrustedProxies); err != nil {
+		log.Fatalf("unable to set trusted proxies %v, error %v", _config.TrustedProxies, err)
+	}
 
 	// OAuth routes
 	for _, arec := range srvConfig.Config.Frontend.OAuth {
@@ -141,6 +175,12 @@ func setupRouter() *gin.Engine {
 			log.Println("facebook oauth is enabled")
 		}
 	}
+	// generic OIDC routes
+	for _, p := range _oidcProviders {
+		r.GET(fmt.Sprintf("/%s/login", p.Record.Provider), p.LoginHandler)
+		r.GET(fmt.Sprintf("/%s/callback", p.Record.Provider), p.CallBackHandler)
+		log.Printf("%s oidc is enabled", p.Record.Provider)
+	}
 	return r
 }
 
@@ -189,6 +229,40 @@ func Server() {
 	}
 	_foxdenUser.Init()
 
+	// initialize user sessions
+	initSessions()
+
+	// read frontend specific configuration and initialize OIDC providers
+	initConfig()
+	initOIDCProviders()
+	initSPNEGO()
+	initLoginThrottle()
+
+	// load route authorization policy
+	initPolicy()
+
+	// load service map used by federated search
+	initServiceMap()
+
+	// initialize registry of issued tokens
+	initTokenRegistry()
+
+	// initialize audit log of write actions
+	initAuditLog()
+
+	// initialize saved searches and their watcher
+	initSearches()
+
+	// initialize collections of DIDs used by bulk actions
+	initCollections()
+
+	// initialize UI preferences of users
+	initPreferences()
+
+	// initialize caches of upstream calls, FOXDEN user attributes are cached too
+	initCaches()
+	_foxdenUser = &cachedUserAttributes{UserAttributes: _foxdenUser}
+
 	// acquire all foxden attributes across FOXDEN schemas
 	_foxdenAttrs = foxdenAttrs()
 
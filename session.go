@@ -0,0 +1,340 @@
+package main
+
+// session module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The session module replaces the plain "user" cookie with opaque
+// session identifiers signed by HMAC and kept in a server side store.
+// Each session has an idle and an absolute expiration, it is rotated
+// on every login and revoked on logout.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionCookieName defines name of cookie which holds FOXDEN session
+const SessionCookieName = "foxden_session"
+
+// default session expiration parameters
+const (
+	SessionIdleTimeout     = 2 * time.Hour
+	SessionAbsoluteTimeout = 24 * time.Hour
+)
+
+// errors returned by session store
+var (
+	ErrNoSession      = errors.New("no session")
+	ErrInvalidSession = errors.New("invalid session signature")
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Session represents user session
+type Session struct {
+	ID          string    `json:"-"`
+	User        string    `json:"user"`
+	Created     time.Time `json:"created"`
+	LastSeen    time.Time `json:"last_seen"`
+	Impersonate string    `json:"impersonate,omitempty"` // user impersonated by admin
+}
+
+// expired checks if session is expired with respect to given time
+func (s *Session) expired(now time.Time, idle, absolute time.Duration) bool {
+	if now.Sub(s.LastSeen) > idle {
+		return true
+	}
+	return now.Sub(s.Created) > absolute
+}
+
+// SessionStore represents server side session store
+type SessionStore struct {
+	Idle     time.Duration
+	Absolute time.Duration
+	secret   []byte
+	sessions map[string]*Session
+	mu       sync.Mutex
+}
+
+// NewSessionStore creates new session store with given expiration parameters
+func NewSessionStore(idle, absolute time.Duration) *SessionStore {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("unable to initialize session secret", err)
+	}
+	return &SessionStore{
+		Idle:     idle,
+		Absolute: absolute,
+		secret:   secret,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// helper function to sign given session id
+func (s *SessionStore) sign(sid string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(sid))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// helper function to verify cookie value and return session id
+func (s *SessionStore) verify(value string) (string, error) {
+	arr := strings.Split(value, ".")
+	if len(arr) != 2 {
+		return "", ErrInvalidSession
+	}
+	if !hmac.Equal([]byte(s.sign(arr[0])), []byte(arr[1])) {
+		return "", ErrInvalidSession
+	}
+	return arr[0], nil
+}
+
+// Create creates new session for given user and returns signed cookie value
+func (s *SessionStore) Create(user string) (string, *Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("[Frontend.main.SessionStore.Create] rand.Read error: %w", err)
+	}
+	sid := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+	sess := &Session{ID: sid, User: user, Created: now, LastSeen: now}
+	s.mu.Lock()
+	s.sessions[sid] = sess
+	s.mu.Unlock()
+	return sid + "." + s.sign(sid), sess, nil
+}
+
+// Get returns session for given cookie value and updates its last seen time
+func (s *SessionStore) Get(value string) (*Session, error) {
+	sid, err := s.verify(value)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	if sess.expired(now, s.Idle, s.Absolute) {
+		delete(s.sessions, sid)
+		return nil, ErrSessionExpired
+	}
+	sess.LastSeen = now
+	return sess, nil
+}
+
+// Impersonate sets user impersonated within session associated with given
+// cookie value, empty user stops impersonation
+func (s *SessionStore) Impersonate(value, user string) error {
+	sid, err := s.verify(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return ErrNoSession
+	}
+	sess.Impersonate = user
+	return nil
+}
+
+// Impersonation returns user impersonated within session associated with given cookie value
+func (s *SessionStore) Impersonation(value string) string {
+	sid, err := s.verify(value)
+	if err != nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[sid]; ok {
+		return sess.Impersonate
+	}
+	return ""
+}
+
+// Revoke removes session associated with given cookie value
+func (s *SessionStore) Revoke(value string) {
+	sid, err := s.verify(value)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.sessions, sid)
+	s.mu.Unlock()
+}
+
+// Cleanup removes all expired sessions from the store
+func (s *SessionStore) Cleanup() int {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int
+	for sid, sess := range s.sessions {
+		if sess.expired(now, s.Idle, s.Absolute) {
+			delete(s.sessions, sid)
+			count++
+		}
+	}
+	return count
+}
+
+// Monitor periodically cleans up expired sessions
+func (s *SessionStore) Monitor(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if n := s.Cleanup(); n > 0 && Verbose > 0 {
+			log.Printf("session store removed %d expired sessions", n)
+		}
+	}
+}
+
+// _sessions holds all frontend sessions
+var _sessions = NewSessionStore(SessionIdleTimeout, SessionAbsoluteTimeout)
+
+// helper function to initialize session store from frontend configuration
+func initSessions() {
+	if sec := srvConfig.Config.Frontend.UserCookieExpires; sec > 0 {
+		_sessions.Absolute = time.Duration(sec) * time.Second
+	}
+	if _sessions.Idle > _sessions.Absolute {
+		_sessions.Idle = _sessions.Absolute
+	}
+	go _sessions.Monitor(10 * time.Minute)
+}
+
+// helper function to write session cookie
+func setSessionCookie(w http.ResponseWriter, value string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Domain:   utils.Domain(),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// helper function to start new session for given user, any existing session
+// presented by the client is revoked, i.e. session is rotated on every login
+func startSession(c *gin.Context, user string) error {
+	if value, err := c.Cookie(SessionCookieName); err == nil {
+		_sessions.Revoke(value)
+	}
+	value, _, err := _sessions.Create(user)
+	if err != nil {
+		return err
+	}
+	c.Set("user", user)
+	setSessionCookie(c.Writer, value, int(_sessions.Absolute.Seconds()))
+	if Verbose > 0 {
+		log.Printf("start new session for user %s", user)
+	}
+	return nil
+}
+
+// helper function to end user session
+func endSession(c *gin.Context) {
+	if value, err := c.Cookie(SessionCookieName); err == nil {
+		_sessions.Revoke(value)
+	}
+	setSessionCookie(c.Writer, "", -1)
+}
+
+// helper function to get session user from gin context
+func sessionUser(c *gin.Context) (string, error) {
+	value, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		return "", fmt.Errorf("[Frontend.main.sessionUser] error: %w", ErrNoSession)
+	}
+	sess, err := _sessions.Get(value)
+	if err != nil {
+		return "", fmt.Errorf("[Frontend.main.sessionUser] error: %w", err)
+	}
+	return sess.User, nil
+}
+
+// sessionWriter wraps gin response writer to replace plain "user" cookie
+// set by OAuth callbacks with proper FOXDEN session
+type sessionWriter struct {
+	gin.ResponseWriter
+	ctx  *gin.Context
+	done bool
+}
+
+// helper function to swap user cookie with session cookie before headers are sent
+func (w *sessionWriter) swap() {
+	if w.done {
+		return
+	}
+	w.done = true
+	header := w.ResponseWriter.Header()
+	var cookies []string
+	for _, val := range header.Values("Set-Cookie") {
+		if !strings.HasPrefix(val, "user=") {
+			cookies = append(cookies, val)
+		}
+	}
+	header.Del("Set-Cookie")
+	for _, val := range cookies {
+		header.Add("Set-Cookie", val)
+	}
+	if user := w.ctx.GetString("user"); user != "" {
+		if err := startSession(w.ctx, user); err != nil {
+			log.Println("ERROR: unable to start session", err)
+		}
+	}
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (w *sessionWriter) WriteHeader(code int) {
+	w.swap()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// WriteHeaderNow implements gin.ResponseWriter interface
+func (w *sessionWriter) WriteHeaderNow() {
+	w.swap()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write implements http.ResponseWriter interface
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.swap()
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter interface
+func (w *sessionWriter) WriteString(s string) (int, error) {
+	w.swap()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// helper function to wrap OAuth callback and convert its user cookie into session
+func oauthSession(c *gin.Context, callback func(c *gin.Context)) {
+	orig := c.Writer
+	c.Writer = &sessionWriter{ResponseWriter: orig, ctx: c}
+	defer func() { c.Writer = orig }()
+	callback(c)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSessionStore tests session store APIs
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore(time.Hour, 2*time.Hour)
+	value, _, err := store.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := store.Get(value)
+	if err != nil || sess.User != "alice" {
+		t.Fatalf("unexpected session %+v error %v", sess, err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "Missing signature", value: sess.ID, err: ErrInvalidSession},
+		{name: "Forged signature", value: sess.ID + ".abc", err: ErrInvalidSession},
+		{name: "Plain user name", value: "alice", err: ErrInvalidSession},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.Get(tt.value); !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// idle expiration
+	sess.LastSeen = time.Now().Add(-2 * time.Hour)
+	if _, err := store.Get(value); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected idle expiration, got %v", err)
+	}
+
+	// absolute expiration
+	value, sess, _ = store.Create("alice")
+	sess.Created = time.Now().Add(-3 * time.Hour)
+	if _, err := store.Get(value); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected absolute expiration, got %v", err)
+	}
+
+	// revocation
+	value, _, _ = store.Create("alice")
+	store.Revoke(value)
+	if _, err := store.Get(value); !errors.Is(err, ErrNoSession) {
+		t.Errorf("expected revoked session, got %v", err)
+	}
+}
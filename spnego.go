@@ -0,0 +1,108 @@
+package main
+
+// spnego module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The spnego module provides Kerberos single sign-on via HTTP Negotiate
+// (SPNEGO) mechanism. The client Kerberos ticket is validated against
+// keytab defined in Kerberos.Keytab configuration and on success we start
+// new user session. Clients without Kerberos ticket receive login page
+// with password form as a fallback.
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/jcmturner/gokrb5.v7/credentials"
+	"gopkg.in/jcmturner/gokrb5.v7/gssapi"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
+	"gopkg.in/jcmturner/gokrb5.v7/service"
+	"gopkg.in/jcmturner/gokrb5.v7/spnego"
+	"gopkg.in/jcmturner/gokrb5.v7/types"
+)
+
+// _keytab holds service keytab used to validate SPNEGO tokens
+var _keytab *keytab.Keytab
+
+// helper function to load service keytab
+func initSPNEGO() {
+	fname := srvConfig.Config.Kerberos.Keytab
+	if fname == "" {
+		return
+	}
+	kt, err := keytab.Load(fname)
+	if err != nil {
+		log.Printf("ERROR: unable to load keytab %s, SPNEGO login is disabled, error %v", fname, err)
+		return
+	}
+	_keytab = kt
+	log.Println("kerberos SPNEGO login is enabled")
+}
+
+// helper function to validate SPNEGO token and return kerberos user name
+func spnegoUser(r *http.Request, kt *keytab.Keytab, negotiate string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(negotiate)
+	if err != nil {
+		return "", fmt.Errorf("[Frontend.main.spnegoUser] base64 decode error: %w", err)
+	}
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(data); err != nil {
+		return "", fmt.Errorf("[Frontend.main.spnegoUser] token.Unmarshal error: %w", err)
+	}
+	var opts []func(*service.Settings)
+	if addr, err := types.GetHostAddress(r.RemoteAddr); err == nil {
+		opts = append(opts, service.ClientAddress(addr))
+	}
+	if _config.KeytabPrincipal != "" {
+		opts = append(opts, service.KeytabPrincipal(_config.KeytabPrincipal))
+	}
+	svc := spnego.SPNEGOService(kt, opts...)
+	authed, ctx, status := svc.AcceptSecContext(&token)
+	if !authed || status.Code != gssapi.StatusComplete {
+		return "", fmt.Errorf("[Frontend.main.spnegoUser] SPNEGO validation error: %v", status)
+	}
+	creds, ok := ctx.Value(spnego.CTXKeyCredentials).(*credentials.Credentials)
+	if !ok || creds.UserName() == "" {
+		return "", errors.New("[Frontend.main.spnegoUser] no credentials in SPNEGO context")
+	}
+	// we only accept principals from our realm as they map to FOXDEN users
+	if realm := srvConfig.Config.Kerberos.Realm; realm != "" && !strings.EqualFold(creds.Domain(), realm) {
+		return "", fmt.Errorf("[Frontend.main.spnegoUser] principal %s@%s is not from realm %s",
+			creds.UserName(), creds.Domain(), realm)
+	}
+	return creds.UserName(), nil
+}
+
+// SPNEGOLoginHandler provides access to GET /kerberos/login endpoint
+func SPNEGOLoginHandler(c *gin.Context) {
+	if _keytab == nil {
+		loginPage(c, http.StatusOK)
+		return
+	}
+	auth := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(auth) != 2 || auth[0] != "Negotiate" {
+		// ask client to negotiate, clients without kerberos ticket will see login form
+		c.Header("WWW-Authenticate", "Negotiate")
+		loginPage(c, http.StatusUnauthorized)
+		return
+	}
+	user, err := spnegoUser(c.Request, _keytab, auth[1])
+	if err != nil {
+		log.Printf("SPNEGO login failed from %s, error %v", c.ClientIP(), err)
+		loginPage(c, http.StatusUnauthorized)
+		return
+	}
+	if err := startSession(c, user); err != nil {
+		handleError(c, http.StatusInternalServerError, "unable to start user session", err)
+		return
+	}
+	log.Println("SPNEGOLoginHandler started session for user", user)
+	c.Redirect(http.StatusFound, DEFAULT_END_POINT)
+}
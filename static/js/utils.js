@@ -279,3 +279,45 @@ function ChangeHeight(tag) {
         }
     }
 }
+// load facets of current results from given url and render them within tag,
+// onSelect callback is called with facet attribute and value clicked by user
+function loadFacets(tag, url, params, onSelect) {
+    var id = document.getElementById(tag);
+    if (!id) {
+        return
+    }
+    fetch(url + '?' + params.toString(), {headers: {'Accept': 'application/json'}})
+        .then(response => response.json())
+        .then(data => {
+            id.innerHTML = '';
+            if (!data.facets) {
+                return
+            }
+            data.facets.forEach(function(facet) {
+                if (!facet.values || facet.values.length == 0) {
+                    return
+                }
+                var div = document.createElement('div');
+                div.className = 'facet';
+                var name = document.createElement('b');
+                name.textContent = facet.name + ': ';
+                div.appendChild(name);
+                facet.values.slice(0, 10).forEach(function(v) {
+                    var a = document.createElement('a');
+                    a.href = '#';
+                    a.textContent = v.value + ' (' + v.count + ')';
+                    a.onclick = function() { onSelect(facet.name, v.value); return false; };
+                    div.appendChild(a);
+                    div.appendChild(document.createTextNode(' '));
+                });
+                id.appendChild(div);
+            });
+            if (data.partial) {
+                var note = document.createElement('div');
+                note.className = 'small';
+                note.textContent = 'counts are based on first ' + data.nrecords + ' records';
+                id.appendChild(note);
+            }
+        })
+        .catch(err => console.log("unable to load facets", err));
+}
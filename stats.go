@@ -0,0 +1,501 @@
+package main
+
+// stats module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The stats module provides facility statistics dashboard via /stats
+// endpoint: number of datasets per beamline, cycle and BTR over time, DOI
+// counts (draft vs public), notes activity and sync activity. Statistics are
+// aggregated from records of Discovery service (restricted by user BTRs),
+// notes of ELog service and requests of Sync service, they can be narrowed
+// by date range and beamline, e.g.
+//
+//	/stats?from=2024-01-01&to=2024-12-31&beamline=3a&interval=month
+//
+// and they are cached. Every statistics is provided as chart-ready data,
+// i.e. list of labels (x-axis) and named series of values, either all of
+// them via /stats?format=json or a single chart via /stats/<name>, e.g.
+// /stats/beamline or /stats/dois.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// statsIntervals defines supported time intervals and their period formats
+var statsIntervals = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+// statsAttributes lists record attributes which datasets are counted by
+var statsAttributes = []string{"beamline", "cycle", "btr"}
+
+// statsMaxSeries defines max number of series of chart, the rest of series
+// is combined into other series
+const statsMaxSeries = 10
+
+// ChartSeries represents named series of chart values
+type ChartSeries struct {
+	Name string `json:"name"`
+	Data []int  `json:"data"` // values of series, one per chart label
+}
+
+// Chart represents chart-ready statistics
+type Chart struct {
+	Name   string        `json:"name"`
+	Title  string        `json:"title"`
+	Labels []string      `json:"labels"` // labels of x-axis, e.g. periods of time
+	Series []ChartSeries `json:"series"`
+	Totals []int         `json:"totals"` // sum of all series per label
+	Max    int           `json:"-"`      // max of totals, used to draw bars
+}
+
+// StatsFilter represents filters of statistics request
+type StatsFilter struct {
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Beamline string `json:"beamline,omitempty"`
+	Interval string `json:"interval"`
+}
+
+// StatsResults represents facility statistics
+type StatsResults struct {
+	Filter   StatsFilter `json:"filter"`
+	NRecords int         `json:"nrecords"` // number of aggregated records
+	Partial  bool        `json:"partial"`  // true if not all matching records were aggregated
+	Errors   []string    `json:"errors,omitempty"`
+	Charts   []Chart     `json:"charts"`
+	Updated  time.Time   `json:"updated"`
+}
+
+// Chart returns chart of statistics with given name
+func (s StatsResults) Chart(name string) (Chart, bool) {
+	for _, chart := range s.Charts {
+		if chart.Name == name {
+			return chart, true
+		}
+	}
+	return Chart{}, false
+}
+
+// helper function to parse filters of statistics request
+func statsFilter(c *gin.Context) (StatsFilter, error) {
+	f := StatsFilter{
+		From:     strings.TrimSpace(c.Query("from")),
+		To:       strings.TrimSpace(c.Query("to")),
+		Beamline: strings.TrimSpace(c.Query("beamline")),
+		Interval: c.DefaultQuery("interval", "month"),
+	}
+	if _, ok := statsIntervals[f.Interval]; !ok {
+		return f, fmt.Errorf("unsupported interval %q, please use day, month or year", f.Interval)
+	}
+	return f, nil
+}
+
+// helper function to make spec of statistics filter
+func statsSpec(f StatsFilter) (map[string]any, error) {
+	spec := make(map[string]any)
+	if f.Beamline != "" {
+		spec["beamline"] = f.Beamline
+	}
+	if f.From != "" || f.To != "" {
+		cond, err := dateCondition(f.From + ".." + f.To)
+		if err != nil {
+			return nil, err
+		}
+		spec["date"] = cond
+	}
+	return spec, nil
+}
+
+// helper function to check if given unix time (in seconds) is within date
+// range of the spec
+func inDateRange(spec map[string]any, ts int64) bool {
+	cond, ok := spec["date"].(map[string]any)
+	if !ok {
+		return true
+	}
+	if lo, ok := cond["$gte"].(int64); ok && ts < lo {
+		return false
+	}
+	if hi, ok := cond["$lte"].(int64); ok && ts > hi {
+		return false
+	}
+	return true
+}
+
+// helper function to convert record date (seconds since epoch) to time
+func recordTime(val any) (time.Time, bool) {
+	switch v := val.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case int64:
+		return time.Unix(v, 0), true
+	case int:
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// statsCounter counts values of named charts by series and labels
+type statsCounter struct {
+	counts map[string]map[string]map[string]int // chart -> series -> label -> count
+	labels map[string]map[string]bool           // chart -> labels
+}
+
+// helper function to create new stats counter
+func newStatsCounter() *statsCounter {
+	return &statsCounter{
+		counts: make(map[string]map[string]map[string]int),
+		labels: make(map[string]map[string]bool),
+	}
+}
+
+// helper function to increment count of given chart, series and label
+func (s *statsCounter) add(chart, series, label string) {
+	if _, ok := s.counts[chart]; !ok {
+		s.counts[chart] = make(map[string]map[string]int)
+		s.labels[chart] = make(map[string]bool)
+	}
+	if _, ok := s.counts[chart][series]; !ok {
+		s.counts[chart][series] = make(map[string]int)
+	}
+	s.counts[chart][series][label]++
+	s.labels[chart][label] = true
+}
+
+// helper function to make chart of given name, labels are sorted and at
+// most statsMaxSeries largest series are kept
+func (s *statsCounter) chart(name, title string) Chart {
+	chart := Chart{Name: name, Title: title, Labels: []string{}, Series: []ChartSeries{}}
+	for label := range s.labels[name] {
+		chart.Labels = append(chart.Labels, label)
+	}
+	sort.Strings(chart.Labels)
+	totals := make(map[string]int)
+	var names []string
+	for series, counts := range s.counts[name] {
+		names = append(names, series)
+		for _, count := range counts {
+			totals[series] += count
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] == totals[names[j]] {
+			return names[i] < names[j]
+		}
+		return totals[names[i]] > totals[names[j]]
+	})
+	var other *ChartSeries
+	for idx, series := range names {
+		data := make([]int, len(chart.Labels))
+		for i, label := range chart.Labels {
+			data[i] = s.counts[name][series][label]
+		}
+		if idx < statsMaxSeries {
+			chart.Series = append(chart.Series, ChartSeries{Name: series, Data: data})
+			continue
+		}
+		if other == nil {
+			other = &ChartSeries{Name: "other", Data: make([]int, len(chart.Labels))}
+		}
+		for i, val := range data {
+			other.Data[i] += val
+		}
+	}
+	if other != nil {
+		chart.Series = append(chart.Series, *other)
+	}
+	chart.Totals = make([]int, len(chart.Labels))
+	for _, series := range chart.Series {
+		for i, val := range series.Data {
+			chart.Totals[i] += val
+		}
+	}
+	for _, val := range chart.Totals {
+		if val > chart.Max {
+			chart.Max = val
+		}
+	}
+	return chart
+}
+
+// helper function to get list of values of record attribute
+func attrValues(rec map[string]any, attr string) []string {
+	switch v := rec[attr].(type) {
+	case nil:
+		return nil
+	case []any:
+		var out []string
+		for _, item := range v {
+			out = append(out, fmt.Sprintf("%v", item))
+		}
+		return out
+	case []string:
+		return v
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	default:
+		return []string{fmt.Sprintf("%v", v)}
+	}
+}
+
+// helper function to count datasets and DOIs of given records, periods of
+// time are formatted with given layout and time zone
+func countRecords(counter *statsCounter, records []map[string]any, layout string, loc *time.Location) {
+	for _, rec := range records {
+		t, ok := recordTime(rec["date"])
+		if !ok {
+			continue
+		}
+		period := t.In(loc).Format(layout)
+		counter.add("datasets", "datasets", period)
+		for _, attr := range statsAttributes {
+			for _, val := range attrValues(rec, attr) {
+				counter.add(attr, val, period)
+			}
+		}
+		if doi, ok := rec["doi"].(string); ok && doi != "" {
+			status := "draft"
+			if public, ok := rec["doi_public"].(bool); ok && public {
+				status = "public"
+			}
+			// DOIs are counted by their creation time if it is known
+			if val, ok := rec["doi_created_at"].(string); ok {
+				if dt, err := time.Parse(time.RFC3339, val); err == nil {
+					period = dt.In(loc).Format(layout)
+				}
+			}
+			counter.add("dois", status, period)
+		}
+	}
+}
+
+// helper function to count notes within date range of the spec
+func countNotes(counter *statsCounter, notes []NoteEntry, spec map[string]any, layout string, loc *time.Location) {
+	for _, n := range notes {
+		t := time.Unix(0, int64(n.Date))
+		if !inDateRange(spec, t.Unix()) {
+			continue
+		}
+		counter.add("notes", "notes", t.In(loc).Format(layout))
+	}
+}
+
+// helper function to count sync requests of given dids by their status
+func countSync(counter *statsCounter, records []map[string]any, dids map[string]bool) {
+	for _, rec := range records {
+		if !dids[recValue(rec, "did")] {
+			continue
+		}
+		status := recValue(rec, "status")
+		if status == "" {
+			status = "unknown"
+		}
+		series := "one-time"
+		if continuous, ok := rec["continuous"].(bool); ok && continuous {
+			series = "continuous"
+		}
+		counter.add("sync", series, status)
+	}
+}
+
+// helper function to aggregate statistics of records matching given service
+// request, at most maxRecords records are aggregated
+func aggregateStats(rec services.ServiceRequest, f StatsFilter, maxRecords int, loc *time.Location) (StatsResults, error) {
+	results := StatsResults{Filter: f, Updated: time.Now()}
+	layout := statsIntervals[f.Interval]
+	counter := newStatsCounter()
+	dids := make(map[string]bool)
+	chunkSize := 1000
+	if chunkSize > maxRecords {
+		chunkSize = maxRecords
+	}
+	// records are paged in stable order, did is unique tie-breaker of date
+	rec.ServiceQuery.SortKeys = []string{"date", "did"}
+	rec.ServiceQuery.SortOrder = 1
+	for idx := 0; idx < maxRecords; idx += chunkSize {
+		limit := chunkSize
+		if idx+limit > maxRecords {
+			limit = maxRecords - idx
+		}
+		rec.ServiceQuery.Idx = idx
+		rec.ServiceQuery.Limit = limit
+		if idx+limit == maxRecords {
+			// fetch one more record to know if there are records we do not aggregate
+			rec.ServiceQuery.Limit = limit + 1
+		}
+		resp, err := chunkOfRecords(rec)
+		if err == nil && resp.HttpCode != 0 && resp.HttpCode != http.StatusOK {
+			err = fmt.Errorf("[Frontend.main.aggregateStats] discovery service error: %s", resp.Error)
+		}
+		if err != nil {
+			return results, err
+		}
+		records := resp.Results.Records
+		if len(records) > limit {
+			records = records[:limit]
+			results.Partial = true
+		}
+		countRecords(counter, records, layout, loc)
+		for _, r := range records {
+			if did := recValue(r, "did"); did != "" {
+				dids[did] = true
+			}
+		}
+		results.NRecords += len(records)
+		if len(records) < limit {
+			break
+		}
+	}
+
+	// notes and sync activity are counted for aggregated datasets only,
+	// failures of these services are reported but do not fail statistics
+	dateSpec, _ := statsSpec(f)
+	var allDids []string
+	for did := range dids {
+		allDids = append(allDids, did)
+	}
+	sort.Strings(allDids)
+	for i := 0; i < len(allDids); i += chunkSize {
+		end := i + chunkSize
+		if end > len(allDids) {
+			end = len(allDids)
+		}
+		notes, err := fetchNotes(allDids[i:end])
+		if err != nil {
+			results.Errors = append(results.Errors, err.Error())
+			break
+		}
+		countNotes(counter, notes, dateSpec, layout, loc)
+	}
+	if len(dids) > 0 {
+		records, err := getSyncRecords("")
+		if err != nil {
+			results.Errors = append(results.Errors, err.Error())
+		}
+		countSync(counter, records, dids)
+	}
+
+	results.Charts = append(results.Charts, counter.chart("datasets", "Datasets"))
+	results.Charts = append(results.Charts, counter.chart("beamline", "Datasets per beamline"))
+	results.Charts = append(results.Charts, counter.chart("cycle", "Datasets per cycle"))
+	results.Charts = append(results.Charts, counter.chart("btr", "Datasets per BTR"))
+	results.Charts = append(results.Charts, counter.chart("dois", "DOIs (draft vs public)"))
+	results.Charts = append(results.Charts, counter.chart("notes", "Notes activity"))
+	results.Charts = append(results.Charts, counter.chart("sync", "Sync requests by status"))
+	return results, nil
+}
+
+// helper function to get (cached) statistics for given user and filter
+func userStats(c *gin.Context, user string, f StatsFilter) (StatsResults, error) {
+	spec, err := statsSpec(f)
+	if err != nil {
+		return StatsResults{}, err
+	}
+	spec = restrictSpec(c, spec)
+	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
+		fuser, err := getFoxdenUser(c, user)
+		if err != nil {
+			return StatsResults{}, err
+		}
+		if !hasAccess(fuser) {
+			return StatsResults{}, fmt.Errorf("[Frontend.main.userStats] user %s is not associated with any BTRs", user)
+		}
+		spec = updateSpec(spec, fuser, "search")
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return StatsResults{}, fmt.Errorf("[Frontend.main.userStats] json.Marshal error: %w", err)
+	}
+	rec := services.ServiceRequest{
+		Client:       "frontend",
+		ServiceQuery: services.ServiceQuery{Query: string(query), Spec: spec},
+	}
+	maxRecords := _config.StatsMaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 10000
+	}
+	loc := userPreferences(user).Location()
+	if loc == nil {
+		loc = time.UTC
+	}
+	// statistics depend only on final spec, interval and time zone of the user
+	key := fmt.Sprintf("%s|%s|%s", query, f.Interval, loc)
+	val, err := getCache(statsCache).GetOrLoad(key, func() (any, error) {
+		return aggregateStats(rec, f, maxRecords, loc)
+	})
+	if err != nil {
+		return StatsResults{}, err
+	}
+	return val.(StatsResults), nil
+}
+
+// StatsHandler provides access to GET /stats endpoint
+func StatsHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	jsonFormat := c.Query("format") == "json" || c.Request.Header.Get("Accept") == "application/json"
+	f, err := statsFilter(c)
+	var results StatsResults
+	if err == nil {
+		results, err = userStats(c, user, f)
+	}
+	if err != nil {
+		if jsonFormat {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		handleError(c, http.StatusBadRequest, "unable to get statistics", err)
+		return
+	}
+	if jsonFormat {
+		c.JSON(http.StatusOK, results)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Statistics")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["Stats"] = results
+	tmpl["Beamlines"] = _beamlines
+	if nrecords, err := countMetadataRecords(); err == nil {
+		tmpl["NRecords"] = nrecords
+	}
+	content := server.TmplPage(StaticFs, "stats.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
+
+// StatsChartHandler provides access to GET /stats/:name endpoint
+func StatsChartHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	f, err := statsFilter(c)
+	var results StatsResults
+	if err == nil {
+		results, err = userStats(c, user, f)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	chart, ok := results.Chart(c.Param("name"))
+	if !ok {
+		err := errors.New("unknown statistics, please use datasets, beamline, cycle, btr, dois, notes or sync")
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, chart)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// TestStatsSpec tests spec of statistics filters
+func TestStatsSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter StatsFilter
+		keys   []string
+		fail   bool
+	}{
+		{"no filters", StatsFilter{Interval: "month"}, nil, false},
+		{"beamline", StatsFilter{Beamline: "3a"}, []string{"beamline"}, false},
+		{"date range", StatsFilter{From: "2024-01-01", To: "2024-12-31"}, []string{"date"}, false},
+		{"open range", StatsFilter{From: "2024-01-01", Beamline: "3a"}, []string{"beamline", "date"}, false},
+		{"invalid date", StatsFilter{From: "01/01/2024"}, nil, true},
+	}
+	for _, tt := range tests {
+		spec, err := statsSpec(tt.filter)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		for _, key := range tt.keys {
+			if _, ok := spec[key]; !ok {
+				t.Errorf("%s: spec %v does not have %s", tt.name, spec, key)
+			}
+		}
+		if len(spec) != len(tt.keys) {
+			t.Errorf("%s: unexpected spec %v", tt.name, spec)
+		}
+	}
+}
+
+// TestCountRecords tests chart-ready statistics of records
+func TestCountRecords(t *testing.T) {
+	jan := float64(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC).Unix())
+	feb := float64(time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC).Unix())
+	records := []map[string]any{
+		{"did": "/a", "date": jan, "beamline": []any{"3a"}, "cycle": "2024-1", "btr": "x"},
+		{"did": "/b", "date": jan, "beamline": []any{"3a", "id1"}, "cycle": "2024-1", "btr": "y",
+			"doi": "10.1/b", "doi_public": true},
+		{"did": "/c", "date": feb, "beamline": "id1", "cycle": "2024-2", "btr": "x",
+			"doi": "10.1/c", "doi_created_at": "2024-03-01T10:00:00Z"},
+		{"did": "/d", "beamline": "3a"},
+	}
+	counter := newStatsCounter()
+	countRecords(counter, records, statsIntervals["month"], time.UTC)
+	notes := []NoteEntry{
+		{Did: "/a", Date: uint64(time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC).UnixNano())},
+		{Did: "/a", Date: uint64(time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC).UnixNano())},
+	}
+	spec, _ := statsSpec(StatsFilter{To: "2024-12-31"})
+	countNotes(counter, notes, spec, statsIntervals["month"], time.UTC)
+	syncRecords := []map[string]any{
+		{"did": "/a", "status": "completed", "continuous": false},
+		{"did": "/b", "status": "completed", "continuous": true},
+		{"did": "/z", "status": "failed"},
+	}
+	countSync(counter, syncRecords, map[string]bool{"/a": true, "/b": true, "/c": true})
+
+	tests := []struct {
+		chart  string
+		labels []string
+		series map[string][]int
+	}{
+		{"datasets", []string{"2024-01", "2024-02"}, map[string][]int{"datasets": {2, 1}}},
+		{"beamline", []string{"2024-01", "2024-02"}, map[string][]int{"3a": {2, 0}, "id1": {1, 1}}},
+		{"cycle", []string{"2024-01", "2024-02"}, map[string][]int{"2024-1": {2, 0}, "2024-2": {0, 1}}},
+		{"dois", []string{"2024-01", "2024-03"}, map[string][]int{"public": {1, 0}, "draft": {0, 1}}},
+		{"notes", []string{"2024-01"}, map[string][]int{"notes": {1}}},
+		{"sync", []string{"completed"}, map[string][]int{"one-time": {1}, "continuous": {1}}},
+	}
+	for _, tt := range tests {
+		chart := counter.chart(tt.chart, tt.chart)
+		if !reflect.DeepEqual(chart.Labels, tt.labels) {
+			t.Errorf("%s: labels %v, want %v", tt.chart, chart.Labels, tt.labels)
+		}
+		series := make(map[string][]int)
+		for _, s := range chart.Series {
+			series[s.Name] = s.Data
+		}
+		if !reflect.DeepEqual(series, tt.series) {
+			t.Errorf("%s: series %v, want %v", tt.chart, series, tt.series)
+		}
+	}
+}
+
+// TestStatsChartOther tests that smallest series are combined into other series
+func TestStatsChartOther(t *testing.T) {
+	counter := newStatsCounter()
+	for i := 0; i < statsMaxSeries+2; i++ {
+		for j := 0; j <= i; j++ {
+			counter.add("btr", string(rune('a'+i)), "2024")
+		}
+	}
+	chart := counter.chart("btr", "BTRs")
+	if len(chart.Series) != statsMaxSeries+1 {
+		t.Fatalf("wrong number of series %d", len(chart.Series))
+	}
+	if other := chart.Series[statsMaxSeries]; other.Name != "other" || other.Data[0] != 3 {
+		t.Errorf("wrong other series %+v", other)
+	}
+	if chart.Totals[0] != chart.Max || chart.Max != 78 {
+		t.Errorf("wrong totals %v and max %d", chart.Totals, chart.Max)
+	}
+}
+
+// TestAggregateStatsPartial tests stable paging of records and partial flag
+// of aggregated statistics
+func TestAggregateStatsPartial(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	var nrecords int
+	var sortKeys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			w.Write([]byte("[]"))
+			return
+		}
+		var rec services.ServiceRequest
+		json.NewDecoder(r.Body).Decode(&rec)
+		sortKeys = rec.ServiceQuery.SortKeys
+		var records []map[string]any
+		for i := rec.ServiceQuery.Idx; i < nrecords && i < rec.ServiceQuery.Idx+rec.ServiceQuery.Limit; i++ {
+			records = append(records, map[string]any{"did": fmt.Sprintf("/%04d", i), "beamline": "3a"})
+		}
+		json.NewEncoder(w).Encode(services.ServiceResponse{Results: services.ServiceResults{Records: records}})
+	}))
+	defer srv.Close()
+
+	srvServices := srvConfig.Config.Services
+	httpRequest := _httpReadRequest
+	defer func() {
+		srvConfig.Config.Services = srvServices
+		_httpReadRequest = httpRequest
+	}()
+	srvConfig.Config.Services.DiscoveryURL = srv.URL
+	srvConfig.Config.Services.ELogServiceURL = srv.URL
+	_httpReadRequest = &services.HttpRequest{Token: "token", Expires: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name       string
+		nrecords   int
+		maxRecords int
+		aggregated int
+		partial    bool
+	}{
+		{"less records", 3, 5, 3, false},
+		{"exact number of records", 5, 5, 5, false},
+		{"more records", 6, 5, 5, true},
+		{"several chunks", 2500, 2500, 2500, false},
+		{"several chunks and more records", 2501, 2500, 2500, true},
+	}
+	for _, tt := range tests {
+		nrecords = tt.nrecords
+		rec := services.ServiceRequest{Client: "frontend"}
+		results, err := aggregateStats(rec, StatsFilter{Interval: "month"}, tt.maxRecords, time.UTC)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if results.NRecords != tt.aggregated || results.Partial != tt.partial {
+			t.Errorf("%s: aggregated %d partial %v, want %d and %v",
+				tt.name, results.NRecords, results.Partial, tt.aggregated, tt.partial)
+		}
+		if !reflect.DeepEqual(sortKeys, []string{"date", "did"}) {
+			t.Errorf("%s: unstable sort keys %v", tt.name, sortKeys)
+		}
+	}
+}
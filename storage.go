@@ -0,0 +1,78 @@
+package main
+
+// storage module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The storage module provides simple persistent storage of frontend
+// data structures as JSON documents within Frontend.StorageDir area.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JSONStore represents JSON document stored on local file system
+type JSONStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// NewJSONStore creates new JSON store for given file name within storage area
+func NewJSONStore(fname string) *JSONStore {
+	return &JSONStore{Path: filepath.Join(storageDir(), fname)}
+}
+
+// Load reads JSON document into given object, missing document is not an error
+func (s *JSONStore) Load(obj any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Load] os.ReadFile error: %w", err)
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Load] json.Unmarshal error: %w", err)
+	}
+	return nil
+}
+
+// Save atomically writes given object as JSON document
+func (s *JSONStore) Save(obj any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Save] json.Marshal error: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Save] os.MkdirAll error: %w", err)
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Save] os.WriteFile error: %w", err)
+	}
+	if err := os.Rename(tmp, s.Path); err != nil {
+		return fmt.Errorf("[Frontend.main.JSONStore.Save] os.Rename error: %w", err)
+	}
+	return nil
+}
+
+// helper function to return frontend storage area
+func storageDir() string {
+	if _config.StorageDir != "" {
+		return _config.StorageDir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".foxden", "frontend")
+	}
+	return filepath.Join(os.TempDir(), "foxden", "frontend")
+}
@@ -0,0 +1,179 @@
+package main
+
+// suggest module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The suggest module provides /search/suggest endpoint used by search page
+// autocompletion. Without key parameter it returns query keys matching given
+// input along with their descriptions, units and data types. With key
+// parameter it returns distinct existing values of that key. Values are
+// obtained from Discovery service for records accessible to the user, i.e.
+// restricted to user's BTRs, and cached for Frontend.SuggestCacheTTL seconds.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// KeySuggestion represents query key suggestion
+type KeySuggestion struct {
+	Key         string `json:"key"`
+	Description string `json:"description,omitempty"`
+	Units       string `json:"units,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Service     string `json:"service,omitempty"`
+}
+
+// helper function to find query keys matching given input, keys which start
+// with the input come first, then keys containing the input and finally keys
+// whose description contains the input
+func suggestQueryKeys(input string, suggestions []KeySuggestion, limit int) []KeySuggestion {
+	input = strings.ToLower(strings.TrimSpace(input))
+	rank := func(s KeySuggestion) int {
+		key := strings.ToLower(s.Key)
+		switch {
+		case strings.HasPrefix(key, input):
+			return 0
+		case strings.Contains(key, input):
+			return 1
+		case strings.Contains(strings.ToLower(s.Description), input):
+			return 2
+		}
+		return -1
+	}
+	var out []KeySuggestion
+	for _, s := range suggestions {
+		if rank(s) >= 0 {
+			out = append(out, s)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, rj := rank(out[i]), rank(out[j])
+		if ri == rj {
+			return out[i].Key < out[j].Key
+		}
+		return ri < rj
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// helper function to collect all query keys with their descriptions, QL
+// records provide descriptions while schemas supplement remaining keys
+func keySuggestions() []KeySuggestion {
+	var suggestions []KeySuggestion
+	qlKeys := queryKeys()
+	for _, rec := range qlKeys {
+		suggestions = append(suggestions, KeySuggestion{
+			Key:         rec.Key,
+			Description: rec.Description,
+			Units:       rec.Units,
+			Type:        rec.DataType,
+			Service:     rec.Service,
+		})
+	}
+	for key, qkey := range knownQueryKeys() {
+		if _, ok := qlKeys[key]; ok {
+			continue
+		}
+		suggestions = append(suggestions, KeySuggestion{Key: key, Units: qkey.Units, Type: qkey.Type})
+	}
+	return suggestions
+}
+
+// helper function to filter distinct values by given input
+func suggestValues(input string, values []FacetValue, limit int) []FacetValue {
+	input = strings.ToLower(strings.TrimSpace(input))
+	var out []FacetValue
+	for _, v := range values {
+		if strings.HasPrefix(strings.ToLower(v.Value), input) {
+			out = append(out, v)
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// helper function to get distinct values of given key accessible to the user
+func distinctValues(c *gin.Context, user, key string) ([]FacetValue, error) {
+	spec := map[string]any{key: map[string]any{"$exists": true}}
+	spec = restrictSpec(c, spec)
+	if user != "test" && srvConfig.Config.Frontend.CheckBtrs && srvConfig.Config.Embed.DocDb == "" {
+		fuser, err := getFoxdenUser(c, user)
+		if err != nil {
+			return nil, err
+		}
+		if !hasAccess(fuser) {
+			return nil, fmt.Errorf("[Frontend.main.distinctValues] user %s is not associated with any BTRs", user)
+		}
+		spec = updateSpec(spec, fuser, "search")
+	}
+	query, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.distinctValues] json.Marshal error: %w", err)
+	}
+	// final spec carries all user restrictions and it is used as cache key
+	ckey := string(query)
+	if val, ok := getCache(suggestCache).Get(ckey); ok {
+		return val.([]FacetValue), nil
+	}
+	rec := services.ServiceRequest{
+		Client:       "frontend",
+		ServiceQuery: services.ServiceQuery{Query: string(query), Spec: spec},
+	}
+	maxRecords := _config.SuggestMaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 5000
+	}
+	results, err := scanFacets(rec, []string{key}, maxRecords)
+	if err != nil {
+		return nil, err
+	}
+	var values []FacetValue
+	if len(results.Facets) > 0 {
+		values = results.Facets[0].Values
+	}
+	getCache(suggestCache).Set(ckey, values)
+	return values, nil
+}
+
+// SuggestHandler provides access to GET /search/suggest endpoint
+func SuggestHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	input := c.Query("q")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unable to parse limit parameter"})
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusOK, gin.H{"keys": suggestQueryKeys(input, keySuggestions(), limit)})
+		return
+	}
+	if keys := knownQueryKeys(); len(keys) > 0 {
+		if _, ok := lookupQueryKey(key, keys); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown key %s", key)})
+			return
+		}
+	}
+	values, err := distinctValues(c, user, key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "values": suggestValues(input, values, limit)})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestSuggestQueryKeys tests ordering of suggested query keys
+func TestSuggestQueryKeys(t *testing.T) {
+	suggestions := []KeySuggestion{
+		{Key: "sample_name", Description: "name of the sample"},
+		{Key: "beam_energy", Description: "beam energy", Units: "keV"},
+		{Key: "beamline", Description: "beamline name"},
+		{Key: "atten_material", Description: "attenuator material"},
+	}
+	tests := []struct {
+		input    string
+		limit    int
+		expected []string
+	}{
+		{input: "beam", limit: 10, expected: []string{"beam_energy", "beamline"}},
+		{input: "name", limit: 10, expected: []string{"sample_name", "beamline"}},
+		{input: "", limit: 2, expected: []string{"atten_material", "beam_energy"}},
+		{input: "xyz", limit: 10, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var keys []string
+			for _, s := range suggestQueryKeys(tt.input, suggestions, tt.limit) {
+				keys = append(keys, s.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, keys)
+			}
+		})
+	}
+}
+
+// TestSuggestValues tests filtering of distinct values
+func TestSuggestValues(t *testing.T) {
+	values := []FacetValue{{Value: "3a", Count: 5}, {Value: "ID1A3", Count: 3}, {Value: "id3a", Count: 1}}
+	out := suggestValues("id", values, 10)
+	expected := []FacetValue{{Value: "ID1A3", Count: 3}, {Value: "id3a", Count: 1}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+	if out := suggestValues("", values, 1); len(out) != 1 {
+		t.Errorf("expected single value, got %v", out)
+	}
+}
+
+// foxdenUserStub provides FOXDEN user attributes for tests
+type foxdenUserStub struct {
+	user services.User
+}
+
+func (f *foxdenUserStub) Init()                                    {}
+func (f *foxdenUserStub) GetUsers() ([]string, error)              { return []string{f.user.Name}, nil }
+func (f *foxdenUserStub) GetGroups() ([]string, error)             { return f.user.Groups, nil }
+func (f *foxdenUserStub) Get(user string) (services.User, error)   { return f.user, nil }
+func (f *foxdenUserStub) GetGroup(did string) string               { return "" }
+func (f *foxdenUserStub) GetEmail(user string) (string, error)     { return "", nil }
+func (f *foxdenUserStub) GetMembers(user string) ([]string, error) { return nil, nil }
+
+// TestSuggestHandlerMaglab tests that MagLab users who have groups but no
+// BTRs get values of records of their groups
+func TestSuggestHandlerMaglab(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	var spec map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec services.ServiceRequest
+		json.NewDecoder(r.Body).Decode(&rec)
+		spec = rec.ServiceQuery.Spec
+		resp := services.ServiceResponse{Results: services.ServiceResults{
+			Records: []map[string]any{{"sample_name": "Ti"}},
+		}}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	frontend := srvConfig.Config.Frontend
+	discoveryURL := srvConfig.Config.Services.DiscoveryURL
+	foxdenUser, httpRequest := _foxdenUser, _httpReadRequest
+	defer func() {
+		srvConfig.Config.Frontend = frontend
+		srvConfig.Config.Services.DiscoveryURL = discoveryURL
+		_foxdenUser, _httpReadRequest = foxdenUser, httpRequest
+	}()
+	srvConfig.Config.Frontend.FoxdenUser.User = "MaglabUser"
+	srvConfig.Config.Frontend.CheckBtrs = true
+	srvConfig.Config.Services.DiscoveryURL = srv.URL
+	_httpReadRequest = &services.HttpRequest{Token: "token", Expires: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name         string
+		groups       []string
+		restrictions *TokenRestrictions
+		code         int
+		allowed      []string
+	}{
+		{"user groups", []string{"g1", "g2"}, nil, http.StatusOK, []string{"g1", "g2"}},
+		{"restricted token", []string{"g1", "g2"}, &TokenRestrictions{Btrs: []string{"g2"}}, http.StatusOK, []string{"g2"}},
+		{"no groups", nil, nil, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec = nil
+			newCache(suggestCache)
+			_foxdenUser = &foxdenUserStub{user: services.User{Name: "alice", Groups: tt.groups}}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/search/suggest?key=sample_name&q=t", nil)
+			c.Set("user", "alice")
+			if tt.restrictions != nil {
+				c.Set("restrictions", tt.restrictions)
+			}
+			SuggestHandler(c)
+			if w.Code != tt.code {
+				t.Fatalf("expected code %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if !strings.Contains(w.Body.String(), "Ti") {
+				t.Errorf("values of user records are missing: %s", w.Body.String())
+			}
+			access, err := json.Marshal(spec["$or"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			groups, _ := json.Marshal(tt.allowed)
+			for _, key := range []string{maglabGroupKey, maglabProposalKey} {
+				cond := fmt.Sprintf(`{"%s":{"$in":%s}}`, key, groups)
+				if !strings.Contains(string(access), cond) {
+					t.Errorf("spec %v does not have %s condition", spec, cond)
+				}
+			}
+		})
+	}
+}
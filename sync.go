@@ -59,3 +59,23 @@ func deleteSyncRecord(suuid string) error {
 	}
 	return nil
 }
+
+// helper function to create sync record in sync service, it returns
+// serialized record which is used to audit the change
+func createSyncRecord(rec map[string]any) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return data, fmt.Errorf("[Frontend.main.createSyncRecord] json.Marshal error: %w", err)
+	}
+	_httpWriteRequest.GetToken()
+	rurl := fmt.Sprintf("%s/record", srvConfig.Config.Services.SyncServiceURL)
+	resp, err := _httpWriteRequest.Post(rurl, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return data, fmt.Errorf("[Frontend.main.createSyncRecord] _httpWriteRequest.Post error: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return data, fmt.Errorf("[Frontend.main.createSyncRecord] sync service response status %s", resp.Status)
+	}
+	return data, nil
+}
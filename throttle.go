@@ -0,0 +1,225 @@
+package main
+
+// throttle module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The throttle module protects kerberos password login from brute-force
+// attacks. We keep per-user and per-IP counters of failed attempts, apply
+// exponential backoff between attempts and temporarily lock out users or
+// IPs which exceed allowed number of failures. Admins can unlock them via
+// /login/unlock endpoint.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errors returned by login throttle
+var (
+	ErrLoginLocked  = errors.New("too many failed login attempts, login is temporarily locked")
+	ErrLoginBackoff = errors.New("too many login attempts, please retry later")
+)
+
+// LoginAttempts represents failed login attempts of user or IP
+type LoginAttempts struct {
+	Key         string    `json:"key"`
+	Kind        string    `json:"kind"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// LoginThrottle keeps track of failed login attempts
+type LoginThrottle struct {
+	MaxFailures int           // number of failures before lockout
+	BaseDelay   time.Duration // initial backoff delay
+	MaxDelay    time.Duration // maximum backoff delay
+	Lockout     time.Duration // lockout duration
+	attempts    map[string]*LoginAttempts
+	mu          sync.Mutex
+}
+
+// NewLoginThrottle creates new login throttle
+func NewLoginThrottle(maxFailures int, baseDelay, maxDelay, lockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		MaxFailures: maxFailures,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		Lockout:     lockout,
+		attempts:    make(map[string]*LoginAttempts),
+	}
+}
+
+// _loginThrottle holds login throttle of kerberos password login
+var _loginThrottle = NewLoginThrottle(5, time.Second, 5*time.Minute, 15*time.Minute)
+
+// helper function to initialize login throttle from frontend configuration
+func initLoginThrottle() {
+	if _config.LoginMaxFailures > 0 {
+		_loginThrottle.MaxFailures = _config.LoginMaxFailures
+	}
+	if _config.LoginLockout > 0 {
+		_loginThrottle.Lockout = time.Duration(_config.LoginLockout) * time.Second
+	}
+	go _loginThrottle.Monitor(10 * time.Minute)
+}
+
+// helper function to construct attempts key
+func attemptsKey(kind, key string) string {
+	return kind + ":" + key
+}
+
+// helper function to compute backoff delay for given number of failures
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	d := t.BaseDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= t.MaxDelay {
+			return t.MaxDelay
+		}
+	}
+	return d
+}
+
+// helper function to check attempts record, it should be called with acquired lock
+func (t *LoginThrottle) check(rec *LoginAttempts, now time.Time) (time.Duration, error) {
+	if rec == nil {
+		return 0, nil
+	}
+	if now.Before(rec.LockedUntil) {
+		return rec.LockedUntil.Sub(now), ErrLoginLocked
+	}
+	if next := rec.LastFailure.Add(t.delay(rec.Failures)); now.Before(next) {
+		return next.Sub(now), ErrLoginBackoff
+	}
+	return 0, nil
+}
+
+// Allow checks if login attempt of given user from given IP is allowed, if it
+// is not allowed it returns time to wait before next attempt
+func (t *LoginThrottle) Allow(user, ip string) (time.Duration, error) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range []string{attemptsKey("user", user), attemptsKey("ip", ip)} {
+		if wait, err := t.check(t.attempts[key], now); err != nil {
+			return wait, err
+		}
+	}
+	return 0, nil
+}
+
+// Failure records failed login attempt of given user from given IP
+func (t *LoginThrottle) Failure(user, ip string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for kind, val := range map[string]string{"user": user, "ip": ip} {
+		key := attemptsKey(kind, val)
+		rec, ok := t.attempts[key]
+		if !ok || (!rec.LockedUntil.IsZero() && now.After(rec.LockedUntil)) {
+			// start new series of attempts once previous lockout is expired
+			rec = &LoginAttempts{Key: val, Kind: kind}
+			t.attempts[key] = rec
+		}
+		rec.Failures++
+		rec.LastFailure = now
+		if rec.Failures >= t.MaxFailures {
+			rec.LockedUntil = now.Add(t.Lockout)
+			log.Printf("SECURITY: login is locked for %s=%s until %s after %d failed attempts",
+				kind, val, rec.LockedUntil.Format(time.RFC3339), rec.Failures)
+		}
+	}
+	log.Printf("SECURITY: failed login attempt user=%s ip=%s", user, ip)
+}
+
+// Success resets failed login attempts of given user, failures of the IP are
+// kept since attacker may have valid account and use it to reset IP counter
+func (t *LoginThrottle) Success(user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, attemptsKey("user", user))
+}
+
+// Unlock removes failed login attempts of given kind (user or ip) and key
+func (t *LoginThrottle) Unlock(kind, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	akey := attemptsKey(kind, key)
+	if _, ok := t.attempts[akey]; !ok {
+		return fmt.Errorf("no failed login attempts for %s=%s", kind, key)
+	}
+	delete(t.attempts, akey)
+	return nil
+}
+
+// Attempts returns list of all failed login attempts
+func (t *LoginThrottle) Attempts() []LoginAttempts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var records []LoginAttempts
+	for _, rec := range t.attempts {
+		records = append(records, *rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastFailure.After(records[j].LastFailure)
+	})
+	return records
+}
+
+// Cleanup removes stale records of failed login attempts
+func (t *LoginThrottle) Cleanup() {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, rec := range t.attempts {
+		if now.After(rec.LockedUntil) && now.Sub(rec.LastFailure) > t.Lockout+t.MaxDelay {
+			delete(t.attempts, key)
+		}
+	}
+}
+
+// Monitor periodically cleans up stale records of failed login attempts
+func (t *LoginThrottle) Monitor(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		t.Cleanup()
+	}
+}
+
+// LoginLocksHandler provides access to GET /login/locks endpoint
+func LoginLocksHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, _loginThrottle.Attempts())
+}
+
+// LoginUnlockHandler provides access to POST /login/unlock endpoint
+func LoginUnlockHandler(c *gin.Context) {
+	admin, _ := getUser(c)
+	kind := "user"
+	key := c.Request.FormValue("user")
+	if key == "" {
+		kind = "ip"
+		key = c.Request.FormValue("ip")
+	}
+	if key == "" {
+		handleError(c, http.StatusBadRequest, "either user or ip parameter is required", errors.New("empty parameters"))
+		return
+	}
+	if err := _loginThrottle.Unlock(kind, key); err != nil {
+		handleError(c, http.StatusNotFound, "unable to unlock login", err)
+		return
+	}
+	log.Printf("SECURITY: admin %s unlocked login for %s=%s", admin, kind, key)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", kind: key})
+}
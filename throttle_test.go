@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestLoginThrottle tests login backoff and lockout
+func TestLoginThrottle(t *testing.T) {
+	throttle := NewLoginThrottle(3, time.Hour, 2*time.Hour, 4*time.Hour)
+	tests := []struct {
+		name     string
+		user     string
+		ip       string
+		failures int
+		expected error
+	}{
+		{name: "No failures", user: "alice", ip: "1.1.1.1", failures: 0, expected: nil},
+		{name: "Backoff", user: "bob", ip: "2.2.2.2", failures: 1, expected: ErrLoginBackoff},
+		{name: "Lockout", user: "carol", ip: "3.3.3.3", failures: 3, expected: ErrLoginLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.failures; i++ {
+				throttle.Failure(tt.user, tt.ip)
+			}
+			// both user and IP should be throttled
+			for _, ip := range []string{tt.ip, "9.9.9.9"} {
+				if _, err := throttle.Allow(tt.user, ip); !errors.Is(err, tt.expected) {
+					t.Errorf("user %s ip %s, expected %v, got %v", tt.user, ip, tt.expected, err)
+				}
+			}
+			if _, err := throttle.Allow("dave", tt.ip); !errors.Is(err, tt.expected) {
+				t.Errorf("ip %s, expected %v, got %v", tt.ip, tt.expected, err)
+			}
+		})
+	}
+	// admin unlock of user should not unlock IP
+	if err := throttle.Unlock("user", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.Allow("carol", "9.9.9.9"); err != nil {
+		t.Errorf("user carol should be unlocked, got %v", err)
+	}
+	if _, err := throttle.Allow("carol", "3.3.3.3"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("ip 3.3.3.3 should remain locked, got %v", err)
+	}
+	if err := throttle.Unlock("user", "unknown"); err == nil {
+		t.Error("expected error for unknown user")
+	}
+}
+
+// TestLoginThrottleSuccess tests that successful login does not reset IP failures
+func TestLoginThrottleSuccess(t *testing.T) {
+	throttle := NewLoginThrottle(3, 0, 0, time.Hour)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		throttle.Failure(user, "1.1.1.1")
+	}
+	// attacker logs into own account from the same IP
+	throttle.Success("mallory")
+	if _, err := throttle.Allow("dave", "1.1.1.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("ip 1.1.1.1 should remain locked, got %v", err)
+	}
+	throttle.Success("alice")
+	if _, err := throttle.Allow("alice", "2.2.2.2"); err != nil {
+		t.Errorf("user alice should be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+// tokens module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The tokens module keeps persistent registry of tokens issued by the
+// frontend. The registry allows users to list and revoke their own tokens
+// and getUser rejects bearer tokens which were revoked.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	"github.com/gin-gonic/gin"
+)
+
+// errors returned by token registry
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenRevoked  = errors.New("token is revoked")
+)
+
+// TokenRecord represents record of issued token, the token itself is not stored
+type TokenRecord struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`
+	Name     string    `json:"name"`
+	Scope    string    `json:"scope"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used"`
+	Revoked  bool      `json:"revoked"`
+}
+
+// Expired checks if token is expired
+func (t *TokenRecord) Expired() bool {
+	return time.Now().After(t.Expires)
+}
+
+// TokenRegistry represents registry of issued tokens
+type TokenRegistry struct {
+	store  *JSONStore
+	tokens map[string]*TokenRecord
+	mu     sync.Mutex
+}
+
+// _tokenRegistry holds registry of tokens issued by frontend
+var _tokenRegistry = &TokenRegistry{tokens: make(map[string]*TokenRecord)}
+
+// helper function to initialize token registry from persistent storage
+func initTokenRegistry() {
+	_tokenRegistry.store = NewJSONStore("tokens.json")
+	if err := _tokenRegistry.Load(); err != nil {
+		log.Println("ERROR: unable to load token registry", err)
+	}
+}
+
+// helper function to obtain token id from its claims, every token issued
+// by authz.JWTAccessToken carries unique random subject
+func tokenID(claims *authz.Claims) string {
+	return claims.RegisteredClaims.Subject
+}
+
+// Load loads registry from its persistent store
+func (r *TokenRegistry) Load() error {
+	if r.store == nil {
+		return nil
+	}
+	var records []*TokenRecord
+	if err := r.store.Load(&records); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range records {
+		r.tokens[rec.ID] = rec
+	}
+	return nil
+}
+
+// helper function to persist registry, it should be called with acquired lock
+func (r *TokenRegistry) save() error {
+	if r.store == nil {
+		return nil
+	}
+	var records []*TokenRecord
+	for id, rec := range r.tokens {
+		// there is no need to keep expired tokens as they can't be used anyway
+		if rec.Expired() {
+			delete(r.tokens, id)
+			continue
+		}
+		records = append(records, rec)
+	}
+	return r.store.Save(records)
+}
+
+// Register adds given token to the registry
+func (r *TokenRegistry) Register(token, name string) (*TokenRecord, error) {
+	claims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("[Frontend.main.TokenRegistry.Register] authz.TokenClaims error: %w", err)
+	}
+	rec := &TokenRecord{
+		ID:      tokenID(claims),
+		User:    claims.CustomClaims.User,
+		Name:    name,
+		Scope:   claims.CustomClaims.Scope,
+		Created: time.Now(),
+	}
+	if claims.ExpiresAt != nil {
+		rec.Expires = claims.ExpiresAt.Time
+	}
+	if rec.ID == "" {
+		return nil, errors.New("[Frontend.main.TokenRegistry.Register] token without subject")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[rec.ID] = rec
+	if err := r.save(); err != nil {
+		return rec, fmt.Errorf("[Frontend.main.TokenRegistry.Register] save error: %w", err)
+	}
+	return rec, nil
+}
+
+// Tokens returns list of non-expired tokens of given user
+func (r *TokenRegistry) Tokens(user string) []TokenRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []TokenRecord
+	for _, rec := range r.tokens {
+		if rec.User == user && !rec.Expired() {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.After(records[j].Created)
+	})
+	return records
+}
+
+// Revoke revokes token with given id which belongs to given user
+func (r *TokenRegistry) Revoke(user, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tokens[id]
+	if !ok || rec.User != user {
+		return ErrTokenNotFound
+	}
+	rec.Revoked = true
+	return r.save()
+}
+
+// Check checks that token with given claims is not revoked and records its usage
+func (r *TokenRegistry) Check(claims *authz.Claims) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tokens[tokenID(claims)]
+	if !ok {
+		// token was not issued by frontend, e.g. it comes from Authz service
+		return nil
+	}
+	if rec.Revoked {
+		return ErrTokenRevoked
+	}
+	// we only persist usage time once a minute to avoid excessive writes
+	now := time.Now()
+	if now.Sub(rec.LastUsed) > time.Minute {
+		rec.LastUsed = now
+		if err := r.save(); err != nil {
+			log.Println("ERROR: unable to save token registry", err)
+		}
+	}
+	return nil
+}
+
+// TokensHandler provides access to GET /tokens endpoint
+func TokensHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	records := _tokenRegistry.Tokens(user)
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, records)
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Tokens")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["Tokens"] = records
+	content := server.TmplPage(StaticFs, "tokens.tmpl", tmpl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(header()+content+footer()))
+}
+
+// TokenRevokeHandler provides access to POST /tokens/revoke and DELETE /tokens/:id endpoints
+func TokenRevokeHandler(c *gin.Context) {
+	user, _ := getUser(c)
+	id := c.Param("id")
+	if id == "" {
+		id = c.Request.FormValue("id")
+	}
+	err := _tokenRegistry.Revoke(user, id)
+	audit(c, "token_revoke", "", map[string]any{"id": id}, nil, err)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrTokenNotFound) {
+			code = http.StatusNotFound
+		}
+		handleError(c, code, "unable to revoke token", err)
+		return
+	}
+	if c.Request.Method == "DELETE" || c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id})
+		return
+	}
+	c.Redirect(http.StatusFound, base("/tokens"))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// TestTokenRegistry tests token registry APIs
+func TestTokenRegistry(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	srvConfig.Config.Authz.ClientID = "test-client-id"
+	store := &JSONStore{Path: filepath.Join(t.TempDir(), "tokens.json")}
+	registry := &TokenRegistry{store: store, tokens: make(map[string]*TokenRecord)}
+
+	token, err := newToken("alice", "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := registry.Register(token, "daq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Check(claims); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := registry.Revoke("bob", rec.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("other user should not revoke token, got %v", err)
+	}
+	if err := registry.Revoke("alice", rec.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// revocation should survive registry reload
+	registry = &TokenRegistry{store: store, tokens: make(map[string]*TokenRecord)}
+	if err := registry.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Check(claims); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected revoked token, got %v", err)
+	}
+	if records := registry.Tokens("alice"); len(records) != 1 || records[0].Name != "daq" {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
@@ -36,7 +36,7 @@ func newToken(user, scope string) (string, error) {
 	if duration == 0 {
 		duration = 7200
 	}
-	return authz.JWTAccessToken(srvConfig.Config.Authz.ClientID, duration, customClaims)
+	return restrictedToken(customClaims, duration, nil)
 }
 
 // helper function to get provenance data
@@ -244,6 +244,8 @@ func makeSpec(searchFilter string, attrs []string, caseInsensitive bool) map[str
 		return map[string]any{}
 	}
 	var filters []map[string]any
+	// user text is matched literally, i.e. it is not interpreted as regex pattern
+	searchFilter = regexp.QuoteMeta(searchFilter)
 	for _, attr := range attrs {
 		if pat, err := regexp.Compile(fmt.Sprintf(".*%s.*", searchFilter)); err == nil {
 			if caseInsensitive {
@@ -276,18 +278,131 @@ func updateBTRs(foxdenUser *services.User, searchFilter string) {
 // wrapper function to update spec for given foxden user and use case
 // it is deployment specific, i.e. at CHESS we use BTRs, at MagLab we may use something else
 func updateSpec(ispec map[string]any, foxdenUser services.User, useCase string) map[string]any {
-	fuser := strings.ToLower(srvConfig.Config.Frontend.FoxdenUser.User)
-	if strings.Contains(fuser, "maglab") {
+	if maglabDeployment() {
 		return maglabUpdateSpec(ispec, foxdenUser, useCase)
 	}
 	// by default we'll use CHESS method
 	return chessUpdateSpec(ispec, foxdenUser.FoxdenGroups, foxdenUser.Btrs, useCase)
 }
 
-// helper function to update query spec for maglab user
+// helper function to check if deployment controls access to records by
+// MagLab groups instead of CHESS BTRs
+func maglabDeployment() bool {
+	return strings.Contains(strings.ToLower(srvConfig.Config.Frontend.FoxdenUser.User), "maglab")
+}
+
+// helper function to get record attributes which control access to records
+// in this deployment and their values allowed to the user, i.e. BTRs at
+// CHESS and user groups at MagLab
+func accessAttributes(foxdenUser services.User) ([]string, []string) {
+	if maglabDeployment() {
+		return []string{maglabGroupKey, maglabProposalKey}, foxdenUser.Groups
+	}
+	return []string{"btr"}, foxdenUser.Btrs
+}
+
+// helper function to check if user has access attributes, i.e. it can see
+// any records in this deployment
+func hasAccess(foxdenUser services.User) bool {
+	_, allowed := accessAttributes(foxdenUser)
+	return len(allowed) > 0
+}
+
+// MagLab record attributes used to control access to records
+const (
+	maglabGroupKey    = "group"
+	maglabProposalKey = "proposal"
+)
+
+// helper function to update query spec for maglab user. MagLab records carry
+// group and proposal attributes and user can see records whose group or
+// proposal belongs to user LDAP groups. It has the following logic
+// - in case of search spec we restrict group and proposal values to user groups,
+// and if spec does not have them we add access condition to it
+// - in case of filter spec we make a new spec based on filter conditions
 func maglabUpdateSpec(ispec map[string]any, foxdenUser services.User, useCase string) map[string]any {
-	// TODO: implement logic here
-	return ispec
+	adminGroup := srvConfig.Config.AccessRules.AdminGroup
+	isAdmin := utils.InList(adminGroup, foxdenUser.FoxdenGroups) || utils.InList(adminGroup, foxdenUser.Groups)
+	if isAdmin && (srvConfig.Config.Frontend.CheckAdmins || srvConfig.Config.Frontend.AllowAllRecords) {
+		// admins are allowed to see all records
+		return ispec
+	}
+	userGroups := foxdenUser.Groups
+	if userGroups == nil {
+		userGroups = []string{}
+	}
+	access := map[string]any{
+		"$or": []map[string]any{
+			{maglabGroupKey: map[string]any{"$in": userGroups}},
+			{maglabProposalKey: map[string]any{"$in": userGroups}},
+		},
+	}
+
+	// search use-case
+	if useCase == "search" {
+		restricted := false
+		for _, key := range []string{maglabGroupKey, maglabProposalKey} {
+			if val, ok := ispec[key]; ok {
+				ispec[key] = map[string]any{"$in": finalBtrs(val, userGroups)}
+				restricted = true
+			}
+		}
+		if restricted {
+			return ispec
+		}
+		if len(ispec) == 0 {
+			return access
+		}
+		if _, ok := ispec["$or"]; ok {
+			// spec already has $or condition, we combine it with access one
+			return map[string]any{"$and": []map[string]any{ispec, access}}
+		}
+		ispec["$or"] = access["$or"]
+		return ispec
+	}
+
+	// filter use-case
+	var filters []map[string]any
+	if val, ok := ispec["$or"]; ok {
+		for _, flt := range specFilters(val) {
+			if _, ok := flt[maglabGroupKey]; ok {
+				continue
+			}
+			if _, ok := flt[maglabProposalKey]; ok {
+				continue
+			}
+			filters = append(filters, flt)
+		}
+	} else {
+		for key, val := range ispec {
+			filters = append(filters, map[string]any{key: val})
+		}
+	}
+	if len(filters) == 0 {
+		return access
+	}
+	return map[string]any{
+		"$and": []map[string]any{
+			{"$or": filters},
+			access,
+		},
+	}
+}
+
+// helper function to convert $or value of the spec into list of filters
+func specFilters(val any) []map[string]any {
+	var filters []map[string]any
+	switch v := val.(type) {
+	case []map[string]any:
+		filters = v
+	case []any:
+		for _, item := range v {
+			if flt, ok := item.(map[string]any); ok {
+				filters = append(filters, flt)
+			}
+		}
+	}
+	return filters
 }
 
 // helper function to update spec with ldap attributes. It has the following logic
@@ -436,8 +551,9 @@ func provenanceRecord(mrec services.MetaRecord) map[string]any {
 	return prov
 }
 
-// helper function to extract last modified timestamp
-func lastModified(m map[string]any) (string, error) {
+// helper function to extract last modified timestamp in given time zone (UTC
+// if it is not set)
+func lastModified(m map[string]any, loc *time.Location) (string, error) {
 	// fallback to date
 	ts, ok := m["date"].(int64)
 	if !ok {
@@ -462,8 +578,11 @@ func lastModified(m map[string]any) (string, error) {
 		}
 	}
 
-	// convert to RFC3339
-	return time.Unix(ts, 0).UTC().Format(time.RFC1123), nil
+	// convert to RFC1123
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Unix(ts, 0).In(loc).Format(time.RFC1123), nil
 }
 
 // ElapsedTime returns the duration between createdAt and updatedAt
@@ -145,3 +145,101 @@ func TestUpdateSpec(t *testing.T) {
 		})
 	}
 }
+
+// TestMaglabUpdateSpec tests maglabUpdateSpec function
+func TestMaglabUpdateSpec(t *testing.T) {
+	if srvConfig.Config == nil {
+		srvConfig.Config = &srvConfig.SrvConfig{}
+	}
+	groups := []string{"grp1", "prop1"}
+	access := map[string]any{
+		"$or": []map[string]any{
+			{"group": map[string]any{"$in": groups}},
+			{"proposal": map[string]any{"$in": groups}},
+		},
+	}
+	tests := []struct {
+		name     string
+		ispec    map[string]any
+		groups   []string
+		useCase  string
+		expected map[string]any
+	}{
+		{
+			name:     "Search use-case with allowed groups",
+			ispec:    map[string]any{"group": []string{"grp1", "grp2"}},
+			groups:   groups,
+			useCase:  "search",
+			expected: map[string]any{"group": map[string]any{"$in": []string{"grp1"}}},
+		},
+		{
+			name:     "Search use-case with allowed proposal",
+			ispec:    map[string]any{"proposal": "prop1", "magnet": "SCM1"},
+			groups:   groups,
+			useCase:  "search",
+			expected: map[string]any{"proposal": map[string]any{"$in": []string{"prop1"}}, "magnet": "SCM1"},
+		},
+		{
+			name:    "Search use-case without access attributes",
+			ispec:   map[string]any{"magnet": "SCM1"},
+			groups:  groups,
+			useCase: "search",
+			expected: map[string]any{
+				"magnet": "SCM1",
+				"$or":    access["$or"],
+			},
+		},
+		{
+			name:     "Search use-case with empty spec",
+			ispec:    map[string]any{},
+			groups:   groups,
+			useCase:  "search",
+			expected: access,
+		},
+		{
+			name:    "Search use-case with $or condition",
+			ispec:   map[string]any{"$or": []map[string]any{{"magnet": "SCM1"}, {"magnet": "SCM2"}}},
+			groups:  groups,
+			useCase: "search",
+			expected: map[string]any{"$and": []map[string]any{
+				{"$or": []map[string]any{{"magnet": "SCM1"}, {"magnet": "SCM2"}}},
+				access,
+			}},
+		},
+		{
+			name:    "Search use-case without user groups",
+			ispec:   map[string]any{"group": "grp1"},
+			groups:  nil,
+			useCase: "search",
+			expected: map[string]any{
+				"group": map[string]any{"$in": []string{}},
+			},
+		},
+		{
+			name:    "Filter use-case with multiple conditions",
+			ispec:   map[string]any{"$or": []map[string]any{{"magnet": "SCM1"}, {"group": "grp2"}}},
+			groups:  groups,
+			useCase: "filter",
+			expected: map[string]any{"$and": []map[string]any{
+				{"$or": []map[string]any{{"magnet": "SCM1"}}},
+				access,
+			}},
+		},
+		{
+			name:     "Filter use-case without conditions",
+			ispec:    map[string]any{},
+			groups:   groups,
+			useCase:  "filter",
+			expected: access,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			foxdenUser := services.User{Groups: test.groups}
+			result := maglabUpdateSpec(test.ispec, foxdenUser, test.useCase)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Test %s failed. Expected %v, got %v", test.name, test.expected, result)
+			}
+		})
+	}
+}
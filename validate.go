@@ -0,0 +1,398 @@
+package main
+
+// validate module
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+// The validate module checks user queries before they are sent to Discovery
+// service. Every key is checked against FOXDEN attributes, beamline schemas
+// and QL records, values are checked against key data types and operators
+// against supported MongoDB operators. Problems are reported per key along
+// with "did you mean" suggestions for misspelled keys.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// QueryProblem represents single problem of user query
+type QueryProblem struct {
+	Path        string   `json:"path"` // path of the key within the query, e.g. $or.0.beamline
+	Key         string   `json:"key"`
+	Message     string   `json:"message"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// String returns string representation of query problem
+func (p QueryProblem) String() string {
+	msg := fmt.Sprintf("%s: %s", p.Path, p.Message)
+	if len(p.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", strings.Join(p.Suggestions, ", "))
+	}
+	return msg
+}
+
+// queryKey represents data type and units of query key
+type queryKey struct {
+	Type  string // normalized data type, see queryDataType, empty if unknown
+	Units string
+}
+
+// supported MongoDB operators of query values
+var valueOperators = []string{
+	"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin", "$all",
+	"$regex", "$options", "$exists", "$size", "$not", "$elemMatch",
+}
+
+// supported MongoDB logical operators of query spec
+var logicalOperators = []string{"$and", "$or", "$nor"}
+
+// helper function to collect query keys known to FOXDEN
+func knownQueryKeys() map[string]queryKey {
+	keys := make(map[string]queryKey)
+	for _, attr := range _foxdenAttrs {
+		keys[attr] = queryKey{}
+	}
+	for _, obj := range _smgr.Map {
+		if obj == nil || obj.Schema == nil {
+			continue
+		}
+		for key, rec := range obj.Schema.Map {
+			if k := keys[key]; k.Type == "" {
+				keys[key] = queryKey{Type: queryDataType(rec.Type), Units: rec.Units}
+			}
+		}
+	}
+	for key, rec := range queryKeys() {
+		if k := keys[key]; k.Type == "" {
+			keys[key] = queryKey{Type: queryDataType(rec.DataType), Units: rec.Units}
+		}
+	}
+	if len(keys) > 0 {
+		// keys which are part of every FOXDEN record
+		for _, key := range []string{"_id", "did", "schema"} {
+			if _, ok := keys[key]; !ok {
+				keys[key] = queryKey{}
+			}
+		}
+		// keys of other FOXDEN services used by federated search
+		for _, skeys := range _serviceMap {
+			for _, key := range skeys {
+				if _, ok := keys[key]; !ok {
+					keys[key] = queryKey{}
+				}
+			}
+		}
+		if _, ok := keys[userMetaDataKey]; !ok {
+			keys[userMetaDataKey] = queryKey{}
+		}
+	}
+	return keys
+}
+
+// helper function to validate user query, queries which are not valid JSON
+// are not validated here since they are reported by processResults
+func validateQuery(query string) []QueryProblem {
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(query), &spec); err != nil {
+		return nil
+	}
+	keys := knownQueryKeys()
+	if len(keys) == 0 {
+		// without schemas we can't validate the query
+		return nil
+	}
+	return validateSpec("", spec, keys)
+}
+
+// helper function to validate query spec against known keys
+func validateSpec(path string, spec map[string]any, keys map[string]queryKey) []QueryProblem {
+	var problems []QueryProblem
+	// iterate over sorted keys to have stable list of problems
+	var skeys []string
+	for key := range spec {
+		skeys = append(skeys, key)
+	}
+	sort.Strings(skeys)
+	for _, key := range skeys {
+		val := spec[key]
+		kpath := joinKey(path, key)
+		if strings.HasPrefix(key, "$") {
+			if !utils.InList(key, logicalOperators) {
+				problems = append(problems, QueryProblem{Path: kpath, Key: key,
+					Message:     "unsupported logical operator",
+					Suggestions: suggestKeys(key, logicalOperators)})
+				continue
+			}
+			items, ok := val.([]any)
+			if !ok {
+				problems = append(problems, QueryProblem{Path: kpath, Key: key,
+					Message: fmt.Sprintf("operator %s expects list of conditions", key)})
+				continue
+			}
+			for idx, item := range items {
+				ipath := joinKey(kpath, fmt.Sprintf("%d", idx))
+				if cond, ok := item.(map[string]any); ok {
+					problems = append(problems, validateSpec(ipath, cond, keys)...)
+				} else {
+					problems = append(problems, QueryProblem{Path: ipath, Key: key,
+						Message: "condition should be an object"})
+				}
+			}
+			continue
+		}
+		qkey, ok := lookupQueryKey(key, keys)
+		if !ok {
+			var names []string
+			for name := range keys {
+				names = append(names, name)
+			}
+			problems = append(problems, QueryProblem{Path: kpath, Key: key,
+				Message: "unknown key", Suggestions: suggestKeys(key, names)})
+			continue
+		}
+		problems = append(problems, validateValue(kpath, key, qkey, val)...)
+	}
+	return problems
+}
+
+// helper function to find query key, nested keys, e.g. detectors.name, are
+// accepted if their parent is known struct or list key
+func lookupQueryKey(key string, keys map[string]queryKey) (queryKey, bool) {
+	if qkey, ok := keys[key]; ok {
+		return qkey, true
+	}
+	if idx := strings.Index(key, "."); idx > 0 {
+		if parent, ok := keys[key[:idx]]; ok && parent.Type != "string" &&
+			parent.Type != "int" && parent.Type != "float" && parent.Type != "bool" {
+			return queryKey{}, true
+		}
+	}
+	return queryKey{}, false
+}
+
+// helper function to validate value of query key
+func validateValue(path, key string, qkey queryKey, val any) []QueryProblem {
+	var problems []QueryProblem
+	cond, ok := val.(map[string]any)
+	if !ok || !operatorMap(cond) {
+		if msg := checkValueType(qkey, val); msg != "" {
+			problems = append(problems, QueryProblem{Path: path, Key: key, Message: msg})
+		}
+		return problems
+	}
+	var ops []string
+	for op := range cond {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		arg := cond[op]
+		problem := QueryProblem{Path: joinKey(path, op), Key: key}
+		switch op {
+		case "$eq", "$ne":
+			problem.Message = checkValueType(qkey, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			if qkey.Type == "bool" || qkey.Type == "struct" {
+				problem.Message = fmt.Sprintf("operator %s is not supported for %s key", op, qkey.Type)
+			} else {
+				problem.Message = checkValueType(qkey, arg)
+			}
+		case "$in", "$nin", "$all":
+			items, ok := arg.([]any)
+			if !ok {
+				problem.Message = fmt.Sprintf("operator %s expects list of values", op)
+				break
+			}
+			for _, item := range items {
+				if msg := checkValueType(qkey, item); msg != "" {
+					problem.Message = msg
+					break
+				}
+			}
+		case "$regex":
+			if qkey.Type != "" && qkey.Type != "string" && qkey.Type != "list" {
+				problem.Message = fmt.Sprintf("operator %s is not supported for %s key", op, qkey.Type)
+			} else if _, ok := arg.(string); !ok {
+				problem.Message = fmt.Sprintf("operator %s expects string pattern", op)
+			}
+		case "$options":
+			if _, ok := arg.(string); !ok {
+				problem.Message = fmt.Sprintf("operator %s expects string value", op)
+			}
+		case "$exists":
+			if _, ok := arg.(bool); !ok {
+				problem.Message = fmt.Sprintf("operator %s expects boolean value", op)
+			}
+		case "$size":
+			if _, ok := arg.(float64); !ok {
+				problem.Message = fmt.Sprintf("operator %s expects integer value", op)
+			} else if qkey.Type != "" && qkey.Type != "list" {
+				problem.Message = fmt.Sprintf("operator %s is not supported for %s key", op, qkey.Type)
+			}
+		case "$not", "$elemMatch":
+			// nested conditions are validated by Discovery service
+		default:
+			problem.Message = "unsupported operator"
+			problem.Suggestions = suggestKeys(op, valueOperators)
+		}
+		if problem.Message != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// helper function to check if all keys of given map are MongoDB operators
+func operatorMap(cond map[string]any) bool {
+	if len(cond) == 0 {
+		return false
+	}
+	for key := range cond {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to check value against data type of query key, it returns
+// problem message or empty string if value is valid
+func checkValueType(qkey queryKey, val any) string {
+	if val == nil {
+		return ""
+	}
+	expect := func() string {
+		msg := fmt.Sprintf("expected %s value, got %v (%s)", qkey.Type, val, jsonType(val))
+		if qkey.Units != "" {
+			msg += fmt.Sprintf(", units %s", qkey.Units)
+		}
+		return msg
+	}
+	switch qkey.Type {
+	case "string":
+		if _, ok := val.(string); !ok {
+			return expect()
+		}
+	case "int":
+		if v, ok := val.(float64); !ok || v != float64(int64(v)) {
+			return expect()
+		}
+	case "float":
+		if _, ok := val.(float64); !ok {
+			return expect()
+		}
+	case "bool":
+		if _, ok := val.(bool); !ok {
+			return expect()
+		}
+	case "list":
+		// list keys can be matched either by single item or by entire list
+		if _, ok := val.(map[string]any); ok {
+			return expect()
+		}
+	}
+	return ""
+}
+
+// helper function to return JSON type name of given value
+func jsonType(val any) string {
+	switch val.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// helper function to suggest closest candidates for misspelled key
+func suggestKeys(key string, candidates []string) []string {
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	lkey := strings.ToLower(key)
+	maxDist := len(key) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	for _, name := range candidates {
+		lname := strings.ToLower(name)
+		dist := editDistance(lkey, lname)
+		if dist > maxDist && !(len(lkey) > 2 && strings.Contains(lname, lkey)) {
+			continue
+		}
+		matches = append(matches, match{name: name, dist: dist})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist == matches[j].dist {
+			return matches[i].name < matches[j].name
+		}
+		return matches[i].dist < matches[j].dist
+	})
+	var out []string
+	for idx, m := range matches {
+		if idx == 3 {
+			break
+		}
+		out = append(out, m.name)
+	}
+	return out
+}
+
+// helper function to compute Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// helper function to report query problems to the user
+func queryProblemsError(c *gin.Context, query string, problems []QueryProblem) {
+	if c.Request.Header.Get("Accept") == "application/json" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "invalid query",
+			"query":    query,
+			"problems": problems,
+			"code":     http.StatusBadRequest,
+		})
+		return
+	}
+	tmpl := server.MakeTmpl(StaticFs, "Data")
+	tmpl["Base"] = srvConfig.Config.Frontend.WebServer.Base
+	tmpl["Query"] = query
+	tmpl["Problems"] = problems
+	page := server.TmplPage(StaticFs, "query_error.tmpl", tmpl)
+	c.Data(http.StatusBadRequest, "text/html; charset=utf-8", []byte(header()+page+footer()))
+}
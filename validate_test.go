@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestValidateSpec tests schema-aware validation of query spec
+func TestValidateSpec(t *testing.T) {
+	keys := map[string]queryKey{
+		"did":         {},
+		"beamline":    {Type: "list"},
+		"cycle":       {Type: "string"},
+		"beam_energy": {Type: "float", Units: "keV"},
+		"scan_number": {Type: "int"},
+		"in_situ":     {Type: "bool"},
+		"detectors":   {Type: "struct"},
+	}
+	tests := []struct {
+		name     string
+		query    string
+		expected []QueryProblem
+	}{
+		{name: "Valid query", query: `{"beamline":"3a","beam_energy":{"$gt":40},"detectors.name":"eiger"}`},
+		{name: "Valid logical query", query: `{"$or":[{"cycle":"2024-1"},{"scan_number":{"$in":[1,2]}}]}`},
+		{
+			name:  "Misspelled key",
+			query: `{"beamlne":"3a"}`,
+			expected: []QueryProblem{{Path: "beamlne", Key: "beamlne", Message: "unknown key",
+				Suggestions: []string{"beamline"}}},
+		},
+		{
+			name:  "Wrong value type",
+			query: `{"beam_energy":"high"}`,
+			expected: []QueryProblem{{Path: "beam_energy", Key: "beam_energy",
+				Message: "expected float value, got high (string), units keV"}},
+		},
+		{
+			name:  "Not integer",
+			query: `{"$and":[{"scan_number":1.5}]}`,
+			expected: []QueryProblem{{Path: "$and.0.scan_number", Key: "scan_number",
+				Message: "expected int value, got 1.5 (number)"}},
+		},
+		{
+			name:  "Operators",
+			query: `{"in_situ":{"$gt":true},"cycle":{"$regx":"2024"}}`,
+			expected: []QueryProblem{
+				{Path: "cycle.$regx", Key: "cycle", Message: "unsupported operator", Suggestions: []string{"$regex"}},
+				{Path: "in_situ.$gt", Key: "in_situ", Message: "operator $gt is not supported for bool key"},
+			},
+		},
+		{
+			name:     "Logical operator",
+			query:    `{"$orr":[]}`,
+			expected: []QueryProblem{{Path: "$orr", Key: "$orr", Message: "unsupported logical operator", Suggestions: []string{"$or", "$nor"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec map[string]any
+			if err := json.Unmarshal([]byte(tt.query), &spec); err != nil {
+				t.Fatal(err)
+			}
+			problems := validateSpec("", spec, keys)
+			if !reflect.DeepEqual(problems, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, problems)
+			}
+		})
+	}
+}
+
+// TestSuggestKeys tests "did you mean" suggestions
+func TestSuggestKeys(t *testing.T) {
+	candidates := []string{"sample_name", "sample_common_name", "beamline", "btr"}
+	tests := []struct {
+		key      string
+		expected []string
+	}{
+		{key: "sampel_name", expected: []string{"sample_name"}},
+		{key: "BTR", expected: []string{"btr"}},
+		{key: "common_name", expected: []string{"sample_common_name"}},
+		{key: "xyz", expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if out := suggestKeys(tt.key, candidates); !reflect.DeepEqual(out, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, out)
+			}
+		})
+	}
+}